@@ -0,0 +1,320 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/farrjere/kube_watcher/kube"
+	"github.com/farrjere/kube_watcher/metrics"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+)
+
+// CurrentContext in a path uses the context the server was started with
+const CurrentContext = "current"
+
+var errBadRequest = errors.New("bad request")
+
+/*
+Server is the desktop app's operations as a JSON API. Every request names its cluster, namespace
+and deployment in the path so nothing is kept between requests:
+
+	GET /api/v1/contexts
+	GET /api/v1/clusters/{context}/namespaces
+	GET /api/v1/clusters/{context}/namespaces/{namespace}/deployments
+	GET /api/v1/clusters/{context}/namespaces/{namespace}/deployments/{deployment}/pods
+	GET .../deployments/{deployment}/search?query=&container=&since=&limit=&context_lines=&aggregate=&memory_above=
+	GET .../deployments/{deployment}/logs?format=&lines=
+	GET .../deployments/{deployment}/stream?container=&filter=&since=
+
+since is a duration like 1h or an RFC3339 time, logs returns a .tar.gz bundle like dl save --bundle
+and stream follows the logs as server-sent events.
+Errors are {"error": "..."} with a status matching the kube error, e.g. 404 for kube.ErrNotFound
+*/
+type Server struct {
+	flags func() *genericclioptions.ConfigFlags
+	// newClient connects to a cluster for a single request, replaced in tests
+	newClient func(cluster string, namespace string) (*kube.KubeClient, error)
+	streams   *streams
+	// streamBuffer is how many lines a slow stream client can fall behind before lines are dropped
+	streamBuffer int
+	heartbeat    time.Duration
+	metrics      *metrics.Metrics
+	redactor     *kube.Redactor
+}
+
+// NewServer connects with a fresh copy of flags for every request, the path picks the context and namespace
+func NewServer(flags func() *genericclioptions.ConfigFlags) *Server {
+	s := &Server{flags: flags, streams: newStreams(), streamBuffer: 1000, heartbeat: 15 * time.Second}
+	s.newClient = s.connect
+	return s
+}
+
+// SetMetrics records the kubernetes api errors, search latency and streams of the requests to m
+func (s *Server) SetMetrics(m *metrics.Metrics) {
+	s.metrics = m
+}
+
+// SetRedactor redacts the logs, events and streams served from now on
+func (s *Server) SetRedactor(r *kube.Redactor) {
+	s.redactor = r
+}
+
+func (s *Server) connect(cluster string, namespace string) (*kube.KubeClient, error) {
+	flags := s.flags()
+	if cluster != CurrentContext {
+		flags.Context = &cluster
+	}
+	if namespace != "" {
+		flags.Namespace = &namespace
+	}
+	kc, err := kube.NewKubeClientFromFlags(flags)
+	if err != nil {
+		return nil, err
+	}
+	if cluster != CurrentContext {
+		kc.SetCluster(cluster)
+	}
+	kc.SetRedactor(s.redactor)
+	return kc, nil
+}
+
+type SearchResponse struct {
+	Results    []kube.SearchResult `json:"results"`
+	Aggregates []kube.Aggregate    `json:"aggregates,omitempty"`
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSON(w, http.StatusMethodNotAllowed, errorBody("only GET is supported"))
+		return
+	}
+	path, ok := strings.CutPrefix(r.URL.Path, "/api/v1/")
+	if !ok {
+		writeError(w, fmt.Errorf("%w: %v", kube.ErrNotFound, r.URL.Path))
+		return
+	}
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	switch {
+	case len(parts) == 1 && parts[0] == "contexts":
+		writeJSON(w, http.StatusOK, kube.AvailableContexts(s.flags()))
+	case len(parts) == 3 && parts[0] == "clusters" && parts[2] == "namespaces":
+		s.namespaces(w, r, parts[1])
+	case len(parts) == 5 && parts[0] == "clusters" && parts[2] == "namespaces" && parts[4] == "deployments":
+		s.deployments(w, r, parts[1], parts[3])
+	case len(parts) == 7 && parts[0] == "clusters" && parts[2] == "namespaces" && parts[4] == "deployments":
+		s.deployment(w, r, parts[1], parts[3], parts[5], parts[6])
+	default:
+		writeError(w, fmt.Errorf("%w: %v", kube.ErrNotFound, r.URL.Path))
+	}
+}
+
+func (s *Server) namespaces(w http.ResponseWriter, r *http.Request, cluster string) {
+	kc, err := s.newClient(cluster, "")
+	if err != nil {
+		s.kubeError(w, err)
+		return
+	}
+	namespaces, err := kc.ListNamespaces(r.Context())
+	if err != nil {
+		s.kubeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, namespaces)
+}
+
+func (s *Server) deployments(w http.ResponseWriter, r *http.Request, cluster string, namespace string) {
+	kc, err := s.newClient(cluster, namespace)
+	if err != nil {
+		s.kubeError(w, err)
+		return
+	}
+	deployments, err := kc.ListDeployments(r.Context())
+	if err != nil {
+		s.kubeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, deployments)
+}
+
+func (s *Server) deployment(w http.ResponseWriter, r *http.Request, cluster string, namespace string, name string, action string) {
+	if action != "pods" && action != "search" && action != "logs" && action != "stream" {
+		writeError(w, fmt.Errorf("%w: %v", kube.ErrNotFound, r.URL.Path))
+		return
+	}
+	kc, err := s.newClient(cluster, namespace)
+	if err != nil {
+		s.kubeError(w, err)
+		return
+	}
+	if _, err = kc.GetDeployment(r.Context(), name); err != nil {
+		s.kubeError(w, err)
+		return
+	}
+	if action == "stream" {
+		s.stream(w, r, kc, name)
+		return
+	}
+	dl := kube.NewDeploymentWatcher(name, kc, r.Context())
+	switch action {
+	case "pods":
+		writeJSON(w, http.StatusOK, dl.PodStatuses())
+	case "search":
+		s.search(w, r, dl)
+	case "logs":
+		s.logs(w, r, dl)
+	}
+}
+
+func (s *Server) search(w http.ResponseWriter, r *http.Request, dl *kube.DeploymentWatcher) {
+	params, aggregate, err := searchParameters(r, time.Now())
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	started := time.Now()
+	response := SearchResponse{Results: dl.SearchLogs(params)}
+	s.metrics.ObserveSearch("api", time.Since(started))
+	if aggregate != "" {
+		response.Aggregates, err = kube.AggregateResults(response.Results, params.Query, aggregate)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+	}
+	writeJSON(w, http.StatusOK, response)
+}
+
+func searchParameters(r *http.Request, now time.Time) (kube.SearchParameters, string, error) {
+	q := r.URL.Query()
+	params := kube.SearchParameters{Query: q.Get("query"), Container: q.Get("container")}
+	if params.Query == "" {
+		return params, "", fmt.Errorf("%w: query is required", errBadRequest)
+	}
+	params.AllContainers = params.Container == ""
+	var err error
+	if params.Since, err = sinceParam(q.Get("since"), now); err != nil {
+		return params, "", err
+	}
+	if params.Limit, err = intParam(q.Get("limit")); err != nil {
+		return params, "", fmt.Errorf("%w: limit: %v", errBadRequest, err)
+	}
+	contextLines, err := intParam(q.Get("context_lines"))
+	if err != nil {
+		return params, "", fmt.Errorf("%w: context_lines: %v", errBadRequest, err)
+	}
+	params.ContextLines = int(contextLines)
+	if memoryAbove := q.Get("memory_above"); memoryAbove != "" {
+		if params.MemoryAbovePercent, err = strconv.ParseFloat(memoryAbove, 64); err != nil {
+			return params, "", fmt.Errorf("%w: memory_above: %v", errBadRequest, err)
+		}
+	}
+	aggregate := q.Get("aggregate")
+	if !kube.ValidAggregation(aggregate) {
+		return params, "", fmt.Errorf("%w: aggregate must be pod or message", errBadRequest)
+	}
+	return params, aggregate, nil
+}
+
+func sinceParam(since string, now time.Time) (time.Time, error) {
+	if since == "" {
+		return time.Time{}, nil
+	}
+	if d, err := time.ParseDuration(since); err == nil {
+		return now.Add(-d), nil
+	}
+	if t, err := time.Parse(time.RFC3339, since); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("%w: since must be a duration like 1h or an RFC3339 time", errBadRequest)
+}
+
+func intParam(value string) (int64, error) {
+	if value == "" {
+		return 0, nil
+	}
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err == nil && n < 0 {
+		err = fmt.Errorf("can't be negative")
+	}
+	return n, err
+}
+
+// logs sends every pod's logs as a bundle, written to a temp dir first since the export is made of many files
+func (s *Server) logs(w http.ResponseWriter, r *http.Request, dl *kube.DeploymentWatcher) {
+	q := r.URL.Query()
+	format := q.Get("format")
+	if format == "" {
+		format = kube.FormatText
+	}
+	if !kube.ValidExportFormat(format) {
+		writeError(w, fmt.Errorf("%w: unknown format %q", errBadRequest, format))
+		return
+	}
+	lines, err := intParam(q.Get("lines"))
+	if err != nil {
+		writeError(w, fmt.Errorf("%w: lines: %v", errBadRequest, err))
+		return
+	}
+	dir, err := os.MkdirTemp("", "kube_watcher-api-")
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	defer os.RemoveAll(dir)
+	written, err := dl.Export(dir, lines, kube.ExportOptions{Format: format, Bundle: true})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	f, err := os.Open(written)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	defer f.Close()
+	w.Header().Set("Content-Type", "application/gzip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filepath.Base(written)))
+	io.Copy(w, f)
+}
+
+func errorBody(message string) map[string]string {
+	return map[string]string{"error": message}
+}
+
+// kubeError is writeError for the errors from talking to the cluster, which are counted in the metrics
+func (s *Server) kubeError(w http.ResponseWriter, err error) {
+	s.metrics.APIError(err)
+	writeError(w, err)
+}
+
+// writeError picks the status from the typed errors the kube package returns
+func writeError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, errBadRequest):
+		status = http.StatusBadRequest
+	case errors.Is(err, kube.ErrNotFound), errors.Is(err, kube.ErrUnknownContext):
+		status = http.StatusNotFound
+	case errors.Is(err, kube.ErrForbidden):
+		status = http.StatusForbidden
+	case errors.Is(err, kube.ErrUnauthorized):
+		status = http.StatusUnauthorized
+	case errors.Is(err, kube.ErrUnavailable):
+		status = http.StatusServiceUnavailable
+	}
+	writeJSON(w, status, errorBody(err.Error()))
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,183 @@
+package api
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/farrjere/kube_watcher/kube"
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+const testKubeconfig = `apiVersion: v1
+kind: Config
+current-context: dev
+clusters:
+- name: dev
+  cluster:
+    server: https://dev.example.com
+contexts:
+- name: dev
+  context:
+    cluster: dev
+    user: me
+- name: prod
+  context:
+    cluster: dev
+    user: me
+users:
+- name: me
+  user:
+    token: abc
+`
+
+func testPod(name string) *v1.Pod {
+	return &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "web", Labels: map[string]string{"app": "web"}},
+		Spec:       v1.PodSpec{Containers: []v1.Container{{Name: "main"}}},
+		Status:     v1.PodStatus{Phase: v1.PodRunning},
+	}
+}
+
+func testObjects() []runtime.Object {
+	labels := map[string]string{"app": "web"}
+	return []runtime.Object{
+		&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "web"}},
+		&appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Name: "api", Namespace: "web", Labels: labels},
+			Spec:       appsv1.DeploymentSpec{Selector: &metav1.LabelSelector{MatchLabels: labels}},
+		},
+		testPod("api-1"),
+	}
+}
+
+func newTestServer(t *testing.T) (*httptest.Server, *Server) {
+	path := filepath.Join(t.TempDir(), "config")
+	os.WriteFile(path, []byte(testKubeconfig), 0o600)
+	objects := testObjects()
+
+	s := NewServer(func() *genericclioptions.ConfigFlags { return kube.NewConfigFlags(path, "") })
+	s.newClient = func(cluster string, namespace string) (*kube.KubeClient, error) {
+		if cluster != CurrentContext && cluster != "prod" {
+			return nil, kube.ErrUnknownContext
+		}
+		clientset := fake.NewSimpleClientset(objects...)
+		if cluster == "prod" {
+			clientset.PrependReactor("list", "namespaces", func(action k8stesting.Action) (bool, runtime.Object, error) {
+				return true, nil, apierrors.NewForbidden(schema.GroupResource{Resource: "namespaces"}, "", errors.New("no access"))
+			})
+		}
+		kc := kube.NewKubeClientFromClientset(clientset)
+		kc.SetNamespace(namespace)
+		return kc, nil
+	}
+	server := httptest.NewServer(s)
+	t.Cleanup(server.Close)
+	return server, s
+}
+
+func get(t *testing.T, server *httptest.Server, path string, v any) int {
+	resp, err := http.Get(server.URL + path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if v != nil {
+		if err = json.NewDecoder(resp.Body).Decode(v); err != nil {
+			t.Fatalf("%v: %v", path, err)
+		}
+	}
+	return resp.StatusCode
+}
+
+func TestServer(t *testing.T) {
+	server, _ := newTestServer(t)
+	var names []string
+	if status := get(t, server, "/api/v1/contexts", &names); status != http.StatusOK || strings.Join(names, ",") != "dev,prod" {
+		t.Errorf("unexpected contexts %v %v", status, names)
+	}
+	if status := get(t, server, "/api/v1/clusters/current/namespaces", &names); status != http.StatusOK || strings.Join(names, ",") != "web" {
+		t.Errorf("unexpected namespaces %v %v", status, names)
+	}
+	if status := get(t, server, "/api/v1/clusters/current/namespaces/web/deployments", &names); status != http.StatusOK || strings.Join(names, ",") != "api" {
+		t.Errorf("unexpected deployments %v %v", status, names)
+	}
+
+	var pods []kube.Pod
+	if status := get(t, server, "/api/v1/clusters/current/namespaces/web/deployments/api/pods", &pods); status != http.StatusOK || len(pods) != 1 || pods[0].Name != "api-1" {
+		t.Errorf("unexpected pods %v %+v", status, pods)
+	}
+
+	var search SearchResponse
+	status := get(t, server, "/api/v1/clusters/current/namespaces/web/deployments/api/search?query=fake&since=1h&aggregate=pod", &search)
+	if status != http.StatusOK || len(search.Results) != 1 || len(search.Aggregates) != 1 || search.Aggregates[0].Key != "api-1" {
+		t.Errorf("unexpected search %v %+v", status, search)
+	}
+}
+
+func TestServerErrors(t *testing.T) {
+	server, _ := newTestServer(t)
+	cases := map[string]int{
+		"/api/v1/clusters/missing/namespaces":                                                    http.StatusNotFound,
+		"/api/v1/clusters/prod/namespaces":                                                       http.StatusForbidden,
+		"/api/v1/clusters/current/namespaces/web/deployments/missing/pods":                       http.StatusNotFound,
+		"/api/v1/clusters/current/namespaces/web/deployments/api/search":                         http.StatusBadRequest,
+		"/api/v1/clusters/current/namespaces/web/deployments/api/search?query=x&since=yesterday": http.StatusBadRequest,
+		"/api/v1/clusters/current/namespaces/web/deployments/api/logs?format=xml":                http.StatusBadRequest,
+		"/api/v1/clusters/current/namespaces/web/deployments/api/restart":                        http.StatusNotFound,
+		"/other": http.StatusNotFound,
+	}
+	for path, expected := range cases {
+		body := map[string]string{}
+		if status := get(t, server, path, &body); status != expected || body["error"] == "" {
+			t.Errorf("%v: expected %v with an error got %v %v", path, expected, status, body)
+		}
+	}
+	resp, err := http.Post(server.URL+"/api/v1/contexts", "application/json", nil)
+	if err != nil || resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("expected only GET to be allowed got %v %v", resp.StatusCode, err)
+	}
+}
+
+func TestServerLogs(t *testing.T) {
+	server, _ := newTestServer(t)
+	resp, err := http.Get(server.URL + "/api/v1/clusters/current/namespaces/web/deployments/api/logs?format=jsonl&lines=10")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || !strings.Contains(resp.Header.Get("Content-Disposition"), ".tar.gz") {
+		t.Fatalf("unexpected response %v %v", resp.StatusCode, resp.Header)
+	}
+	gz, err := gzip.NewReader(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := tar.NewReader(gz)
+	var files []string
+	for {
+		h, err := tr.Next()
+		if err != nil {
+			break
+		}
+		files = append(files, h.Name)
+	}
+	if !strings.Contains(strings.Join(files, ","), "api-1.jsonl") {
+		t.Errorf("expected the pod's logs in the bundle got %v", files)
+	}
+}
@@ -0,0 +1,219 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/farrjere/kube_watcher/kube"
+)
+
+// streams shares one watcher per deployment and container between every client following it,
+// the watcher is stopped when its last client disconnects
+type streams struct {
+	mu        sync.Mutex
+	upstreams map[string]*upstream
+}
+
+type upstream struct {
+	cancel      context.CancelFunc
+	subscribers map[*subscriber]bool
+}
+
+// subscriber is one client's bounded buffer, lines arriving while it is full are counted rather than
+// holding up the other clients
+type subscriber struct {
+	entries chan kube.LogEntry
+	keep    func(kube.LogEntry) bool
+	mu      sync.Mutex
+	dropped int
+}
+
+func newStreams() *streams {
+	return &streams{upstreams: make(map[string]*upstream)}
+}
+
+func newSubscriber(buffer int, keep func(kube.LogEntry) bool) *subscriber {
+	return &subscriber{entries: make(chan kube.LogEntry, buffer), keep: keep}
+}
+
+func (s *subscriber) send(e kube.LogEntry) {
+	if !s.keep(e) {
+		return
+	}
+	select {
+	case s.entries <- e:
+	default:
+		s.mu.Lock()
+		s.dropped++
+		s.mu.Unlock()
+	}
+}
+
+// takeDropped is the number of lines dropped since it was last called
+func (s *subscriber) takeDropped() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	dropped := s.dropped
+	s.dropped = 0
+	return dropped
+}
+
+// subscribe adds sub to the upstream for key, starting it with start if nobody is following key yet.
+// start talks to the cluster so it runs without the lock, clients joining meanwhile wait for its lines
+// like everyone else
+func (s *streams) subscribe(key string, sub *subscriber, start func(ctx context.Context) <-chan kube.LogEntry) {
+	s.mu.Lock()
+	if u, ok := s.upstreams[key]; ok {
+		u.subscribers[sub] = true
+		s.mu.Unlock()
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	u := &upstream{cancel: cancel, subscribers: map[*subscriber]bool{sub: true}}
+	s.upstreams[key] = u
+	s.mu.Unlock()
+
+	entries := start(ctx)
+	go func() {
+		for e := range entries {
+			s.mu.Lock()
+			for sub := range u.subscribers {
+				sub.send(e)
+			}
+			s.mu.Unlock()
+		}
+		// the watcher only ends early if it couldn't keep going, let its clients know
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if s.upstreams[key] == u {
+			delete(s.upstreams, key)
+		}
+		for sub := range u.subscribers {
+			close(sub.entries)
+		}
+		u.subscribers = nil
+	}()
+}
+
+func (s *streams) unsubscribe(key string, sub *subscriber) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u, ok := s.upstreams[key]
+	if !ok || !u.subscribers[sub] {
+		return
+	}
+	delete(u.subscribers, sub)
+	if len(u.subscribers) == 0 {
+		delete(s.upstreams, key)
+		u.cancel()
+	}
+}
+
+// close stops every watcher, ending the streams of their clients
+func (s *streams) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, u := range s.upstreams {
+		u.cancel()
+	}
+}
+
+// following is the number of deployments and containers with a watcher running
+func (s *streams) following() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.upstreams)
+}
+
+// CloseStreams ends every stream, for shutting down without waiting on clients that never disconnect
+func (s *Server) CloseStreams() {
+	s.streams.close()
+}
+
+// streamFilter keeps the lines containing filter, ignoring case, logged at or after since.
+// Lines without a timestamp are always kept
+func streamFilter(filter string, since time.Time) func(kube.LogEntry) bool {
+	filter = strings.ToLower(filter)
+	return func(e kube.LogEntry) bool {
+		if !since.IsZero() && !e.Time.IsZero() && e.Time.Before(since) {
+			return false
+		}
+		return filter == "" || strings.Contains(strings.ToLower(e.Message), filter)
+	}
+}
+
+// stream sends the deployment's merged logs as server-sent events: log events carrying a LogEntry,
+// dropped events with the number of lines the client was too slow for, and heartbeat comments.
+// Each pod starts from its last 100 lines, since drops the ones logged before it, so since only ever narrows
+// what is streamed. A client joining a deployment others are already following shares their watcher and
+// gets no backlog, only the lines from then on
+func (s *Server) stream(w http.ResponseWriter, r *http.Request, kc *kube.KubeClient, deployment string) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, fmt.Errorf("streaming isn't supported by this connection"))
+		return
+	}
+	q := r.URL.Query()
+	since, err := sinceParam(q.Get("since"), time.Now())
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	container := q.Get("container")
+	key := strings.Join([]string{kc.Cluster(), kc.Namespace(), deployment, container}, "/")
+	sub := newSubscriber(s.streamBuffer, streamFilter(q.Get("filter"), since))
+	s.streams.subscribe(key, sub, func(ctx context.Context) <-chan kube.LogEntry {
+		dl := kube.NewDeploymentWatcher(deployment, kc, ctx)
+		s.metrics.Watch(key, dl)
+		go func() {
+			<-ctx.Done()
+			s.metrics.Unwatch(key, dl)
+		}()
+		return dl.StreamContainer(container)
+	})
+	defer s.streams.unsubscribe(key, sub)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(s.heartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-heartbeat.C:
+			writeDropped(w, sub)
+			fmt.Fprint(w, ": heartbeat\n\n")
+		case e, ok := <-sub.entries:
+			if !ok {
+				return
+			}
+			writeDropped(w, sub)
+			writeEvent(w, "log", e)
+		}
+		flusher.Flush()
+	}
+}
+
+func writeDropped(w http.ResponseWriter, sub *subscriber) {
+	if dropped := sub.takeDropped(); dropped > 0 {
+		writeEvent(w, "dropped", map[string]int{"dropped": dropped})
+	}
+}
+
+func writeEvent(w http.ResponseWriter, event string, v any) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return
+	}
+	fmt.Fprintf(w, "event: %v\ndata: %s\n\n", event, data)
+}
@@ -0,0 +1,127 @@
+package api
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/farrjere/kube_watcher/kube"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestSubscriberDropsWhenFull(t *testing.T) {
+	since := time.Date(2023, 9, 1, 12, 0, 0, 0, time.UTC)
+	sub := newSubscriber(2, streamFilter("error", since))
+	for _, m := range []string{"error a", "ok", "error b", "ERROR c", "error d"} {
+		sub.send(kube.LogEntry{Message: m, Time: since})
+	}
+	sub.send(kube.LogEntry{Message: "error too old", Time: since.Add(-time.Second)})
+	if dropped := sub.takeDropped(); dropped != 2 || len(sub.entries) != 2 {
+		t.Errorf("expected 2 buffered and 2 dropped got %v %v", len(sub.entries), dropped)
+	}
+	if dropped := sub.takeDropped(); dropped != 0 {
+		t.Errorf("expected the count reset once taken got %v", dropped)
+	}
+}
+
+func TestSubscribeStartsWithoutTheLock(t *testing.T) {
+	s := newStreams()
+	defer s.close()
+	starting := make(chan struct{})
+	release := make(chan struct{})
+	go s.subscribe("slow", newSubscriber(1, streamFilter("", time.Time{})), func(ctx context.Context) <-chan kube.LogEntry {
+		close(starting)
+		<-release
+		return make(chan kube.LogEntry)
+	})
+	<-starting
+	started := make(chan struct{})
+	go func() {
+		s.subscribe("slow", newSubscriber(1, streamFilter("", time.Time{})), nil)
+		s.subscribe("fast", newSubscriber(1, streamFilter("", time.Time{})), func(ctx context.Context) <-chan kube.LogEntry {
+			return make(chan kube.LogEntry)
+		})
+		close(started)
+	}()
+	select {
+	case <-started:
+	case <-time.After(time.Second):
+		t.Errorf("expected other clients not to wait on a starting upstream")
+	}
+	if s.following() != 2 {
+		t.Errorf("expected both upstreams running got %v", s.following())
+	}
+	close(release)
+}
+
+func TestStream(t *testing.T) {
+	clientset := fake.NewSimpleClientset(testObjects()...)
+	podWatch := watch.NewFake()
+	clientset.PrependWatchReactor("pods", k8stesting.DefaultWatchReactor(podWatch, nil))
+	s := NewServer(func() *genericclioptions.ConfigFlags { return kube.NewConfigFlags("", "") })
+	s.newClient = func(cluster string, namespace string) (*kube.KubeClient, error) {
+		kc := kube.NewKubeClientFromClientset(clientset)
+		kc.SetNamespace(namespace)
+		return kc, nil
+	}
+	s.heartbeat = 20 * time.Millisecond
+	server := httptest.NewServer(s)
+	defer server.Close()
+	url := server.URL + "/api/v1/clusters/current/namespaces/web/deployments/api/stream?filter=FAKE"
+
+	follow := func() (*bufio.Reader, func()) {
+		resp, err := http.Get(url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/event-stream" {
+			t.Fatalf("unexpected response %v %v", resp.StatusCode, resp.Header)
+		}
+		return bufio.NewReader(resp.Body), func() { resp.Body.Close() }
+	}
+	waitFor := func(r *bufio.Reader, prefix string) string {
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				t.Fatalf("stream ended waiting for %q: %v", prefix, err)
+			}
+			if strings.HasPrefix(line, prefix) {
+				return line
+			}
+		}
+	}
+
+	first, closeFirst := follow()
+	second, closeSecond := follow()
+	// a pod started after both clients connected reaches both of them
+	podWatch.Add(testPod("api-2"))
+	for _, r := range []*bufio.Reader{first, second} {
+		for data := ""; !strings.Contains(data, `"pod":"api-2"`); {
+			data = waitFor(r, "data: ")
+			if !strings.Contains(data, `"message":"fake logs"`) {
+				t.Errorf("unexpected log event %v", data)
+			}
+		}
+	}
+	if following := s.streams.following(); following != 1 {
+		t.Errorf("expected both clients to share one watcher got %v", following)
+	}
+	waitFor(second, ": heartbeat")
+
+	closeFirst()
+	closeSecond()
+	deadline := time.Now().Add(5 * time.Second)
+	for s.streams.following() != 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the watcher stopped once both clients left")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,15 @@
+package main
+
+import (
+	"github.com/farrjere/kube_watcher/commands"
+	"log"
+	"os"
+)
+
+// kubectl runs this as kubectl watcher ... once it is on the PATH
+func main() {
+	app := commands.NewApp("kubectl-watcher")
+	if err := app.Run(os.Args); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,62 @@
+package commands
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/farrjere/kube_watcher/api"
+	"github.com/farrjere/kube_watcher/metrics"
+	"github.com/urfave/cli/v2"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+)
+
+// serveAPI runs the json api until SIGTERM or ctrl-c, letting requests in flight finish
+func serveAPI(cCtx *cli.Context) error {
+	handler := api.NewServer(func() *genericclioptions.ConfigFlags { return configFlags(cCtx) })
+	handler.SetRedactor(redactor(cCtx))
+	mux := http.NewServeMux()
+	mux.Handle("/", handler)
+	if cCtx.Bool("metrics") {
+		m := metrics.New()
+		handler.SetMetrics(m)
+		mux.Handle("/metrics", m.Handler())
+	}
+	server := &http.Server{Addr: cCtx.String("listen"), Handler: mux}
+	server.RegisterOnShutdown(handler.CloseStreams)
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		<-ctx.Done()
+		shutdown, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		server.Shutdown(shutdown)
+	}()
+	fmt.Printf("Serving the api on %v\n", server.Addr)
+	err := server.ListenAndServe()
+	if !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	// ListenAndServe returns as soon as shutdown starts, wait for the requests in flight
+	<-stopped
+	return nil
+}
+
+func apiCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "api",
+		Usage: "serves namespaces, deployments, pods, search, logs and live log streams as a json api under /api/v1",
+		Flags: []cli.Flag{
+			&cli.StringFlag{Name: "listen", Usage: "the address to listen on", Value: "localhost:8080"},
+			&cli.BoolFlag{Name: "metrics", Usage: "serve prometheus metrics about the watcher at /metrics"},
+		},
+		Action: serveAPI,
+	}
+}
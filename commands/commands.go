@@ -0,0 +1,553 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"github.com/farrjere/kube_watcher/config"
+	"github.com/farrjere/kube_watcher/kube"
+	"github.com/farrjere/kube_watcher/notify"
+	"github.com/farrjere/kube_watcher/sink"
+	"github.com/farrjere/kube_watcher/tui"
+	"github.com/urfave/cli/v2"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+	_ "k8s.io/client-go/plugin/pkg/client/auth"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+)
+
+func streamLogs(cCtx *cli.Context) {
+	ctx := context.Background()
+	kc := newClient(cCtx)
+	deployment := setting(cCtx, "deployment")
+	sinks, err := sink.ParseAllWith(sinkSpecs(cCtx), sink.ParseOptions{ColorScheme: setting(cCtx, "colors")})
+	if err != nil {
+		fmt.Println("unable to setup sinks")
+		panic(err)
+	}
+	dl := kube.NewDeploymentWatcher(deployment, kc, ctx)
+	setStreamOptions(cCtx, dl)
+	go reportDrops(dl, 5*time.Second)
+	interval := cCtx.Duration("metrics-interval")
+	if interval > 0 {
+		go dl.PollMetrics(interval, func(sample kube.MetricsSample) {
+			fmt.Fprintln(os.Stderr, "[metrics]", kube.FormatMetricsSample(sample))
+		})
+	}
+	err = dl.StreamTo(sinks...)
+	if err != nil {
+		fmt.Println(err)
+	}
+}
+
+func captureLogs(cCtx *cli.Context) {
+	// stopping the capture ends the stream so the pod files are flushed and closed
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
+	kc := newClient(cCtx)
+	deployment := setting(cCtx, "deployment")
+	path := cCtx.Args().Get(0)
+	if path == "" {
+		path = "."
+	}
+	opts := sink.RotateOptions{
+		MaxSize:      cCtx.Int64("max-size") * 1024 * 1024,
+		Interval:     cCtx.Duration("rotate-every"),
+		Compress:     cCtx.Bool("compress"),
+		MaxTotalSize: cCtx.Int64("max-total") * 1024 * 1024,
+		MaxAge:       cCtx.Duration("max-age"),
+	}
+	podFiles, err := sink.NewPodFiles(path, opts)
+	if err != nil {
+		fmt.Printf("unable to capture logs to %v\n", path)
+		panic(err)
+	}
+	dl := kube.NewDeploymentWatcher(deployment, kc, ctx)
+	setStreamOptions(cCtx, dl)
+	go reportDrops(dl, 5*time.Second)
+	fmt.Printf("Capturing logs for %v to %v\n", deployment, path)
+	err = dl.StreamTo(podFiles)
+	if err != nil {
+		fmt.Println(err)
+	}
+}
+
+func streamFlags() []cli.Flag {
+	return append([]cli.Flag{
+		&cli.IntFlag{Name: "buffer", Usage: "how many lines each pod's stream can fall behind before the overflow policy applies", Value: 10},
+		&cli.StringFlag{Name: "overflow", Usage: "what a full buffer does with new lines: block, drop-oldest or drop-newest", Value: kube.OverflowBlock},
+	}, lineFlags()...)
+}
+
+func lineFlags() []cli.Flag {
+	return []cli.Flag{
+		&cli.IntFlag{Name: "max-line-size", Usage: "the longest line in bytes kept whole", Value: kube.DefaultMaxLineSize},
+		&cli.StringFlag{Name: "long-lines", Usage: "what happens to longer lines: truncate or split", Value: kube.LongLinesTruncate},
+	}
+}
+
+func lineOptions(cCtx *cli.Context) kube.LineOptions {
+	return kube.LineOptions{MaxLineSize: cCtx.Int("max-line-size"), LongLines: cCtx.String("long-lines")}
+}
+
+func setLineOptions(cCtx *cli.Context, dl *kube.DeploymentWatcher) {
+	err := dl.SetLineOptions(lineOptions(cCtx))
+	if err != nil {
+		fmt.Println("unable to setup reading the logs")
+		panic(err)
+	}
+}
+
+func streamOptions(cCtx *cli.Context) kube.SubscribeOptions {
+	return kube.SubscribeOptions{Buffer: cCtx.Int("buffer"), Overflow: cCtx.String("overflow")}
+}
+
+func setStreamOptions(cCtx *cli.Context, dl *kube.DeploymentWatcher) {
+	err := dl.SetStreamOptions(streamOptions(cCtx))
+	if err != nil {
+		fmt.Println("unable to setup the stream")
+		panic(err)
+	}
+	setLineOptions(cCtx, dl)
+}
+
+// reportDrops prints the line counts to stderr whenever more lines have been dropped
+func reportDrops(dl *kube.DeploymentWatcher, interval time.Duration) {
+	var reported int64
+	for range time.Tick(interval) {
+		stats := dl.StreamStats()
+		var dropped int64
+		for _, s := range stats {
+			dropped += s.Dropped
+		}
+		if dropped > reported {
+			reported = dropped
+			fmt.Fprintln(os.Stderr, "[stream]", kube.FormatStreamStats(stats))
+		}
+	}
+}
+
+// setContext switches the profile's context when one is in use, otherwise the kubeconfig's current context.
+// Each command can also be given --context instead
+func setContext(cCtx *cli.Context) {
+	if profile := profileName(cCtx); profile != "" {
+		context := flagValue(cCtx, "context")
+		if context == "" {
+			fmt.Println("a context is required: set_context --context name")
+			return
+		}
+		err := setProfileContext(cCtx, profile, context)
+		if err != nil {
+			fmt.Printf("unable to set context: %v\n", err)
+		}
+		return
+	}
+	flags := configFlags(cCtx)
+	if !cCtx.Bool("save") {
+		fmt.Println("an unsaved context only lasts for one command, pass --context to the command instead")
+		return
+	}
+	err := kube.SaveContext(flags)
+	if err != nil {
+		fmt.Printf("unable to set context: %v\n", err)
+		return
+	}
+	fmt.Printf("Switched to context %v\n", flagValue(cCtx, "context"))
+}
+
+// flagValue is the flag from the innermost command that was given it, so the kubectl flags work both before
+// and after the command, kubectl watcher -n test dl stream or dl stream -n test
+func flagValue(cCtx *cli.Context, name string) string {
+	for _, c := range cCtx.Lineage() {
+		if value := c.String(name); value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+func configFlags(cCtx *cli.Context) *genericclioptions.ConfigFlags {
+	kubeconfig := flagValue(cCtx, "kubeconfig")
+	if kubeconfig == "" {
+		kubeconfig = flagValue(cCtx, "path")
+	}
+	flags := kube.NewConfigFlags(kubeconfig, setting(cCtx, "context"))
+	namespace := setting(cCtx, "namespace")
+	flags.Namespace = &namespace
+	impersonate := cCtx.String("as")
+	flags.Impersonate = &impersonate
+	impersonateGroups := cCtx.StringSlice("as-group")
+	flags.ImpersonateGroup = &impersonateGroups
+	timeout := cCtx.String("request-timeout")
+	if timeout != "" {
+		flags.Timeout = &timeout
+	}
+	return flags
+}
+
+func newClient(cCtx *cli.Context) *kube.KubeClient {
+	kc, err := kube.NewKubeClientFromFlags(configFlags(cCtx))
+	if err != nil {
+		fmt.Println("error loading config")
+		panic(err)
+	}
+	kc.SetRedactor(redactor(cCtx))
+	return kc
+}
+
+// redactor is the config's redaction with --redact and --redact-mode taking precedence, nil when nothing is redacted
+func redactor(cCtx *cli.Context) *kube.Redactor {
+	c := loaded(cCtx).config
+	opts := kube.RedactOptions{}
+	if c.Redaction != nil {
+		opts = *c.Redaction
+	}
+	if cCtx.IsSet("redact") {
+		opts.Detectors = cCtx.StringSlice("redact")
+	}
+	if cCtx.IsSet("redact-mode") {
+		opts.Mode = cCtx.String("redact-mode")
+	}
+	if !opts.Enabled() {
+		return nil
+	}
+	r, err := kube.NewRedactor(opts)
+	if err != nil {
+		fmt.Println("unable to setup redaction")
+		panic(err)
+	}
+	return r
+}
+
+// globalFlags are kubectl's, so the binary behaves as a kubectl plugin
+func globalFlags() []cli.Flag {
+	return []cli.Flag{
+		&cli.StringFlag{Name: "kubeconfig", Usage: "path to the kubeconfig file to use"},
+		&cli.StringFlag{Name: "context", Usage: "the kubeconfig context to use"},
+		&cli.StringFlag{Name: "namespace", Aliases: []string{"n"}, Usage: "the namespace to use, defaults to the context's"},
+		&cli.StringFlag{Name: "as", Usage: "username to impersonate for the operation"},
+		&cli.StringSliceFlag{Name: "as-group", Usage: "group to impersonate for the operation, can be repeated"},
+		&cli.StringFlag{Name: "config", Usage: "the kube-watcher config file holding the profiles", Value: config.DefaultPath()},
+		&cli.StringFlag{Name: "profile", Usage: "the profile to take the context, namespace, deployment and other defaults from", EnvVars: []string{"KUBE_WATCHER_PROFILE"}},
+		&cli.StringFlag{Name: "request-timeout", Usage: "how long to wait for a single request before giving up, e.g. 30s, 0 to wait forever", Value: "0"},
+		&cli.StringSliceFlag{Name: "redact", Usage: "redact what these detectors find from every log line and event, can be repeated: all, " + strings.Join(kube.DetectorNames(), ", ")},
+		&cli.StringFlag{Name: "redact-mode", Usage: "replace what is redacted with a mask or a hash of it, hashes are keyed by the config's hash_key or a random key per run", Value: kube.RedactMask},
+	}
+}
+
+func saveDeploymentLogs(cCtx *cli.Context) {
+	ctx := context.Background()
+	kc := newClient(cCtx)
+	deployment := setting(cCtx, "deployment")
+	lines := cCtx.Int64("lines")
+	dl := kube.NewDeploymentWatcher(deployment, kc, ctx)
+	setLineOptions(cCtx, dl)
+	path := cCtx.Args().Get(0)
+	if path == "" {
+		path = "."
+	}
+
+	written, err := dl.Export(path, lines, exportOptions(cCtx))
+	if err != nil {
+		fmt.Printf("unable to save logs for %v: %v\n", deployment, err)
+		return
+	}
+	fmt.Printf("Output logs for %v to %v", deployment, written)
+}
+
+func bundleDiagnostics(cCtx *cli.Context) {
+	ctx := context.Background()
+	kc := newClient(cCtx)
+	deployment := setting(cCtx, "deployment")
+	lines := cCtx.Int64("lines")
+	path := cCtx.Args().Get(0)
+	if path == "" {
+		path = "."
+	}
+	dl := kube.NewDeploymentWatcher(deployment, kc, ctx)
+	written, err := dl.WriteDiagnosticsBundle(path, lines)
+	if err != nil {
+		fmt.Printf("unable to bundle diagnostics for %v: %v\n", deployment, err)
+		return
+	}
+	fmt.Printf("Wrote diagnostics for %v to %v\n", deployment, written)
+}
+
+func exportOptions(cCtx *cli.Context) kube.ExportOptions {
+	return kube.ExportOptions{Format: cCtx.String("format"), Compress: cCtx.Bool("gzip"), Bundle: cCtx.Bool("bundle")}
+}
+
+func exportFlags() []cli.Flag {
+	return []cli.Flag{
+		&cli.StringFlag{Name: "format", Usage: "the file format: text, jsonl or csv", Value: kube.FormatText},
+		&cli.BoolFlag{Name: "gzip", Usage: "gzip each file"},
+		&cli.BoolFlag{Name: "bundle", Usage: "write a single .tar.gz with every pod and container and a manifest"},
+	}
+}
+
+func searchDeploymentLogs(cCtx *cli.Context) {
+	ctx := context.Background()
+	kc := newClient(cCtx)
+	namespace := kc.Namespace()
+	deployment := setting(cCtx, "deployment")
+	path := cCtx.String("path")
+	searchParams, aggregate, err := searchParameters(cCtx)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	query := searchParams.Query
+	dl := kube.NewDeploymentWatcher(deployment, kc, ctx)
+	setLineOptions(cCtx, dl)
+	results := dl.SearchLogs(searchParams)
+	fmt.Printf("Found %v results", len(results))
+	if aggregate != "" {
+		aggregates, err := kube.AggregateResults(results, query, aggregate)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		fmt.Println()
+		fmt.Print(kube.FormatAggregates(aggregates))
+	} else if path == "" {
+		for _, result := range results {
+			fmt.Printf("Results for %v\n", result.PodName)
+			fmt.Println("----------------------------------------------------------------")
+			for _, match := range result.Matches {
+				fmt.Println(match)
+			}
+			fmt.Println()
+		}
+	}
+	if path != "" {
+		manifest := dl.Manifest(query)
+		manifest.From = searchParams.Since
+		written, err := kube.WriteExport(path, dl.SearchResultFiles(results), manifest, exportOptions(cCtx))
+		if err != nil {
+			fmt.Printf("unable to write results to %v: %v\n", path, err)
+		} else {
+			fmt.Printf("Wrote results to %v\n", written)
+		}
+	}
+
+	webhook := cCtx.String("notify")
+	if webhook != "" {
+		notifySearchResults(cCtx, webhook, namespace, deployment, query, results)
+	}
+}
+
+func notifySearchResults(cCtx *cli.Context, webhook string, namespace string, deployment string, query string, results []kube.SearchResult) {
+	notifier, err := notify.New(cCtx.String("notify-format"), webhook)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	throttled := notify.NewThrottled(notifier, notify.DefaultThrottleOptions())
+	for _, result := range results {
+		n := notify.Notification{
+			Title:      fmt.Sprintf("%v matches for %q in %v", len(result.Matches), query, result.PodName),
+			Source:     "search",
+			Namespace:  namespace,
+			Deployment: deployment,
+			Pod:        result.PodName,
+			Lines:      result.Matches,
+		}
+		if len(n.Lines) > 20 {
+			n.Lines = n.Lines[len(n.Lines)-20:]
+		}
+		err = throttled.Notify(cCtx.Context, n)
+		if err != nil {
+			fmt.Printf("unable to send notification for %v: %v\n", result.PodName, err)
+		}
+	}
+}
+
+func deploymentStatus(cCtx *cli.Context) {
+	ctx := context.Background()
+	kc := newClient(cCtx)
+	namespace := kc.Namespace()
+	deployment := setting(cCtx, "deployment")
+	dl := kube.NewDeploymentWatcher(deployment, kc, ctx)
+	if !cCtx.Bool("watch") {
+		fmt.Print(kube.FormatPodTable(dl.PodStatuses(), time.Now()))
+		return
+	}
+	dl.WatchStatus(func(pods []kube.Pod) {
+		// clear the screen so the table redraws in place
+		fmt.Print("\033[H\033[2J")
+		fmt.Printf("%v/%v at %v\n\n", namespace, deployment, time.Now().Format(time.TimeOnly))
+		fmt.Print(kube.FormatPodTable(pods, time.Now()))
+	})
+}
+
+func runTUI(cCtx *cli.Context) {
+	opts := tui.Options{
+		Flags:      configFlags(cCtx),
+		Namespace:  setting(cCtx, "namespace"),
+		Deployment: setting(cCtx, "deployment"),
+		SavePath:   cCtx.String("save-path"),
+		Stream:     streamOptions(cCtx),
+		Lines:      lineOptions(cCtx),
+		Redactor:   redactor(cCtx),
+	}
+	if !kube.ValidOverflow(opts.Stream.Overflow) {
+		fmt.Printf("unknown overflow policy %q\n", opts.Stream.Overflow)
+		return
+	}
+	if err := opts.Lines.Validate(); err != nil {
+		fmt.Println(err)
+		return
+	}
+	err := tui.Run(opts)
+	if err != nil {
+		fmt.Println(err)
+	}
+}
+
+// NewApp is the command line, shared by kube_watcher and the kubectl-watcher plugin
+func NewApp(name string) *cli.App {
+	return &cli.App{
+		Name:   name,
+		Usage:  "watch, search and save the logs of a deployment's pods",
+		Flags:  globalFlags(),
+		Before: loadSettings,
+		Commands: []*cli.Command{
+			{
+				Name:    "set_context",
+				Aliases: []string{"c"},
+				Usage:   "Sets the context that will be used for all other commands, in the profile if one is in use otherwise in the kubeconfig",
+				Flags: []cli.Flag{
+					&cli.BoolFlag{Name: "save", Value: true},
+					&cli.StringFlag{Name: "path", Usage: "the path to your kube conf"},
+					&cli.StringFlag{Name: "context", Usage: "the context to use"},
+				},
+				Action: func(cCtx *cli.Context) error {
+					setContext(cCtx)
+					return nil
+				},
+			},
+			profileCommand(),
+			searchesCommand(),
+			serveCommand(),
+			apiCommand(),
+			{
+				Name:  "tui",
+				Usage: "watch, filter, search and save deployment logs from an interactive terminal ui",
+				Flags: append([]cli.Flag{
+					&cli.StringFlag{Name: "path", Usage: "the path to your kube conf"},
+					&cli.StringFlag{Name: "context", Usage: "the context to use, picked in the ui if not set"},
+					&cli.StringFlag{Name: "namespace", Aliases: []string{"n"}, Usage: "the namespace to use, picked in the ui if not set"},
+					&cli.StringFlag{Name: "deployment", Usage: "the deployment to watch, picked in the ui if not set"},
+					&cli.StringFlag{Name: "save-path", Usage: "the directory saved logs and search results go to", Value: "."},
+				}, streamFlags()...),
+				Action: func(cCtx *cli.Context) error {
+					runTUI(cCtx)
+					return nil
+				},
+			},
+			{
+				Name:    "deployment_logs",
+				Aliases: []string{"dl"},
+				Usage:   "saves all logs to disk, searches logs, or attaches to logs to watch",
+				Subcommands: []*cli.Command{
+					{
+						Name:  "search",
+						Usage: "searches a deployment logs for the query",
+						Flags: append([]cli.Flag{
+							&cli.StringFlag{Name: "namespace", Aliases: []string{"n"}, Usage: "the namespace to use", Required: false},
+							&cli.StringFlag{Name: "deployment", Usage: "deployment"},
+							&cli.StringFlag{Name: "query", Usage: "the query to search for"},
+							&cli.StringFlag{Name: "saved", Usage: "run a saved search, the other flags override its settings"},
+							&cli.IntFlag{Name: "context-lines", Aliases: []string{"C"}, Usage: "the # of lines to show before and after each match"},
+							&cli.StringFlag{Name: "aggregate", Usage: "count the matches by pod or message instead of listing them"},
+							&cli.TimestampFlag{Name: "since", Usage: "The time we should look back to", Required: false, Layout: "2006-01-02T15:04:05"},
+							&cli.StringFlag{Name: "path", Usage: "The path to output the logs to", Required: false},
+							&cli.StringFlag{Name: "container", Usage: "The container to search logs of, if not specified used all", Required: false},
+							&cli.Float64Flag{Name: "memory-above", Usage: "only lines logged while the pod used more than this % of its memory limit. Usage is read once when searching, so only lines from the last metrics window (about a minute) can match", Required: false},
+							&cli.Float64Flag{Name: "cpu-above", Usage: "only lines logged while the pod used more than this % of its cpu limit. Usage is read once when searching, so only lines from the last metrics window (about a minute) can match", Required: false},
+							&cli.StringFlag{Name: "notify", Usage: "a webhook url to send the search hits to", Required: false},
+							&cli.StringFlag{Name: "notify-format", Usage: "the webhook payload format: webhook, slack or teams", Value: "webhook"},
+						}, append(exportFlags(), lineFlags()...)...),
+						Action: func(cCtx *cli.Context) error {
+							searchDeploymentLogs(cCtx)
+							return nil
+						},
+					},
+					{
+						Name:  "save",
+						Usage: "saves all logs for a deployment to disk: dl save -flags path",
+						Flags: append([]cli.Flag{
+							&cli.StringFlag{Name: "namespace", Aliases: []string{"n"}, Usage: "the namespace to use", Required: false},
+							&cli.StringFlag{Name: "deployment", Usage: "deployment"},
+							&cli.Int64Flag{Name: "lines", Usage: "the # of lines to output", Value: 0},
+						}, append(exportFlags(), lineFlags()...)...),
+						Action: func(cCtx *cli.Context) error {
+							saveDeploymentLogs(cCtx)
+							return nil
+						},
+					},
+					{
+						Name:  "bundle",
+						Usage: "writes logs, pod specs, statuses and events for a deployment to one archive: dl bundle -flags path",
+						Flags: []cli.Flag{
+							&cli.StringFlag{Name: "namespace", Aliases: []string{"n"}, Usage: "the namespace to use", Required: false},
+							&cli.StringFlag{Name: "deployment", Usage: "deployment"},
+							&cli.Int64Flag{Name: "lines", Usage: "the # of lines to include per container, 0 for all", Value: 0},
+						},
+						Action: func(cCtx *cli.Context) error {
+							bundleDiagnostics(cCtx)
+							return nil
+						},
+					},
+					{
+						Name:  "capture",
+						Usage: "follows every pod of a deployment appending to a rotating file per pod: dl capture -flags path",
+						Flags: append([]cli.Flag{
+							&cli.StringFlag{Name: "namespace", Aliases: []string{"n"}, Usage: "the namespace to use", Required: false},
+							&cli.StringFlag{Name: "deployment", Usage: "deployment"},
+							&cli.Int64Flag{Name: "max-size", Usage: "rotate a pod's file once it reaches this many MB, 0 for no limit", Value: 100},
+							&cli.DurationFlag{Name: "rotate-every", Usage: "rotate a pod's file once it has been written to this long, e.g. 1h", Value: 0},
+							&cli.BoolFlag{Name: "compress", Usage: "gzip rotated files", Value: true},
+							&cli.Int64Flag{Name: "max-total", Usage: "the most MB all captured files can use, oldest rotated files are removed first, 0 for no limit", Value: 0},
+							&cli.DurationFlag{Name: "max-age", Usage: "remove rotated files older than this, e.g. 72h", Value: 0},
+						}, streamFlags()...),
+						Action: func(cCtx *cli.Context) error {
+							captureLogs(cCtx)
+							return nil
+						},
+					},
+					{
+						Name:  "status",
+						Usage: "shows the readiness, restarts and last termination of each pod: dl status -namespace test -deployment d",
+						Flags: []cli.Flag{
+							&cli.StringFlag{Name: "namespace", Aliases: []string{"n"}, Usage: "the namespace to use", Required: false},
+							&cli.StringFlag{Name: "deployment", Usage: "deployment"},
+							&cli.BoolFlag{Name: "watch", Aliases: []string{"w"}, Usage: "keep the table up to date as pods change"},
+						},
+						Action: func(cCtx *cli.Context) error {
+							deploymentStatus(cCtx)
+							return nil
+						},
+					},
+					{
+						Name:  "stream",
+						Usage: "streams all logs for a deployment to the console: dl stream -namespace test -deployment d",
+						Flags: append([]cli.Flag{
+							&cli.StringFlag{Name: "namespace", Aliases: []string{"n"}, Usage: "the namespace to use", Required: false},
+							&cli.StringFlag{Name: "deployment", Usage: "deployment"},
+							&cli.DurationFlag{Name: "metrics-interval", Usage: "how often to print pod cpu and memory usage, 0 to turn off", Value: 30 * time.Second},
+							&cli.StringFlag{Name: "colors", Usage: "the console color scheme: random, basic or none"},
+							&cli.StringSliceFlag{Name: "sink", Usage: "where to send the logs, can be repeated: console, plain, jsonl, file:<path>, dir:<dir>, http:<url>, loki:<url>, elasticsearch:<url>, otlp:<url>, syslog:<tcp://host:port>, kafka:<brokers>", Value: cli.NewStringSlice("console")},
+						}, streamFlags()...),
+						Action: func(cCtx *cli.Context) error {
+							streamLogs(cCtx)
+							return nil
+						},
+					},
+				},
+			},
+		},
+	}
+}
@@ -0,0 +1,220 @@
+package commands
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/farrjere/kube_watcher/config"
+	"github.com/farrjere/kube_watcher/kube"
+
+	"github.com/urfave/cli/v2"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+)
+
+// runFlags parses the args as NewApp would and returns the connection flags a command would use
+func runFlags(t *testing.T, args ...string) *genericclioptions.ConfigFlags {
+	flags, _ := runSettings(t, args...)
+	return flags
+}
+
+// runSettings is runFlags plus the deployment the command would watch
+func runSettings(t *testing.T, args ...string) (*genericclioptions.ConfigFlags, string) {
+	var flags *genericclioptions.ConfigFlags
+	var deployment string
+	app := &cli.App{
+		Flags:  globalFlags(),
+		Before: loadSettings,
+		Commands: []*cli.Command{{
+			Name: "dl",
+			Subcommands: []*cli.Command{{
+				Name:  "stream",
+				Flags: []cli.Flag{&cli.StringFlag{Name: "namespace", Aliases: []string{"n"}}, &cli.StringFlag{Name: "deployment"}},
+				Action: func(cCtx *cli.Context) error {
+					flags = configFlags(cCtx)
+					deployment = setting(cCtx, "deployment")
+					return nil
+				},
+			}},
+		}},
+	}
+	if err := app.Run(append([]string{"kubectl-watcher"}, args...)); err != nil {
+		t.Fatal(err)
+	}
+	return flags, deployment
+}
+
+func TestConfigFlags(t *testing.T) {
+	t.Setenv("KUBE_WATCHER_CONFIG", filepath.Join(t.TempDir(), "config.yaml"))
+	flags := runFlags(t, "--kubeconfig", "/tmp/config", "--context", "prod", "-n", "global", "--as", "jane", "--as-group", "ops", "--request-timeout", "10s", "dl", "stream")
+	if *flags.KubeConfig != "/tmp/config" || *flags.Context != "prod" || *flags.Namespace != "global" {
+		t.Errorf("expected the global flags got %v %v %v", *flags.KubeConfig, *flags.Context, *flags.Namespace)
+	}
+	if *flags.Impersonate != "jane" || len(*flags.ImpersonateGroup) != 1 || *flags.Timeout != "10s" {
+		t.Errorf("expected impersonation and timeout got %v %v %v", *flags.Impersonate, *flags.ImpersonateGroup, *flags.Timeout)
+	}
+
+	flags = runFlags(t, "-n", "global", "dl", "stream", "-n", "local")
+	if *flags.Namespace != "local" {
+		t.Errorf("expected the command's namespace to win got %v", *flags.Namespace)
+	}
+	if flags.Context != nil && *flags.Context != "" {
+		t.Errorf("expected no context override got %v", *flags.Context)
+	}
+}
+
+func TestRedaction(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	t.Setenv("KUBE_WATCHER_CONFIG", path)
+	c := config.Config{Redaction: &kube.RedactOptions{Detectors: []string{kube.DetectEmail}, Rules: []kube.RedactRule{{Name: "customer", Pattern: "cust_[0-9]+"}}}}
+	if err := c.Save(path); err != nil {
+		t.Fatal(err)
+	}
+	redact := func(args ...string) string {
+		var redacted string
+		app := &cli.App{
+			Flags: globalFlags(),
+			Commands: []*cli.Command{{
+				Name: "stream",
+				Action: func(cCtx *cli.Context) error {
+					redacted = redactor(cCtx).Redact("cust_1 jane@example.com 10.0.0.1")
+					return nil
+				},
+			}},
+		}
+		if err := app.Run(append([]string{"kube_watcher"}, args...)); err != nil {
+			t.Fatal(err)
+		}
+		return redacted
+	}
+	if r := redact("stream"); r != "[REDACTED:customer] [REDACTED:email] 10.0.0.1" {
+		t.Errorf("expected the config's redaction got %q", r)
+	}
+	if r := redact("--redact", "ip", "stream"); r != "[REDACTED:customer] jane@example.com [REDACTED:ip]" {
+		t.Errorf("expected --redact to pick the detectors got %q", r)
+	}
+	if r := redact("--redact", "ip", "--redact-mode", "hash", "stream"); !strings.HasPrefix(r, "[customer:") {
+		t.Errorf("expected --redact-mode to hash got %q", r)
+	}
+}
+
+func TestProfiles(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	run := func(args ...string) {
+		if err := NewApp("kube_watcher").Run(append([]string{"kube_watcher", "--config", path}, args...)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	run("profile", "create", "--context", "minikube", "-n", "web", "--deployment", "api", "--sink", "plain", "--default", "dev")
+	run("profile", "create", "--context", "prod-cluster", "prod")
+	run("profile", "create", "--namespace", "api", "prod")
+
+	c, err := config.Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	prod, _ := c.Profile("prod")
+	if c.DefaultProfile != "dev" || prod.Context != "prod-cluster" || prod.Namespace != "api" {
+		t.Errorf("expected the update to keep the context got %+v", c)
+	}
+
+	flags, deployment := runSettings(t, "--config", path, "dl", "stream")
+	if *flags.Context != "minikube" || *flags.Namespace != "web" || deployment != "api" {
+		t.Errorf("expected the default profile got %v %v %v", *flags.Context, *flags.Namespace, deployment)
+	}
+	flags, deployment = runSettings(t, "--config", path, "--profile", "prod", "dl", "stream", "-n", "other", "--deployment", "web")
+	if *flags.Context != "prod-cluster" || *flags.Namespace != "other" || deployment != "web" {
+		t.Errorf("expected flags to win over the profile got %v %v %v", *flags.Context, *flags.Namespace, deployment)
+	}
+
+	err = NewApp("kube_watcher").Run([]string{"kube_watcher", "--config", path, "--profile", "missing", "dl", "stream"})
+	if err == nil || !strings.Contains(err.Error(), "no profile named missing") {
+		t.Errorf("expected an unknown profile to be an error got %v", err)
+	}
+
+	run("--profile", "prod", "set_context", "--context", "staging")
+	run("profile", "delete", "dev")
+	c, _ = config.Load(path)
+	prod, _ = c.Profile("prod")
+	if len(c.Profiles) != 1 || c.DefaultProfile != "" || prod.Context != "staging" {
+		t.Errorf("unexpected profiles after set_context and delete %+v", c)
+	}
+}
+
+// runSearchParameters parses the args as dl search would and returns what it would search with
+func runSearchParameters(t *testing.T, args ...string) (kube.SearchParameters, string, error) {
+	var params kube.SearchParameters
+	var aggregate string
+	var err error
+	app := &cli.App{
+		Flags: globalFlags(),
+		Commands: []*cli.Command{{
+			Name: "search",
+			Flags: []cli.Flag{
+				&cli.StringFlag{Name: "query"},
+				&cli.StringFlag{Name: "saved"},
+				&cli.StringFlag{Name: "container"},
+				&cli.IntFlag{Name: "context-lines", Aliases: []string{"C"}},
+				&cli.StringFlag{Name: "aggregate"},
+				&cli.TimestampFlag{Name: "since", Layout: "2006-01-02T15:04:05"},
+			},
+			Action: func(cCtx *cli.Context) error {
+				params, aggregate, err = searchParameters(cCtx)
+				return nil
+			},
+		}},
+	}
+	if runErr := app.Run(append([]string{"kube_watcher"}, args...)); runErr != nil {
+		t.Fatal(runErr)
+	}
+	return params, aggregate, err
+}
+
+func TestSavedSearches(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.yaml")
+	run := func(args ...string) string {
+		var out bytes.Buffer
+		app := NewApp("kube_watcher")
+		app.Writer = &out
+		if err := app.Run(append([]string{"kube_watcher", "--config", path}, args...)); err != nil {
+			t.Fatal(err)
+		}
+		return out.String()
+	}
+	run("searches", "save", "--query", "timeout", "--since", "1h", "-C", "2", "--aggregate", "message", "timeouts")
+	run("searches", "save", "--query", "panic", "--container", "app", "panics")
+
+	params, aggregate, err := runSearchParameters(t, "--config", path, "search", "--saved", "timeouts")
+	if err != nil || params.Query != "timeout" || params.ContextLines != 2 || aggregate != "message" || params.Since.IsZero() {
+		t.Errorf("expected the saved search got %+v %v %v", params, aggregate, err)
+	}
+	params, aggregate, err = runSearchParameters(t, "--config", path, "search", "--saved", "timeouts", "-C", "0", "--aggregate", "pod", "--container", "sidecar")
+	if err != nil || params.ContextLines != 0 || aggregate != "pod" || params.Container != "sidecar" || params.AllContainers {
+		t.Errorf("expected flags to override the saved search got %+v %v %v", params, aggregate, err)
+	}
+	if _, _, err = runSearchParameters(t, "--config", path, "search", "--saved", "missing"); err == nil {
+		t.Errorf("expected a missing saved search to fail")
+	}
+
+	library := run("searches", "export", "--name", "panics")
+	exported := filepath.Join(dir, "library.yaml")
+	os.WriteFile(exported, []byte(library), 0o644)
+
+	other := filepath.Join(dir, "other.yaml")
+	if err = NewApp("kube_watcher").Run([]string{"kube_watcher", "--config", other, "searches", "import", exported}); err != nil {
+		t.Fatal(err)
+	}
+	c, _ := config.Load(other)
+	if len(c.Searches) != 1 || c.Searches[0].Name != "panics" || c.Searches[0].Container != "app" {
+		t.Errorf("expected only the exported search imported got %+v", c.Searches)
+	}
+
+	run("searches", "delete", "timeouts")
+	c, _ = config.Load(path)
+	if len(c.Searches) != 1 {
+		t.Errorf("expected the search deleted got %+v", c.Searches)
+	}
+}
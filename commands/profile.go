@@ -0,0 +1,251 @@
+package commands
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/farrjere/kube_watcher/config"
+	"github.com/farrjere/kube_watcher/sink"
+	"github.com/urfave/cli/v2"
+)
+
+func loadConfig(cCtx *cli.Context) (*config.Config, string, error) {
+	path := cCtx.String("config")
+	if path == "" {
+		path = config.DefaultPath()
+	}
+	c, err := config.Load(path)
+	return c, path, err
+}
+
+// settings are the config and the profile a command runs with, read from the config file once per command
+type settings struct {
+	config  *config.Config
+	profile config.Profile
+	// err is why the profile can't be used, e.g. the config is broken or there is no profile by that name
+	err error
+}
+
+const settingsKey = "settings"
+
+// loaded are the command's settings, loading them the first time they're asked for
+func loaded(cCtx *cli.Context) *settings {
+	if s, ok := cCtx.App.Metadata[settingsKey].(*settings); ok {
+		return s
+	}
+	s := &settings{config: &config.Config{}}
+	c, _, err := loadConfig(cCtx)
+	if err != nil {
+		s.err = err
+	} else {
+		s.config = c
+		s.profile, s.err = c.Current(cCtx.String("profile"))
+	}
+	if cCtx.App.Metadata == nil {
+		cCtx.App.Metadata = make(map[string]interface{})
+	}
+	cCtx.App.Metadata[settingsKey] = s
+	return s
+}
+
+// loadSettings is the app's Before, so a broken config or an unknown --profile fails the command before it runs.
+// profile and set_context are left to report their own errors, they can name a profile that doesn't exist yet
+func loadSettings(cCtx *cli.Context) error {
+	s := loaded(cCtx)
+	if cmd := cCtx.App.Command(cCtx.Args().First()); cmd != nil && (cmd.Name == "profile" || cmd.Name == "set_context") {
+		return nil
+	}
+	return s.err
+}
+
+// profileName is the --profile asked for, or the config's default profile
+func profileName(cCtx *cli.Context) string {
+	if name := cCtx.String("profile"); name != "" {
+		return name
+	}
+	return loaded(cCtx).config.DefaultProfile
+}
+
+// currentProfile is the --profile asked for, or the config's default profile
+func currentProfile(cCtx *cli.Context) config.Profile {
+	return loaded(cCtx).profile
+}
+
+// setting is the flag if it was given, otherwise the profile's value for it
+func setting(cCtx *cli.Context, name string) string {
+	if value := flagValue(cCtx, name); value != "" {
+		return value
+	}
+	return currentProfile(cCtx).Value(name)
+}
+
+// sinkSpecs are the --sink flags, the profile's sinks when none are given
+func sinkSpecs(cCtx *cli.Context) []string {
+	if !cCtx.IsSet("sink") {
+		if sinks := currentProfile(cCtx).Sinks; len(sinks) > 0 {
+			return sinks
+		}
+	}
+	return cCtx.StringSlice("sink")
+}
+
+func profileFlags() []cli.Flag {
+	return []cli.Flag{
+		&cli.StringFlag{Name: "context", Usage: "the kubeconfig context"},
+		&cli.StringFlag{Name: "namespace", Aliases: []string{"n"}, Usage: "the namespace"},
+		&cli.StringFlag{Name: "deployment", Usage: "the deployment"},
+		&cli.StringFlag{Name: "container", Usage: "the container searches default to"},
+		&cli.StringFlag{Name: "query", Usage: "the query searches default to"},
+		&cli.StringFlag{Name: "since", Usage: "how far back searches look by default, e.g. 1h"},
+		&cli.StringSliceFlag{Name: "sink", Usage: "where dl stream sends logs by default, can be repeated"},
+		&cli.StringFlag{Name: "colors", Usage: "the console color scheme: random, basic or none"},
+		&cli.BoolFlag{Name: "default", Usage: "use this profile when no --profile is given"},
+	}
+}
+
+// createProfile adds a profile, or updates the settings given on an existing one
+func createProfile(cCtx *cli.Context) error {
+	name := cCtx.Args().First()
+	if name == "" {
+		return fmt.Errorf("a profile name is required: profile create -flags name")
+	}
+	update := config.Profile{
+		Context:     cCtx.String("context"),
+		Namespace:   cCtx.String("namespace"),
+		Deployment:  cCtx.String("deployment"),
+		Container:   cCtx.String("container"),
+		Query:       cCtx.String("query"),
+		Since:       cCtx.String("since"),
+		Sinks:       cCtx.StringSlice("sink"),
+		ColorScheme: cCtx.String("colors"),
+	}
+	if _, err := update.SinceTime(time.Now()); err != nil {
+		return err
+	}
+	if !sink.ValidColorScheme(update.ColorScheme) {
+		return fmt.Errorf("unknown color scheme %q", update.ColorScheme)
+	}
+	c, path, err := loadConfig(cCtx)
+	if err != nil {
+		return err
+	}
+	p, exists := c.Profile(name)
+	if !exists {
+		p = config.Profile{Name: name}
+	}
+	c.SetProfile(p.Merge(update))
+	if cCtx.Bool("default") {
+		c.DefaultProfile = name
+	}
+	if err = c.Save(path); err != nil {
+		return err
+	}
+	if exists {
+		fmt.Printf("Updated profile %v\n", name)
+	} else {
+		fmt.Printf("Created profile %v\n", name)
+	}
+	return nil
+}
+
+func listProfiles(cCtx *cli.Context) error {
+	c, _, err := loadConfig(cCtx)
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tCONTEXT\tNAMESPACE\tDEPLOYMENT\tSINKS")
+	for _, p := range c.Profiles {
+		name := p.Name
+		if name == c.DefaultProfile {
+			name += " *"
+		}
+		fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\n", name, p.Context, p.Namespace, p.Deployment, strings.Join(p.Sinks, ","))
+	}
+	return w.Flush()
+}
+
+func deleteProfile(cCtx *cli.Context) error {
+	name := cCtx.Args().First()
+	c, path, err := loadConfig(cCtx)
+	if err != nil {
+		return err
+	}
+	if !c.DeleteProfile(name) {
+		return fmt.Errorf("no profile named %v", name)
+	}
+	if err = c.Save(path); err != nil {
+		return err
+	}
+	fmt.Printf("Deleted profile %v\n", name)
+	return nil
+}
+
+func useProfile(cCtx *cli.Context) error {
+	name := cCtx.Args().First()
+	c, path, err := loadConfig(cCtx)
+	if err != nil {
+		return err
+	}
+	if _, ok := c.Profile(name); !ok {
+		return fmt.Errorf("no profile named %v", name)
+	}
+	c.DefaultProfile = name
+	if err = c.Save(path); err != nil {
+		return err
+	}
+	fmt.Printf("Using profile %v by default\n", name)
+	return nil
+}
+
+// setProfileContext is set_context for a profile, so switching context doesn't touch the kubeconfig
+func setProfileContext(cCtx *cli.Context, name string, context string) error {
+	c, path, err := loadConfig(cCtx)
+	if err != nil {
+		return err
+	}
+	p, ok := c.Profile(name)
+	if !ok {
+		p = config.Profile{Name: name}
+	}
+	p.Context = context
+	c.SetProfile(p)
+	if err = c.Save(path); err != nil {
+		return err
+	}
+	fmt.Printf("Profile %v now uses context %v\n", name, context)
+	return nil
+}
+
+func profileCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "profile",
+		Usage: "manages the named profiles in the config file, pick one with --profile",
+		Subcommands: []*cli.Command{
+			{
+				Name:   "create",
+				Usage:  "creates a profile or updates the settings given on it: profile create -flags name",
+				Flags:  profileFlags(),
+				Action: createProfile,
+			},
+			{
+				Name:   "list",
+				Usage:  "lists the profiles, the default one is marked with a *",
+				Action: listProfiles,
+			},
+			{
+				Name:   "delete",
+				Usage:  "deletes a profile: profile delete name",
+				Action: deleteProfile,
+			},
+			{
+				Name:   "use",
+				Usage:  "makes a profile the default: profile use name",
+				Action: useProfile,
+			},
+		},
+	}
+}
@@ -0,0 +1,229 @@
+package commands
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/farrjere/kube_watcher/config"
+	"github.com/farrjere/kube_watcher/kube"
+	"github.com/urfave/cli/v2"
+)
+
+// savedSearch is the --saved search, nil when dl search isn't running one
+func savedSearch(cCtx *cli.Context) (*config.SavedSearch, error) {
+	name := cCtx.String("saved")
+	if name == "" {
+		return nil, nil
+	}
+	s, ok := loaded(cCtx).config.Search(name)
+	if !ok {
+		return nil, fmt.Errorf("no saved search named %v", name)
+	}
+	return &s, nil
+}
+
+// searchParameters are what dl search runs with, flags win over a saved search which wins over the profile
+func searchParameters(cCtx *cli.Context) (kube.SearchParameters, string, error) {
+	saved, err := savedSearch(cCtx)
+	if err != nil {
+		return kube.SearchParameters{}, "", err
+	}
+	if saved == nil {
+		saved = &config.SavedSearch{}
+		profile := currentProfile(cCtx)
+		saved.Query, saved.Container, saved.Since = profile.Query, profile.Container, profile.Since
+	}
+	if query := flagValue(cCtx, "query"); query != "" {
+		saved.Query = query
+	}
+	if container := flagValue(cCtx, "container"); container != "" {
+		saved.Container = container
+	}
+	if cCtx.IsSet("context-lines") {
+		saved.ContextLines = cCtx.Int("context-lines")
+	}
+	if cCtx.IsSet("aggregate") {
+		saved.Aggregate = cCtx.String("aggregate")
+	}
+	if !kube.ValidAggregation(saved.Aggregate) {
+		return kube.SearchParameters{}, "", fmt.Errorf("unknown aggregation %q, use pod or message", saved.Aggregate)
+	}
+	params, err := saved.Parameters(time.Now())
+	if err != nil {
+		return params, "", err
+	}
+	if since := cCtx.Timestamp("since"); since != nil {
+		params.Since = since.Add(0)
+	}
+	params.MemoryAbovePercent = cCtx.Float64("memory-above")
+	params.CPUAbovePercent = cCtx.Float64("cpu-above")
+	return params, saved.Aggregate, nil
+}
+
+func savedSearchFlags() []cli.Flag {
+	return []cli.Flag{
+		&cli.StringFlag{Name: "query", Usage: "the query to search for", Required: true},
+		&cli.StringFlag{Name: "description", Usage: "what the search is for"},
+		&cli.StringFlag{Name: "since", Usage: "how far back the search looks when it runs, e.g. 1h"},
+		&cli.StringFlag{Name: "container", Usage: "the container to search, all of them if not set"},
+		&cli.IntFlag{Name: "context-lines", Aliases: []string{"C"}, Usage: "the # of lines to show before and after each match"},
+		&cli.StringFlag{Name: "aggregate", Usage: "count the matches by pod or message instead of listing them"},
+		&cli.Int64Flag{Name: "limit", Usage: "the most matches kept per container, the most recent ones, 0 for all"},
+	}
+}
+
+func saveSearch(cCtx *cli.Context) error {
+	s := config.SavedSearch{
+		Name:         cCtx.Args().First(),
+		Description:  cCtx.String("description"),
+		Query:        cCtx.String("query"),
+		Since:        cCtx.String("since"),
+		Container:    cCtx.String("container"),
+		ContextLines: cCtx.Int("context-lines"),
+		Aggregate:    cCtx.String("aggregate"),
+		Limit:        cCtx.Int64("limit"),
+	}
+	if err := s.Validate(); err != nil {
+		return err
+	}
+	c, path, err := loadConfig(cCtx)
+	if err != nil {
+		return err
+	}
+	c.SetSearch(s)
+	if err = c.Save(path); err != nil {
+		return err
+	}
+	fmt.Printf("Saved search %v\n", s.Name)
+	return nil
+}
+
+func listSearches(cCtx *cli.Context) error {
+	c, _, err := loadConfig(cCtx)
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tQUERY\tSINCE\tCONTAINER\tAGGREGATE\tDESCRIPTION")
+	for _, s := range c.Searches {
+		fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\t%v\n", s.Name, s.Query, orAll(s.Since), orAll(s.Container), s.Aggregate, s.Description)
+	}
+	return w.Flush()
+}
+
+func orAll(value string) string {
+	if value == "" {
+		return "all"
+	}
+	return value
+}
+
+func deleteSearch(cCtx *cli.Context) error {
+	name := cCtx.Args().First()
+	c, path, err := loadConfig(cCtx)
+	if err != nil {
+		return err
+	}
+	if !c.DeleteSearch(name) {
+		return fmt.Errorf("no saved search named %v", name)
+	}
+	if err = c.Save(path); err != nil {
+		return err
+	}
+	fmt.Printf("Deleted saved search %v\n", name)
+	return nil
+}
+
+// exportSearches writes the library to the file given, or stdout when there isn't one
+func exportSearches(cCtx *cli.Context) error {
+	c, _, err := loadConfig(cCtx)
+	if err != nil {
+		return err
+	}
+	library, err := c.ExportSearches(cCtx.StringSlice("name")...)
+	if err != nil {
+		return err
+	}
+	content, err := library.Marshal()
+	if err != nil {
+		return err
+	}
+	path := cCtx.Args().First()
+	if path == "" {
+		_, err = cCtx.App.Writer.Write(content)
+		return err
+	}
+	if err = os.WriteFile(path, content, 0o644); err != nil {
+		return err
+	}
+	fmt.Printf("Exported %v searches to %v\n", len(library.Searches), path)
+	return nil
+}
+
+func importSearches(cCtx *cli.Context) error {
+	path := cCtx.Args().First()
+	if path == "" {
+		return fmt.Errorf("a file to import is required: searches import file")
+	}
+	library, err := config.ReadSearchLibrary(path)
+	if err != nil {
+		return err
+	}
+	c, configPath, err := loadConfig(cCtx)
+	if err != nil {
+		return err
+	}
+	added, skipped := c.ImportSearches(library.Searches, cCtx.Bool("overwrite"))
+	if err = c.Save(configPath); err != nil {
+		return err
+	}
+	fmt.Printf("Imported %v searches\n", len(added))
+	if len(skipped) > 0 {
+		fmt.Printf("Skipped %v, they are already saved, use --overwrite to replace them\n", strings.Join(skipped, ", "))
+	}
+	return nil
+}
+
+func searchesCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "searches",
+		Usage: "manages the saved searches in the config file, run one with dl search --saved name",
+		Subcommands: []*cli.Command{
+			{
+				Name:   "save",
+				Usage:  "saves a search or replaces the one with the same name: searches save -flags name",
+				Flags:  savedSearchFlags(),
+				Action: saveSearch,
+			},
+			{
+				Name:   "list",
+				Usage:  "lists the saved searches",
+				Action: listSearches,
+			},
+			{
+				Name:   "delete",
+				Usage:  "deletes a saved search: searches delete name",
+				Action: deleteSearch,
+			},
+			{
+				Name:  "export",
+				Usage: "writes saved searches to a file to share, stdout if no file is given: searches export -flags [file]",
+				Flags: []cli.Flag{
+					&cli.StringSliceFlag{Name: "name", Usage: "the search to export, can be repeated, all of them if not set"},
+				},
+				Action: exportSearches,
+			},
+			{
+				Name:  "import",
+				Usage: "adds the searches from a shared file: searches import -flags file",
+				Flags: []cli.Flag{
+					&cli.BoolFlag{Name: "overwrite", Usage: "replace saved searches with the same name"},
+				},
+				Action: importSearches,
+			},
+		},
+	}
+}
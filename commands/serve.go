@@ -0,0 +1,72 @@
+package commands
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/farrjere/kube_watcher/daemon"
+	"github.com/farrjere/kube_watcher/metrics"
+	"github.com/urfave/cli/v2"
+)
+
+// serve runs until SIGTERM or ctrl-c, reloading the workloads file on SIGHUP
+func serve(cCtx *cli.Context) error {
+	path := cCtx.String("file")
+	c, err := daemon.LoadConfig(path)
+	if err != nil {
+		return err
+	}
+	d := daemon.New()
+	if listen := cCtx.String("metrics-listen"); listen != "" {
+		m := metrics.New()
+		d.SetMetrics(m)
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", m.Handler())
+		server := &http.Server{Addr: listen, Handler: mux}
+		defer server.Close()
+		go func() {
+			fmt.Printf("Serving metrics on %v/metrics\n", listen)
+			if err := server.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+				fmt.Printf("Unable to serve metrics: %v\n", err)
+			}
+		}()
+	}
+	if err = d.Apply(c); err != nil {
+		return err
+	}
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP, syscall.SIGTERM, os.Interrupt)
+	defer signal.Stop(signals)
+	for sig := range signals {
+		if sig != syscall.SIGHUP {
+			break
+		}
+		fmt.Printf("Reloading %v\n", path)
+		c, err = daemon.LoadConfig(path)
+		if err == nil {
+			err = d.Apply(c)
+		}
+		if err != nil {
+			fmt.Printf("Keeping the running workloads, unable to reload: %v\n", err)
+		}
+	}
+	fmt.Println("Shutting down, flushing sinks")
+	d.Stop()
+	return nil
+}
+
+func serveCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "serve",
+		Usage: "watches every workload in a YAML file, sending their logs to sinks and alert rules, SIGHUP reloads the file",
+		Flags: []cli.Flag{
+			&cli.StringFlag{Name: "file", Aliases: []string{"f"}, Usage: "the workloads, sinks and alert rules to run", Required: true},
+			&cli.StringFlag{Name: "metrics-listen", Usage: "the address to serve prometheus metrics on at /metrics, e.g. :9090, off when not set"},
+		},
+		Action: serve,
+	}
+}
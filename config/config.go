@@ -0,0 +1,347 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/farrjere/kube_watcher/kube"
+	"sigs.k8s.io/yaml"
+)
+
+// Profile is a saved set of defaults so they don't need repeating on every command
+type Profile struct {
+	Name       string `json:"name"`
+	Context    string `json:"context,omitempty"`
+	Namespace  string `json:"namespace,omitempty"`
+	Deployment string `json:"deployment,omitempty"`
+	Container  string `json:"container,omitempty"`
+	// Query and Since are the search defaults, Since is a duration like 1h
+	Query       string   `json:"query,omitempty"`
+	Since       string   `json:"since,omitempty"`
+	Sinks       []string `json:"sinks,omitempty"`
+	ColorScheme string   `json:"color_scheme,omitempty"`
+}
+
+// SinceTime is the time searches look back to, zero if the profile doesn't set one
+func (p Profile) SinceTime(now time.Time) (time.Time, error) {
+	since, err := sinceTime(p.Since, now)
+	if err != nil {
+		return since, fmt.Errorf("profile %v has an invalid since %q: %w", p.Name, p.Since, err)
+	}
+	return since, nil
+}
+
+// Value looks a setting up by its command line flag name
+func (p Profile) Value(flag string) string {
+	switch flag {
+	case "context":
+		return p.Context
+	case "namespace":
+		return p.Namespace
+	case "deployment":
+		return p.Deployment
+	case "container":
+		return p.Container
+	case "query":
+		return p.Query
+	case "since":
+		return p.Since
+	case "colors":
+		return p.ColorScheme
+	}
+	return ""
+}
+
+// Merge fills the profile's settings from other, leaving the ones other doesn't set alone
+func (p Profile) Merge(other Profile) Profile {
+	set := func(field *string, value string) {
+		if value != "" {
+			*field = value
+		}
+	}
+	set(&p.Context, other.Context)
+	set(&p.Namespace, other.Namespace)
+	set(&p.Deployment, other.Deployment)
+	set(&p.Container, other.Container)
+	set(&p.Query, other.Query)
+	set(&p.Since, other.Since)
+	set(&p.ColorScheme, other.ColorScheme)
+	if len(other.Sinks) > 0 {
+		p.Sinks = other.Sinks
+	}
+	return p
+}
+
+// SavedSearch is a named search that can be rerun with dl search --saved name
+type SavedSearch struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	Query       string `json:"query"`
+	// Since is a duration like 1h, the window is relative to when the search runs
+	Since        string `json:"since,omitempty"`
+	Container    string `json:"container,omitempty"`
+	ContextLines int    `json:"context_lines,omitempty"`
+	// Aggregate is pod or message, empty to list the matches
+	Aggregate string `json:"aggregate,omitempty"`
+	Limit     int64  `json:"limit,omitempty"`
+}
+
+func (s SavedSearch) Validate() error {
+	if s.Name == "" {
+		return fmt.Errorf("a saved search needs a name")
+	}
+	if s.Query == "" {
+		return fmt.Errorf("saved search %v needs a query", s.Name)
+	}
+	if _, err := s.SinceTime(time.Now()); err != nil {
+		return err
+	}
+	if s.ContextLines < 0 {
+		return fmt.Errorf("saved search %v has negative context lines", s.Name)
+	}
+	if !kube.ValidAggregation(s.Aggregate) {
+		return fmt.Errorf("saved search %v has an unknown aggregation %q", s.Name, s.Aggregate)
+	}
+	return nil
+}
+
+func (s SavedSearch) SinceTime(now time.Time) (time.Time, error) {
+	since, err := sinceTime(s.Since, now)
+	if err != nil {
+		return since, fmt.Errorf("saved search %v has an invalid since %q: %w", s.Name, s.Since, err)
+	}
+	return since, nil
+}
+
+// Parameters are the search parameters for running the saved search now
+func (s SavedSearch) Parameters(now time.Time) (kube.SearchParameters, error) {
+	since, err := s.SinceTime(now)
+	if err != nil {
+		return kube.SearchParameters{}, err
+	}
+	return kube.SearchParameters{
+		Query:         s.Query,
+		Container:     s.Container,
+		AllContainers: s.Container == "",
+		Since:         since,
+		Limit:         s.Limit,
+		ContextLines:  s.ContextLines,
+	}, nil
+}
+
+func sinceTime(since string, now time.Time) (time.Time, error) {
+	if since == "" {
+		return time.Time{}, nil
+	}
+	d, err := time.ParseDuration(since)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return now.Add(-d), nil
+}
+
+type Config struct {
+	// DefaultProfile is used when no profile is asked for
+	DefaultProfile string        `json:"default_profile,omitempty"`
+	Profiles       []Profile     `json:"profiles"`
+	Searches       []SavedSearch `json:"searches,omitempty"`
+
+	// Redaction is applied to every log line and event read, by the commands and the app alike
+	Redaction *kube.RedactOptions `json:"redaction,omitempty"`
+}
+
+// DefaultPath is $KUBE_WATCHER_CONFIG if set, otherwise $XDG_CONFIG_HOME/kube-watcher/config.yaml
+// with ~/.config when XDG_CONFIG_HOME isn't set
+func DefaultPath() string {
+	if path := os.Getenv("KUBE_WATCHER_CONFIG"); path != "" {
+		return path
+	}
+	dir := os.Getenv("XDG_CONFIG_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			home = "."
+		}
+		dir = filepath.Join(home, ".config")
+	}
+	return filepath.Join(dir, "kube-watcher", "config.yaml")
+}
+
+// Load reads the config at path, a missing file is an empty config
+func Load(path string) (*Config, error) {
+	content, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return &Config{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	c := Config{}
+	if err = yaml.Unmarshal(content, &c); err != nil {
+		return nil, fmt.Errorf("unable to read %v: %w", path, err)
+	}
+	return &c, nil
+}
+
+// Save writes the config to a temp file first so a failed write never leaves half a config behind
+func (c *Config) Save(path string) error {
+	content, err := yaml.Marshal(c)
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".config-*.yaml")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(content)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func (c *Config) Profile(name string) (Profile, bool) {
+	for _, p := range c.Profiles {
+		if p.Name == name {
+			return p, true
+		}
+	}
+	return Profile{}, false
+}
+
+// Current is the named profile, or the default one when name is empty
+func (c *Config) Current(name string) (Profile, error) {
+	if name == "" {
+		name = c.DefaultProfile
+	}
+	if name == "" {
+		return Profile{}, nil
+	}
+	p, ok := c.Profile(name)
+	if !ok {
+		return Profile{}, fmt.Errorf("no profile named %v", name)
+	}
+	return p, nil
+}
+
+// SetProfile adds the profile or replaces the one with the same name
+func (c *Config) SetProfile(p Profile) {
+	for i := range c.Profiles {
+		if c.Profiles[i].Name == p.Name {
+			c.Profiles[i] = p
+			return
+		}
+	}
+	c.Profiles = append(c.Profiles, p)
+	sort.Slice(c.Profiles, func(i, j int) bool { return c.Profiles[i].Name < c.Profiles[j].Name })
+}
+
+func (c *Config) DeleteProfile(name string) bool {
+	for i := range c.Profiles {
+		if c.Profiles[i].Name == name {
+			c.Profiles = append(c.Profiles[:i], c.Profiles[i+1:]...)
+			if c.DefaultProfile == name {
+				c.DefaultProfile = ""
+			}
+			return true
+		}
+	}
+	return false
+}
+
+func (c *Config) Search(name string) (SavedSearch, bool) {
+	for _, s := range c.Searches {
+		if s.Name == name {
+			return s, true
+		}
+	}
+	return SavedSearch{}, false
+}
+
+// SetSearch adds the search or replaces the one with the same name
+func (c *Config) SetSearch(s SavedSearch) {
+	for i := range c.Searches {
+		if c.Searches[i].Name == s.Name {
+			c.Searches[i] = s
+			return
+		}
+	}
+	c.Searches = append(c.Searches, s)
+	sort.Slice(c.Searches, func(i, j int) bool { return c.Searches[i].Name < c.Searches[j].Name })
+}
+
+func (c *Config) DeleteSearch(name string) bool {
+	for i := range c.Searches {
+		if c.Searches[i].Name == name {
+			c.Searches = append(c.Searches[:i], c.Searches[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// ImportSearches adds searches from a shared library, ones with a name already saved are skipped unless overwrite is set
+func (c *Config) ImportSearches(searches []SavedSearch, overwrite bool) (added []string, skipped []string) {
+	for _, s := range searches {
+		if _, exists := c.Search(s.Name); exists && !overwrite {
+			skipped = append(skipped, s.Name)
+			continue
+		}
+		c.SetSearch(s)
+		added = append(added, s.Name)
+	}
+	return added, skipped
+}
+
+// SearchLibrary is the file saved searches are shared in
+type SearchLibrary struct {
+	Searches []SavedSearch `json:"searches"`
+}
+
+// ExportSearches is the library of the named searches, all of them when no names are given
+func (c *Config) ExportSearches(names ...string) (SearchLibrary, error) {
+	if len(names) == 0 {
+		return SearchLibrary{Searches: c.Searches}, nil
+	}
+	library := SearchLibrary{}
+	for _, name := range names {
+		s, ok := c.Search(name)
+		if !ok {
+			return library, fmt.Errorf("no saved search named %v", name)
+		}
+		library.Searches = append(library.Searches, s)
+	}
+	return library, nil
+}
+
+func (l SearchLibrary) Marshal() ([]byte, error) {
+	return yaml.Marshal(l)
+}
+
+// ReadSearchLibrary reads a shared library, every search in it has to be valid
+func ReadSearchLibrary(path string) (SearchLibrary, error) {
+	library := SearchLibrary{}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return library, err
+	}
+	if err = yaml.Unmarshal(content, &library); err != nil {
+		return library, fmt.Errorf("unable to read %v: %w", path, err)
+	}
+	for _, s := range library.Searches {
+		if err = s.Validate(); err != nil {
+			return library, fmt.Errorf("%v: %w", path, err)
+		}
+	}
+	return library, nil
+}
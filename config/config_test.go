@@ -0,0 +1,114 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSaveAndLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "kube-watcher", "config.yaml")
+	c, err := Load(path)
+	if err != nil || len(c.Profiles) != 0 {
+		t.Fatalf("expected a missing file to be an empty config got %v %v", c, err)
+	}
+
+	c.SetProfile(Profile{Name: "prod", Context: "prod-cluster", Namespace: "web", Sinks: []string{"plain", "dir:/tmp/logs"}})
+	c.SetProfile(Profile{Name: "dev", Context: "minikube", Deployment: "api", Since: "1h"})
+	c.DefaultProfile = "dev"
+	if err = c.Save(path); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(loaded.Profiles) != 2 || loaded.Profiles[0].Name != "dev" {
+		t.Fatalf("expected both profiles sorted by name got %+v", loaded.Profiles)
+	}
+	current, err := loaded.Current("")
+	if err != nil || current.Deployment != "api" {
+		t.Errorf("expected the default profile got %+v %v", current, err)
+	}
+	prod, err := loaded.Current("prod")
+	if err != nil || len(prod.Sinks) != 2 || prod.Value("namespace") != "web" {
+		t.Errorf("unexpected prod profile %+v %v", prod, err)
+	}
+	if _, err = loaded.Current("missing"); err == nil {
+		t.Errorf("expected an error for a missing profile")
+	}
+
+	now := time.Now()
+	since, err := current.SinceTime(now)
+	if err != nil || !since.Equal(now.Add(-time.Hour)) {
+		t.Errorf("expected an hour back got %v %v", since, err)
+	}
+
+	if !loaded.DeleteProfile("dev") || loaded.DefaultProfile != "" {
+		t.Errorf("expected deleting the default profile to clear it")
+	}
+	entries, _ := os.ReadDir(filepath.Dir(path))
+	if len(entries) != 1 {
+		t.Errorf("expected no temp files left behind got %v", entries)
+	}
+}
+
+func TestMerge(t *testing.T) {
+	p := Profile{Name: "dev", Context: "minikube", Namespace: "web", Sinks: []string{"console"}}
+	p = p.Merge(Profile{Namespace: "api", Sinks: []string{"jsonl"}})
+	if p.Name != "dev" || p.Context != "minikube" || p.Namespace != "api" || p.Sinks[0] != "jsonl" {
+		t.Errorf("unexpected merge %+v", p)
+	}
+}
+
+func TestSavedSearches(t *testing.T) {
+	c := &Config{}
+	c.SetSearch(SavedSearch{Name: "timeouts", Query: "timeout", Since: "30m", ContextLines: 2, Aggregate: "message"})
+	c.SetSearch(SavedSearch{Name: "errors", Query: "error", Container: "app"})
+	if c.Searches[0].Name != "errors" {
+		t.Errorf("expected the searches sorted by name got %+v", c.Searches)
+	}
+
+	s, _ := c.Search("timeouts")
+	now := time.Now()
+	params, err := s.Parameters(now)
+	if err != nil || !params.AllContainers || params.ContextLines != 2 || !params.Since.Equal(now.Add(-30*time.Minute)) {
+		t.Errorf("unexpected parameters %+v %v", params, err)
+	}
+	if err = (SavedSearch{Name: "bad", Query: "x", Aggregate: "node"}).Validate(); err == nil {
+		t.Errorf("expected an unknown aggregation to be invalid")
+	}
+	if err = (SavedSearch{Name: "bad", Query: "x", Since: "yesterday"}).Validate(); err == nil {
+		t.Errorf("expected an invalid since to be invalid")
+	}
+
+	library, err := c.ExportSearches("timeouts")
+	if err != nil || len(library.Searches) != 1 {
+		t.Fatalf("unexpected export %+v %v", library, err)
+	}
+	if _, err = c.ExportSearches("missing"); err == nil {
+		t.Errorf("expected exporting a missing search to fail")
+	}
+	content, _ := library.Marshal()
+	path := filepath.Join(t.TempDir(), "searches.yaml")
+	os.WriteFile(path, content, 0o644)
+	read, err := ReadSearchLibrary(path)
+	if err != nil || read.Searches[0].Aggregate != "message" {
+		t.Fatalf("unexpected library %+v %v", read, err)
+	}
+
+	other := &Config{Searches: []SavedSearch{{Name: "timeouts", Query: "old"}}}
+	added, skipped := other.ImportSearches(read.Searches, false)
+	if len(added) != 0 || len(skipped) != 1 || other.Searches[0].Query != "old" {
+		t.Errorf("expected the existing search kept got %v %v %+v", added, skipped, other.Searches)
+	}
+	added, _ = other.ImportSearches(read.Searches, true)
+	if len(added) != 1 || other.Searches[0].Query != "timeout" {
+		t.Errorf("expected overwrite to replace the search got %+v", other.Searches)
+	}
+	if !other.DeleteSearch("timeouts") || len(other.Searches) != 0 {
+		t.Errorf("expected the search deleted")
+	}
+}
@@ -0,0 +1,99 @@
+package daemon
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/farrjere/kube_watcher/kube"
+	"github.com/farrjere/kube_watcher/notify"
+)
+
+// notifyTimeout bounds a notification including its retries, so shutting down never waits on a dead webhook for long
+const notifyTimeout = time.Minute
+
+// alertQueueSize is how many notifications can wait to be sent, a burst beyond it is dropped as the throttle would
+const alertQueueSize = 100
+
+// alertSink is a LogSink sending a notification for the lines matching a rule.
+// Notifications are sent one at a time in the background so a slow webhook doesn't hold up the other sinks
+type alertSink struct {
+	rule     AlertRule
+	pattern  *regexp.Regexp
+	notifier notify.Notifier
+	// fired is called for every matching line, throttled or not
+	fired   func()
+	queue   chan notify.Notification
+	stopped chan struct{}
+}
+
+func newAlertSink(rule AlertRule, notifier notify.Notifier, fired func()) *alertSink {
+	a := &alertSink{
+		rule:     rule,
+		pattern:  regexp.MustCompile(rule.Pattern),
+		notifier: notifier,
+		fired:    fired,
+		queue:    make(chan notify.Notification, alertQueueSize),
+		stopped:  make(chan struct{}),
+	}
+	go a.send()
+	return a
+}
+
+// newNotifiers builds one throttled notifier per rule, shared by every workload the rule applies to
+func newNotifiers(rules []AlertRule) (map[string]notify.Notifier, error) {
+	notifiers := make(map[string]notify.Notifier, len(rules))
+	for _, r := range rules {
+		n, err := notify.New(r.Format, r.Notify)
+		if err != nil {
+			return nil, fmt.Errorf("alert rule %v: %w", r.Name, err)
+		}
+		notifiers[r.Name] = notify.NewThrottled(n, notify.DefaultThrottleOptions())
+	}
+	return notifiers, nil
+}
+
+func (a *alertSink) Write(entry kube.LogEntry) error {
+	if entry.IsEvent() || !a.pattern.MatchString(entry.Message) {
+		return nil
+	}
+	a.fired()
+	n := notify.Notification{
+		Title:      fmt.Sprintf("%v matched in %v", a.rule.Name, entry.Pod),
+		Text:       entry.Message,
+		Severity:   a.rule.Severity,
+		Source:     "alert",
+		Cluster:    entry.Cluster,
+		Namespace:  entry.Namespace,
+		Deployment: entry.Deployment,
+		Pod:        entry.Pod,
+		Lines:      []string{entry.Line()},
+		Time:       entry.Time,
+	}
+	select {
+	case a.queue <- n:
+	default:
+	}
+	return nil
+}
+
+func (a *alertSink) send() {
+	defer close(a.stopped)
+	for n := range a.queue {
+		ctx, cancel := context.WithTimeout(context.Background(), notifyTimeout)
+		err := a.notifier.Notify(ctx, n)
+		cancel()
+		if err != nil && !errors.Is(err, notify.ErrDuplicate) && !errors.Is(err, notify.ErrRateLimited) {
+			fmt.Printf("unable to send alert %v for %v: %v\n", a.rule.Name, n.Pod, err)
+		}
+	}
+}
+
+// Close waits for the notifications still waiting to be sent
+func (a *alertSink) Close() error {
+	close(a.queue)
+	<-a.stopped
+	return nil
+}
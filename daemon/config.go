@@ -0,0 +1,190 @@
+package daemon
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+
+	"github.com/farrjere/kube_watcher/kube"
+	"github.com/farrjere/kube_watcher/metrics"
+	"sigs.k8s.io/yaml"
+)
+
+/*
+Config is the YAML file kube_watcher serve runs from, e.g.
+
+	sinks: [dir:/var/log/kube-watcher]
+	alerts:
+	  - name: panics
+	    pattern: "panic:|fatal error"
+	    notify: https://hooks.slack.com/services/...
+	    format: slack
+	counters:
+	  - name: errors
+	    pattern: "level=error"
+	redaction:
+	  detectors: [all]
+	  mode: hash
+	  # without a key the hashes change whenever a workload restarts
+	  hash_key: a-long-random-secret
+	workloads:
+	  - deployment: api
+	    namespace: web
+	  - name: prod-billing
+	    context: prod
+	    namespace: billing
+	    deployment: billing
+	    sinks: [http:https://logs.example.com/ingest]
+*/
+type Config struct {
+	// Sinks are used by every workload that doesn't set its own
+	Sinks     []string    `json:"sinks,omitempty"`
+	Alerts    []AlertRule `json:"alerts,omitempty"`
+	Workloads []Workload  `json:"workloads"`
+
+	// Counters are exported on the metrics endpoint when serve has one
+	Counters []metrics.LogCounter `json:"counters,omitempty"`
+	// Redaction is applied to every workload's lines and events before they reach the sinks and alerts
+	Redaction kube.RedactOptions `json:"redaction,omitempty"`
+}
+
+type Workload struct {
+	// Name is how alert rules refer to the workload, it defaults to context/namespace/deployment
+	Name       string   `json:"name,omitempty"`
+	Kubeconfig string   `json:"kubeconfig,omitempty"`
+	Context    string   `json:"context,omitempty"`
+	Namespace  string   `json:"namespace,omitempty"`
+	Deployment string   `json:"deployment"`
+	Sinks      []string `json:"sinks,omitempty"`
+}
+
+func (w Workload) String() string {
+	if w.Name != "" {
+		return w.Name
+	}
+	return fmt.Sprintf("%v/%v/%v", orCurrent(w.Context), orCurrent(w.Namespace), w.Deployment)
+}
+
+func orCurrent(s string) string {
+	if s == "" {
+		return "current"
+	}
+	return s
+}
+
+// AlertRule sends a notification for every line matching Pattern, throttled so a noisy line can't flood the channel
+type AlertRule struct {
+	Name    string `json:"name"`
+	Pattern string `json:"pattern"`
+	// Notify is the webhook url, Format is webhook, slack or teams
+	Notify   string `json:"notify"`
+	Format   string `json:"format,omitempty"`
+	Severity string `json:"severity,omitempty"`
+	// Workloads limits the rule to the workloads with these names, all of them when empty
+	Workloads []string `json:"workloads,omitempty"`
+}
+
+func (r AlertRule) appliesTo(w Workload) bool {
+	return appliesTo(r.Workloads, w)
+}
+
+func appliesTo(workloads []string, w Workload) bool {
+	if len(workloads) == 0 {
+		return true
+	}
+	for _, name := range workloads {
+		if name == w.String() {
+			return true
+		}
+	}
+	return false
+}
+
+func LoadConfig(path string) (*Config, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	c := Config{}
+	if err = yaml.Unmarshal(content, &c); err != nil {
+		return nil, fmt.Errorf("unable to read %v: %w", path, err)
+	}
+	if err = c.Validate(); err != nil {
+		return nil, fmt.Errorf("%v: %w", path, err)
+	}
+	return &c, nil
+}
+
+func (c *Config) Validate() error {
+	names := make(map[string]bool)
+	for _, w := range c.Workloads {
+		if w.Deployment == "" {
+			return fmt.Errorf("workload %v needs a deployment", w)
+		}
+		if names[w.String()] {
+			return fmt.Errorf("workload %v is listed twice", w)
+		}
+		names[w.String()] = true
+	}
+	rules := make(map[string]bool)
+	for _, r := range c.Alerts {
+		if r.Name == "" || r.Pattern == "" || r.Notify == "" {
+			return fmt.Errorf("alert rule %q needs a name, pattern and notify url", r.Name)
+		}
+		// each rule has its own notifier and throttle, found by name
+		if rules[r.Name] {
+			return fmt.Errorf("alert rule %v is listed twice", r.Name)
+		}
+		rules[r.Name] = true
+		if _, err := regexp.Compile(r.Pattern); err != nil {
+			return fmt.Errorf("alert rule %v has an invalid pattern: %w", r.Name, err)
+		}
+		for _, name := range r.Workloads {
+			if !names[name] {
+				return fmt.Errorf("alert rule %v refers to unknown workload %v", r.Name, name)
+			}
+		}
+	}
+	if err := c.Redaction.Validate(); err != nil {
+		return err
+	}
+	for _, counter := range c.Counters {
+		if err := counter.Validate(); err != nil {
+			return err
+		}
+		for _, name := range counter.Workloads {
+			if !names[name] {
+				return fmt.Errorf("counter %v refers to unknown workload %v", counter.Name, name)
+			}
+		}
+	}
+	return nil
+}
+
+// sinks are the workload's own sinks or the shared ones
+func (c *Config) sinks(w Workload) []string {
+	if len(w.Sinks) > 0 {
+		return w.Sinks
+	}
+	return c.Sinks
+}
+
+func (c *Config) alerts(w Workload) []AlertRule {
+	var rules []AlertRule
+	for _, r := range c.Alerts {
+		if r.appliesTo(w) {
+			rules = append(rules, r)
+		}
+	}
+	return rules
+}
+
+func (c *Config) counters(w Workload) []metrics.LogCounter {
+	var counters []metrics.LogCounter
+	for _, counter := range c.Counters {
+		if appliesTo(counter.Workloads, w) {
+			counters = append(counters, counter)
+		}
+	}
+	return counters
+}
@@ -0,0 +1,216 @@
+package daemon
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/farrjere/kube_watcher/kube"
+	"github.com/farrjere/kube_watcher/metrics"
+	"github.com/farrjere/kube_watcher/sink"
+)
+
+// Daemon keeps a DeploymentWatcher streaming for every workload in its config.
+// The watchers follow rollouts themselves, the daemon only starts and stops them as the config changes
+type Daemon struct {
+	mu      sync.Mutex
+	running map[string]*runningWorkload
+	// newClient connects to the workload's cluster, replaced in tests
+	newClient func(Workload) (*kube.KubeClient, error)
+	// retry is how long to wait before connecting again when a cluster can't be reached
+	retry   time.Duration
+	metrics *metrics.Metrics
+}
+
+// workloadSpec is everything a running workload was started with, a reload restarts it if any of it changes
+type workloadSpec struct {
+	Workload Workload
+	Sinks    []string
+	Alerts   []AlertRule
+	Counters []metrics.LogCounter
+
+	Redaction kube.RedactOptions
+}
+
+type runningWorkload struct {
+	spec   workloadSpec
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+func New() *Daemon {
+	return &Daemon{running: make(map[string]*runningWorkload), newClient: newClient, retry: 30 * time.Second}
+}
+
+// SetMetrics reports the workloads started from now on to m
+func (d *Daemon) SetMetrics(m *metrics.Metrics) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.metrics = m
+}
+
+func newClient(w Workload) (*kube.KubeClient, error) {
+	flags := kube.NewConfigFlags(w.Kubeconfig, w.Context)
+	if w.Namespace != "" {
+		flags.Namespace = &w.Namespace
+	}
+	kc, err := kube.NewKubeClientFromFlags(flags)
+	if err != nil {
+		return nil, err
+	}
+	if w.Context != "" {
+		kc.SetCluster(w.Context)
+	}
+	return kc, nil
+}
+
+// Apply starts the workloads that are new or changed and stops the ones no longer in the config.
+// If any sink can't be opened nothing is changed, so a bad reload leaves the daemon running as it was
+func (d *Daemon) Apply(c *Config) error {
+	if err := c.Validate(); err != nil {
+		return err
+	}
+	notifiers, err := newNotifiers(c.Alerts)
+	if err != nil {
+		return err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	wanted := make(map[string]workloadSpec, len(c.Workloads))
+	for _, w := range c.Workloads {
+		wanted[w.String()] = workloadSpec{Workload: w, Sinks: c.sinks(w), Alerts: c.alerts(w), Counters: c.counters(w), Redaction: c.Redaction}
+	}
+
+	starting := make(map[string][]kube.LogSink)
+	for name, spec := range wanted {
+		if r, ok := d.running[name]; ok && reflect.DeepEqual(r.spec, spec) {
+			continue
+		}
+		name := name
+		sinks, err := sink.ParseAllWith(spec.Sinks, sink.ParseOptions{
+			KafkaFailed: func(topic string, entries int) { d.metrics.KafkaFailed(name, topic, entries) },
+		})
+		if err != nil {
+			for _, opened := range starting {
+				for _, s := range opened {
+					err = errors.Join(err, s.Close())
+				}
+			}
+			return fmt.Errorf("workload %v: %w", name, err)
+		}
+		for i := range sinks {
+			kind := sinkKind(spec.Sinks[i])
+			sinks[i] = newReportingSink(sinks[i], name, kind, func() { d.metrics.SinkError(name, kind) })
+		}
+		for _, rule := range spec.Alerts {
+			rule := rule
+			sinks = append(sinks, newAlertSink(rule, notifiers[rule.Name], func() { d.metrics.AlertFired(rule.Name, name) }))
+		}
+		if d.metrics != nil {
+			sinks = append(sinks, d.metrics.Sink(name, spec.Counters))
+		}
+		starting[name] = sinks
+	}
+
+	for name, r := range d.running {
+		if _, changed := starting[name]; changed || !hasSpec(wanted, name) {
+			fmt.Printf("Stopping %v\n", name)
+			r.stop()
+			delete(d.running, name)
+		}
+	}
+	for name, sinks := range starting {
+		fmt.Printf("Watching %v\n", name)
+		d.running[name] = d.start(wanted[name], sinks)
+	}
+	return nil
+}
+
+func hasSpec(specs map[string]workloadSpec, name string) bool {
+	_, ok := specs[name]
+	return ok
+}
+
+func (d *Daemon) start(spec workloadSpec, sinks []kube.LogSink) *runningWorkload {
+	ctx, cancel := context.WithCancel(context.Background())
+	r := &runningWorkload{spec: spec, cancel: cancel, done: make(chan struct{})}
+	go func() {
+		defer close(r.done)
+		d.run(ctx, spec.Workload, spec.Redaction, sinks)
+	}()
+	return r
+}
+
+// run connects to the workload's cluster, retrying until it can, then streams until the context is done.
+// The sinks are closed, flushing anything buffered, whichever way it ends
+func (d *Daemon) run(ctx context.Context, w Workload, redaction kube.RedactOptions, sinks []kube.LogSink) {
+	var kc *kube.KubeClient
+	for ctx.Err() == nil {
+		var err error
+		kc, err = d.newClient(w)
+		if err == nil {
+			break
+		}
+		fmt.Printf("Unable to connect for %v, retrying in %v: %v\n", w, d.retry, err)
+		d.metrics.Reconnect(w.String())
+		d.metrics.APIError(err)
+		select {
+		case <-ctx.Done():
+		case <-time.After(d.retry):
+		}
+	}
+	if ctx.Err() != nil {
+		for _, s := range sinks {
+			s.Close()
+		}
+		return
+	}
+	if redaction.Enabled() {
+		// validated with the config
+		redactor, _ := kube.NewRedactor(redaction)
+		kc.SetRedactor(redactor)
+	}
+	dl := kube.NewDeploymentWatcher(w.Deployment, kc, ctx)
+	d.metrics.Watch(w.String(), dl)
+	defer d.metrics.Unwatch(w.String(), dl)
+	if err := dl.StreamTo(sinks...); err != nil {
+		fmt.Printf("Error writing logs for %v: %v\n", w, err)
+	}
+}
+
+// stop cancels the workload and waits for its sinks to be flushed and closed
+func (r *runningWorkload) stop() {
+	r.cancel()
+	<-r.done
+}
+
+// Workloads are the names of the running workloads
+func (d *Daemon) Workloads() []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	names := make([]string, 0, len(d.running))
+	for name := range d.running {
+		names = append(names, name)
+	}
+	return names
+}
+
+// Stop stops every workload, returning once all their sinks have been flushed
+func (d *Daemon) Stop() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var wg sync.WaitGroup
+	for name, r := range d.running {
+		wg.Add(1)
+		go func(r *runningWorkload) {
+			defer wg.Done()
+			r.stop()
+		}(r)
+		delete(d.running, name)
+	}
+	wg.Wait()
+}
@@ -0,0 +1,185 @@
+package daemon
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/farrjere/kube_watcher/kube"
+	"github.com/farrjere/kube_watcher/metrics"
+	"github.com/farrjere/kube_watcher/notify"
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestLoadConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "serve.yaml")
+	os.WriteFile(path, []byte(`
+sinks: [plain]
+alerts:
+  - name: panics
+    pattern: "panic:"
+    notify: http://localhost/hook
+    workloads: [billing]
+workloads:
+  - deployment: api
+    namespace: web
+  - name: billing
+    context: prod
+    deployment: billing
+    sinks: [jsonl]
+`), 0o644)
+	c, err := LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	api, billing := c.Workloads[0], c.Workloads[1]
+	if api.String() != "current/web/api" || c.sinks(api)[0] != "plain" || c.sinks(billing)[0] != "jsonl" {
+		t.Errorf("unexpected workloads %v %v", api, billing)
+	}
+	if len(c.alerts(api)) != 0 || len(c.alerts(billing)) != 1 {
+		t.Errorf("expected the alert only on billing")
+	}
+
+	invalid := []Config{
+		{Workloads: []Workload{{Namespace: "web"}}},
+		{Workloads: []Workload{{Deployment: "api"}, {Deployment: "api"}}},
+		{Workloads: []Workload{{Deployment: "api"}}, Alerts: []AlertRule{{Name: "bad", Pattern: "(", Notify: "http://localhost"}}},
+		{Workloads: []Workload{{Deployment: "api"}}, Alerts: []AlertRule{{Name: "a", Pattern: "x", Notify: "http://localhost", Workloads: []string{"missing"}}}},
+		{Workloads: []Workload{{Deployment: "api"}}, Alerts: []AlertRule{{Name: "a", Pattern: "x", Notify: "http://localhost"}, {Name: "a", Pattern: "y", Notify: "http://localhost"}}},
+		{Workloads: []Workload{{Deployment: "api"}}, Counters: []metrics.LogCounter{{Name: "errors", Pattern: "("}}},
+		{Workloads: []Workload{{Deployment: "api"}}, Redaction: kube.RedactOptions{Detectors: []string{"ssn"}}},
+	}
+	for _, c := range invalid {
+		if err = c.Validate(); err == nil {
+			t.Errorf("expected %+v to be invalid", c)
+		}
+	}
+}
+
+func TestDaemon(t *testing.T) {
+	labels := map[string]string{"app": "web"}
+	clientset := fake.NewSimpleClientset(
+		&appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default", Labels: labels},
+			Spec:       appsv1.DeploymentSpec{Selector: &metav1.LabelSelector{MatchLabels: labels}},
+		},
+		&v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "web-1", Namespace: "default", Labels: labels},
+			Spec:       v1.PodSpec{Containers: []v1.Container{{Name: "main"}}},
+			Status:     v1.PodStatus{Phase: v1.PodRunning},
+		},
+	)
+	var alerts atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		alerts.Add(1)
+	}))
+	defer server.Close()
+
+	var connects atomic.Int32
+	d := New()
+	d.newClient = func(w Workload) (*kube.KubeClient, error) {
+		connects.Add(1)
+		return kube.NewKubeClientFromClientset(clientset), nil
+	}
+	defer d.Stop()
+
+	path := filepath.Join(t.TempDir(), "all.log")
+	c := &Config{
+		Sinks:     []string{"file:" + path},
+		Alerts:    []AlertRule{{Name: "fake", Pattern: "fake", Notify: server.URL}},
+		Workloads: []Workload{{Name: "web", Deployment: "web"}},
+	}
+	if err := d.Apply(c); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(10 * time.Second)
+	for alerts.Load() == 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for the alert")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if err := d.Apply(c); err != nil || connects.Load() != 1 {
+		t.Errorf("expected an unchanged workload to keep running got %v connects %v", connects.Load(), err)
+	}
+	bad := &Config{Workloads: []Workload{{Name: "web", Deployment: "web", Sinks: []string{"bogus"}}}}
+	if err := d.Apply(bad); err == nil || len(d.Workloads()) != 1 || connects.Load() != 1 {
+		t.Errorf("expected a bad reload to leave the workload running got %v", err)
+	}
+
+	if err := d.Apply(&Config{}); err != nil || len(d.Workloads()) != 0 {
+		t.Fatalf("expected the workload stopped got %v %v", d.Workloads(), err)
+	}
+	content, err := os.ReadFile(path)
+	if err != nil || !strings.Contains(string(content), "fake logs") {
+		t.Errorf("expected the logs flushed on stop got %q %v", content, err)
+	}
+}
+
+type failingSink struct{}
+
+func (failingSink) Write(entry kube.LogEntry) error {
+	return errors.New("receiver is down")
+}
+
+func (failingSink) Close() error {
+	return nil
+}
+
+func TestReportingSink(t *testing.T) {
+	failed := 0
+	s := newReportingSink(failingSink{}, "web", sinkKind("loki:http://localhost:3100"), func() { failed++ })
+	now := time.Now()
+	s.now = func() time.Time { return now }
+	for i := 0; i < 3; i++ {
+		if err := s.Write(kube.LogEntry{Message: "hi"}); err == nil {
+			t.Errorf("expected the error passed on")
+		}
+	}
+	if failed != 3 || s.suppressed != 2 || s.kind != "loki" {
+		t.Errorf("expected every error counted and all but the first held back got %v %v %v", failed, s.suppressed, s.kind)
+	}
+	now = now.Add(sinkErrorInterval)
+	s.Write(kube.LogEntry{Message: "hi"})
+	if s.suppressed != 0 || !s.logged.Equal(now) {
+		t.Errorf("expected the error logged once the interval passed")
+	}
+}
+
+// slowNotifier records how many notifications are being sent at once
+type slowNotifier struct {
+	sending, most, sent atomic.Int32
+}
+
+func (n *slowNotifier) Notify(ctx context.Context, _ notify.Notification) error {
+	if now := n.sending.Add(1); now > n.most.Load() {
+		n.most.Store(now)
+	}
+	time.Sleep(time.Millisecond)
+	n.sending.Add(-1)
+	n.sent.Add(1)
+	return nil
+}
+
+func TestAlertSinkSendsOneAtATime(t *testing.T) {
+	n := &slowNotifier{}
+	a := newAlertSink(AlertRule{Name: "errors", Pattern: "error"}, n, func() {})
+	for i := 0; i < 1000; i++ {
+		a.Write(kube.LogEntry{Pod: "web-1", Kind: kube.EntryLog, Message: "error"})
+	}
+	a.Close()
+	if n.most.Load() != 1 || n.sent.Load() > alertQueueSize+1 {
+		t.Errorf("expected a burst sent one at a time and the overflow dropped got %v at once, %v sent", n.most.Load(), n.sent.Load())
+	}
+}
@@ -0,0 +1,55 @@
+package daemon
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/farrjere/kube_watcher/kube"
+)
+
+// sinkErrorInterval is the least time between logging a sink's errors, the ones in between are only counted
+const sinkErrorInterval = time.Minute
+
+// reportingSink logs a sink's write errors as they happen, so a dead sink is noticed while the daemon runs
+// rather than once the workload is stopped. failed is called for every error
+type reportingSink struct {
+	kube.LogSink
+	workload string
+	kind     string
+	failed   func()
+	now      func() time.Time
+	// logged is when an error was last logged, suppressed counts the errors since
+	logged     time.Time
+	suppressed int
+}
+
+func newReportingSink(s kube.LogSink, workload string, kind string, failed func()) *reportingSink {
+	return &reportingSink{LogSink: s, workload: workload, kind: kind, failed: failed, now: time.Now}
+}
+
+// sinkKind is the type of sink a spec opens, e.g. loki, leaving out the address which can hold credentials
+func sinkKind(spec string) string {
+	kind, _, _ := strings.Cut(spec, ":")
+	return kind
+}
+
+func (s *reportingSink) Write(entry kube.LogEntry) error {
+	err := s.LogSink.Write(entry)
+	if err == nil {
+		return nil
+	}
+	s.failed()
+	if now := s.now(); now.Sub(s.logged) >= sinkErrorInterval {
+		message := fmt.Sprintf("Error writing to the %v sink for %v: %v", s.kind, s.workload, err)
+		if s.suppressed > 0 {
+			message += fmt.Sprintf(" (%v more errors since the last)", s.suppressed)
+		}
+		fmt.Println(message)
+		s.logged = now
+		s.suppressed = 0
+	} else {
+		s.suppressed++
+	}
+	return err
+}
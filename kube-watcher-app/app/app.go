@@ -2,10 +2,14 @@ package app
 
 import (
 	"context"
+	"fmt"
+	"github.com/farrjere/kube_watcher/config"
 	"github.com/farrjere/kube_watcher/kube"
 	"github.com/farrjere/kube_watcher/kube-watcher-app/ui"
 	"github.com/skratchdot/open-golang/open"
 	wailsRuntime "github.com/wailsapp/wails/v2/pkg/runtime"
+	"os"
+	"time"
 )
 
 // App struct
@@ -16,17 +20,21 @@ type App struct {
 	cancelFunc    context.CancelFunc
 	CancelChannel chan string
 	ui            *ui.UI
+	// streamOptions drop a slow pane's oldest lines rather than holding up every pod
+	streamOptions kube.SubscribeOptions
 }
 
 type PodLogMessage struct {
 	Message string `json:"message"`
 	Pod     string `json:"pod"`
+	Kind    string `json:"kind"`
+	Level   string `json:"level"`
 }
 
 // NewApp creates a new App application struct
 func NewApp(ui *ui.UI) *App {
 	c := make(chan string)
-	return &App{ui: ui, CancelChannel: c}
+	return &App{ui: ui, CancelChannel: c, streamOptions: kube.SubscribeOptions{Buffer: 1000, Overflow: kube.OverflowDropOldest}}
 }
 
 func (a *App) Test() PodLogMessage {
@@ -40,7 +48,7 @@ func (a *App) Startup(ctx context.Context) {
 }
 
 func (a *App) GetContexts() []string {
-	return kube.AvailableContexts("")
+	return kube.AvailableContexts(kube.NewConfigFlags("", ""))
 }
 
 func (a *App) GetNamespaces() []string {
@@ -60,60 +68,272 @@ func (a *App) SetNamespace(namespace string) {
 
 func (a *App) SetDeployment(deployment string) []string {
 	wailsRuntime.LogInfo(a.ctx, "Called set deployment")
-	ctx := context.Background()
+	if a.cancelFunc != nil {
+		a.cancelFunc()
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	a.cancelFunc = cancel
 	a.watcher = kube.NewDeploymentWatcher(deployment, a.kubeClient, ctx)
 	return a.watcher.GetPods()
 }
 
+func (a *App) PodStatuses() []kube.Pod {
+	wailsRuntime.LogInfo(a.ctx, "Called pod statuses")
+	return a.watcher.PodStatuses()
+}
+
 func (a *App) CancelPodStream(pod string) {
 	wailsRuntime.LogInfof(a.ctx, "Called cancel pod stream for pod %v", pod)
 	a.CancelChannel <- pod
 	wailsRuntime.LogInfof(a.ctx, "Messages in channel %v", len(a.CancelChannel))
 }
 
-func (a *App) Save() {
+func (a *App) Save(options kube.ExportOptions) {
 	dir := a.ui.ChooseDir("")
-	a.watcher.LogAllPodsToDisk(dir, 0)
-	err := open.Run(dir)
+	if dir == "" {
+		return
+	}
+	_, err := a.watcher.Export(dir, 0, options)
+	if err != nil {
+		wailsRuntime.LogError(a.ctx, err.Error())
+		return
+	}
+	err = open.Run(dir)
 	if err != nil {
 		wailsRuntime.LogError(a.ctx, err.Error())
 	}
 	wailsRuntime.LogInfo(a.ctx, "Saved logs to disk")
 }
 
-func (a *App) Search(query string, limit int64) []kube.SearchResult {
+func (a *App) Bundle() {
+	dir := a.ui.ChooseDir("")
+	if dir == "" {
+		return
+	}
+	path, err := a.watcher.WriteDiagnosticsBundle(dir, 0)
+	if err != nil {
+		wailsRuntime.LogError(a.ctx, err.Error())
+		return
+	}
+	err = open.Run(dir)
+	if err != nil {
+		wailsRuntime.LogError(a.ctx, err.Error())
+	}
+	wailsRuntime.LogInfof(a.ctx, "Saved diagnostics bundle to %v", path)
+}
+
+func (a *App) Search(query string, limit int64, memoryAbove float64) []kube.SearchResult {
 	wailsRuntime.LogInfo(a.ctx, "Search called")
-	params := kube.SearchParameters{Query: query, AllContainers: true, Limit: limit}
+	params := kube.SearchParameters{Query: query, AllContainers: true, Limit: limit, MemoryAbovePercent: memoryAbove}
 	results := a.watcher.SearchLogs(params)
 	return results
 }
 
 func (a *App) Stream() {
 	wailsRuntime.LogInfo(a.ctx, "Stream called")
-	podContexts := a.watcher.StreamLogs()
-	for {
-		for name, pc := range podContexts {
+	watcher := a.watcher
+	watcher.OnPodStatus(func(pods []kube.Pod) {
+		wailsRuntime.EventsEmit(a.ctx, "pod_status", pods)
+	})
+	err := watcher.SetStreamOptions(a.streamOptions)
+	if err != nil {
+		wailsRuntime.LogError(a.ctx, err.Error())
+		return
+	}
+	entries := watcher.Stream()
+	go watcher.PollMetrics(15*time.Second, func(sample kube.MetricsSample) {
+		wailsRuntime.EventsEmit(a.ctx, "pod_metrics", &sample)
+	})
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		for {
 			select {
 			case m := <-a.CancelChannel:
 				wailsRuntime.LogInfof(a.ctx, "Canceling pod %v", m)
-				podContexts[m].Cancel()
-			case m := <-pc.PodLog.Messages:
-				event := PodLogMessage{Message: m, Pod: name}
-				wailsRuntime.EventsEmit(a.ctx, "pod_log", &event)
+				watcher.CancelPod(m)
+			case <-done:
+				return
 			}
 		}
+	}()
+	go func() {
+		ticker := time.NewTicker(2 * time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				wailsRuntime.EventsEmit(a.ctx, "stream_stats", watcher.StreamStats())
+			case <-done:
+				return
+			}
+		}
+	}()
+	err = kube.FanOut(entries, &eventSink{ctx: a.ctx})
+	if err != nil {
+		wailsRuntime.LogError(a.ctx, err.Error())
+	}
+}
+
+// SetStreamOptions sets the buffer and overflow policy of the next Stream
+func (a *App) SetStreamOptions(opts kube.SubscribeOptions) error {
+	if !kube.ValidOverflow(opts.Overflow) {
+		return fmt.Errorf("unknown overflow policy %q", opts.Overflow)
+	}
+	a.streamOptions = opts
+	return nil
+}
+
+// StreamStats are the lines each pod has streamed, delivered and dropped
+func (a *App) StreamStats() []kube.StreamStats {
+	if a.watcher == nil {
+		return nil
 	}
+	return a.watcher.StreamStats()
+}
+
+// GetConfig is the profiles shared with the command line
+func (a *App) GetConfig() *config.Config {
+	c, err := config.Load(config.DefaultPath())
+	if err != nil {
+		wailsRuntime.LogError(a.ctx, err.Error())
+		return &config.Config{}
+	}
+	return c
+}
+
+// SaveProfile creates or updates a profile, settings the desktop app doesn't show like sinks are kept
+func (a *App) SaveProfile(profile config.Profile) error {
+	path := config.DefaultPath()
+	c, err := config.Load(path)
+	if err != nil {
+		return err
+	}
+	existing, ok := c.Profile(profile.Name)
+	if ok {
+		profile = existing.Merge(profile)
+	}
+	c.SetProfile(profile)
+	return c.Save(path)
+}
+
+func (a *App) DeleteProfile(name string) error {
+	path := config.DefaultPath()
+	c, err := config.Load(path)
+	if err != nil {
+		return err
+	}
+	c.DeleteProfile(name)
+	return c.Save(path)
+}
+
+type SavedSearchResult struct {
+	Search     config.SavedSearch  `json:"search"`
+	Results    []kube.SearchResult `json:"results"`
+	Aggregates []kube.Aggregate    `json:"aggregates"`
+}
+
+// RunSavedSearch runs a search from the library, aggregates are only filled in when the search aggregates
+func (a *App) RunSavedSearch(name string) (*SavedSearchResult, error) {
+	wailsRuntime.LogInfof(a.ctx, "RunSavedSearch called for %v", name)
+	c, err := config.Load(config.DefaultPath())
+	if err != nil {
+		return nil, err
+	}
+	search, ok := c.Search(name)
+	if !ok {
+		return nil, fmt.Errorf("no saved search named %v", name)
+	}
+	params, err := search.Parameters(time.Now())
+	if err != nil {
+		return nil, err
+	}
+	result := &SavedSearchResult{Search: search, Results: a.watcher.SearchLogs(params)}
+	if search.Aggregate != "" {
+		result.Aggregates, err = kube.AggregateResults(result.Results, search.Query, search.Aggregate)
+	}
+	return result, err
+}
+
+func (a *App) SaveSearch(search config.SavedSearch) error {
+	if err := search.Validate(); err != nil {
+		return err
+	}
+	path := config.DefaultPath()
+	c, err := config.Load(path)
+	if err != nil {
+		return err
+	}
+	c.SetSearch(search)
+	return c.Save(path)
+}
+
+func (a *App) DeleteSearch(name string) error {
+	path := config.DefaultPath()
+	c, err := config.Load(path)
+	if err != nil {
+		return err
+	}
+	c.DeleteSearch(name)
+	return c.Save(path)
+}
+
+// ImportSearches adds the searches from a shared library file, keeping the saved ones with the same name
+func (a *App) ImportSearches() ([]string, error) {
+	file := a.ui.OpenYAMLFile("Import saved searches")
+	if file == "" {
+		return nil, nil
+	}
+	library, err := config.ReadSearchLibrary(file)
+	if err != nil {
+		return nil, err
+	}
+	path := config.DefaultPath()
+	c, err := config.Load(path)
+	if err != nil {
+		return nil, err
+	}
+	added, skipped := c.ImportSearches(library.Searches, false)
+	if len(skipped) > 0 {
+		wailsRuntime.LogInfof(a.ctx, "Skipped importing %v, they are already saved", skipped)
+	}
+	return added, c.Save(path)
+}
+
+func (a *App) ExportSearches() error {
+	file := a.ui.SaveYAMLFile("Export saved searches")
+	if file == "" {
+		return nil
+	}
+	library, err := a.GetConfig().ExportSearches()
+	if err != nil {
+		return err
+	}
+	content, err := library.Marshal()
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(file, content, 0o644)
 }
 
 func (a *App) LoadCluster(path string, context string) {
-	config := kube.ConfigParameters{Path: path, Context: context}
-	restConfig, err := kube.LoadConfig(config)
+	kubeClient, err := kube.NewKubeClientFromFlags(kube.NewConfigFlags(path, context))
 	if err != nil {
 		wailsRuntime.LogErrorf(a.ctx, "%v - %v - %v", err, path, context)
 		return
 	}
-	a.kubeClient = kube.NewKubeClient(restConfig)
+	c, err := config.Load(config.DefaultPath())
 	if err != nil {
+		wailsRuntime.LogErrorf(a.ctx, "unable to load the redaction config: %v", err)
 		return
 	}
+	if c.Redaction != nil && c.Redaction.Enabled() {
+		redactor, err := kube.NewRedactor(*c.Redaction)
+		if err != nil {
+			wailsRuntime.LogErrorf(a.ctx, "unable to setup redaction: %v", err)
+			return
+		}
+		kubeClient.SetRedactor(redactor)
+	}
+	a.kubeClient = kubeClient
 }
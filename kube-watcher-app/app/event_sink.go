@@ -0,0 +1,22 @@
+package app
+
+import (
+	"context"
+	"github.com/farrjere/kube_watcher/kube"
+	wailsRuntime "github.com/wailsapp/wails/v2/pkg/runtime"
+)
+
+// eventSink hands log entries to the frontend as pod_log events
+type eventSink struct {
+	ctx context.Context
+}
+
+func (s *eventSink) Write(entry kube.LogEntry) error {
+	event := PodLogMessage{Message: entry.Line(), Pod: entry.Pod, Kind: entry.Kind, Level: entry.Level}
+	wailsRuntime.EventsEmit(s.ctx, "pod_log", &event)
+	return nil
+}
+
+func (s *eventSink) Close() error {
+	return nil
+}
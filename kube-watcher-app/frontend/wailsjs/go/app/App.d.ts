@@ -3,27 +3,52 @@
 import {kube} from '../models';
 import {context} from '../models';
 import {app} from '../models';
+import {config} from '../models';
+
+export function Bundle():Promise<void>;
 
 export function CancelPodStream(arg1:string):Promise<void>;
 
+export function DeleteProfile(arg1:string):Promise<void>;
+
+export function DeleteSearch(arg1:string):Promise<void>;
+
+export function ExportSearches():Promise<void>;
+
+export function GetConfig():Promise<config.Config>;
+
 export function GetContexts():Promise<Array<string>>;
 
 export function GetDeployments():Promise<Array<string>>;
 
 export function GetNamespaces():Promise<Array<string>>;
 
+export function ImportSearches():Promise<Array<string>>;
+
 export function LoadCluster(arg1:string,arg2:string):Promise<void>;
 
-export function Save():Promise<void>;
+export function PodStatuses():Promise<Array<kube.Pod>>;
 
-export function Search(arg1:string,arg2:number):Promise<Array<kube.SearchResult>>;
+export function RunSavedSearch(arg1:string):Promise<app.SavedSearchResult>;
+
+export function SaveProfile(arg1:config.Profile):Promise<void>;
+
+export function SaveSearch(arg1:config.SavedSearch):Promise<void>;
+
+export function Save(arg1:kube.ExportOptions):Promise<void>;
+
+export function Search(arg1:string,arg2:number,arg3:number):Promise<Array<kube.SearchResult>>;
 
 export function SetDeployment(arg1:string):Promise<Array<string>>;
 
 export function SetNamespace(arg1:string):Promise<void>;
 
+export function SetStreamOptions(arg1:kube.SubscribeOptions):Promise<void>;
+
 export function Startup(arg1:context.Context):Promise<void>;
 
 export function Stream():Promise<void>;
 
+export function StreamStats():Promise<Array<kube.StreamStats>>;
+
 export function Test():Promise<app.PodLogMessage>;
@@ -2,10 +2,30 @@
 // Cynhyrchwyd y ffeil hon yn awtomatig. PEIDIWCH Â MODIWL
 // This file is automatically generated. DO NOT EDIT
 
+export function Bundle() {
+  return window['go']['app']['App']['Bundle']();
+}
+
 export function CancelPodStream(arg1) {
   return window['go']['app']['App']['CancelPodStream'](arg1);
 }
 
+export function DeleteProfile(arg1) {
+  return window['go']['app']['App']['DeleteProfile'](arg1);
+}
+
+export function DeleteSearch(arg1) {
+  return window['go']['app']['App']['DeleteSearch'](arg1);
+}
+
+export function ExportSearches() {
+  return window['go']['app']['App']['ExportSearches']();
+}
+
+export function GetConfig() {
+  return window['go']['app']['App']['GetConfig']();
+}
+
 export function GetContexts() {
   return window['go']['app']['App']['GetContexts']();
 }
@@ -18,16 +38,36 @@ export function GetNamespaces() {
   return window['go']['app']['App']['GetNamespaces']();
 }
 
+export function ImportSearches() {
+  return window['go']['app']['App']['ImportSearches']();
+}
+
 export function LoadCluster(arg1, arg2) {
   return window['go']['app']['App']['LoadCluster'](arg1, arg2);
 }
 
-export function Save() {
-  return window['go']['app']['App']['Save']();
+export function PodStatuses() {
+  return window['go']['app']['App']['PodStatuses']();
 }
 
-export function Search(arg1, arg2) {
-  return window['go']['app']['App']['Search'](arg1, arg2);
+export function RunSavedSearch(arg1) {
+  return window['go']['app']['App']['RunSavedSearch'](arg1);
+}
+
+export function Save(arg1) {
+  return window['go']['app']['App']['Save'](arg1);
+}
+
+export function Search(arg1, arg2, arg3) {
+  return window['go']['app']['App']['Search'](arg1, arg2, arg3);
+}
+
+export function SaveProfile(arg1) {
+  return window['go']['app']['App']['SaveProfile'](arg1);
+}
+
+export function SaveSearch(arg1) {
+  return window['go']['app']['App']['SaveSearch'](arg1);
 }
 
 export function SetDeployment(arg1) {
@@ -38,6 +78,10 @@ export function SetNamespace(arg1) {
   return window['go']['app']['App']['SetNamespace'](arg1);
 }
 
+export function SetStreamOptions(arg1) {
+  return window['go']['app']['App']['SetStreamOptions'](arg1);
+}
+
 export function Startup(arg1) {
   return window['go']['app']['App']['Startup'](arg1);
 }
@@ -46,6 +90,10 @@ export function Stream() {
   return window['go']['app']['App']['Stream']();
 }
 
+export function StreamStats() {
+  return window['go']['app']['App']['StreamStats']();
+}
+
 export function Test() {
   return window['go']['app']['App']['Test']();
 }
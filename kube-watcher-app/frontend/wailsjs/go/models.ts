@@ -3,6 +3,8 @@ export namespace app {
 	export class PodLogMessage {
 	    message: string;
 	    pod: string;
+	    kind: string;
+	    level: string;
 	
 	    static createFrom(source: any = {}) {
 	        return new PodLogMessage(source);
@@ -12,13 +14,290 @@ export namespace app {
 	        if ('string' === typeof source) source = JSON.parse(source);
 	        this.message = source["message"];
 	        this.pod = source["pod"];
+	        this.kind = source["kind"];
+	        this.level = source["level"];
 	    }
 	}
+	export class SavedSearchResult {
+	    search: config.SavedSearch;
+	    results: kube.SearchResult[];
+	    aggregates: kube.Aggregate[];
+	
+	    static createFrom(source: any = {}) {
+	        return new SavedSearchResult(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.search = this.convertValues(source["search"], config.SavedSearch);
+	        this.results = this.convertValues(source["results"], kube.SearchResult);
+	        this.aggregates = this.convertValues(source["aggregates"], kube.Aggregate);
+	    }
+	
+		convertValues(a: any, classs: any, asMap: boolean = false): any {
+		    if (!a) {
+		        return a;
+		    }
+		    if (a.slice && a.map) {
+		        return (a as any[]).map(elem => this.convertValues(elem, classs));
+		    } else if ("object" === typeof a) {
+		        if (asMap) {
+		            for (const key of Object.keys(a)) {
+		                a[key] = new classs(a[key]);
+		            }
+		            return a;
+		        }
+		        return new classs(a);
+		    }
+		    return a;
+		}
+	}
+
+}
+
+export namespace config {
+	
+	export class Profile {
+	    name: string;
+	    context?: string;
+	    namespace?: string;
+	    deployment?: string;
+	    container?: string;
+	    query?: string;
+	    since?: string;
+	    sinks?: string[];
+	    color_scheme?: string;
+	
+	    static createFrom(source: any = {}) {
+	        return new Profile(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.name = source["name"];
+	        this.context = source["context"];
+	        this.namespace = source["namespace"];
+	        this.deployment = source["deployment"];
+	        this.container = source["container"];
+	        this.query = source["query"];
+	        this.since = source["since"];
+	        this.sinks = source["sinks"];
+	        this.color_scheme = source["color_scheme"];
+	    }
+	}
+	export class SavedSearch {
+	    name: string;
+	    description?: string;
+	    query: string;
+	    since?: string;
+	    container?: string;
+	    context_lines?: number;
+	    aggregate?: string;
+	    limit?: number;
+	
+	    static createFrom(source: any = {}) {
+	        return new SavedSearch(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.name = source["name"];
+	        this.description = source["description"];
+	        this.query = source["query"];
+	        this.since = source["since"];
+	        this.container = source["container"];
+	        this.context_lines = source["context_lines"];
+	        this.aggregate = source["aggregate"];
+	        this.limit = source["limit"];
+	    }
+	}
+	export class Config {
+	    default_profile?: string;
+	    profiles: Profile[];
+	    searches?: SavedSearch[];
+	
+	    static createFrom(source: any = {}) {
+	        return new Config(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.default_profile = source["default_profile"];
+	        this.profiles = this.convertValues(source["profiles"], Profile);
+	        this.searches = this.convertValues(source["searches"], SavedSearch);
+	    }
+	
+		convertValues(a: any, classs: any, asMap: boolean = false): any {
+		    if (!a) {
+		        return a;
+		    }
+		    if (a.slice && a.map) {
+		        return (a as any[]).map(elem => this.convertValues(elem, classs));
+		    } else if ("object" === typeof a) {
+		        if (asMap) {
+		            for (const key of Object.keys(a)) {
+		                a[key] = new classs(a[key]);
+		            }
+		            return a;
+		        }
+		        return new classs(a);
+		    }
+		    return a;
+		}
+	}
 
 }
 
 export namespace kube {
 	
+	export class Aggregate {
+	    key: string;
+	    count: number;
+	    // Go type: time
+	    first: any;
+	    // Go type: time
+	    last: any;
+	    example?: string;
+	
+	    static createFrom(source: any = {}) {
+	        return new Aggregate(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.key = source["key"];
+	        this.count = source["count"];
+	        this.first = this.convertValues(source["first"], null);
+	        this.last = this.convertValues(source["last"], null);
+	        this.example = source["example"];
+	    }
+	
+		convertValues(a: any, classs: any, asMap: boolean = false): any {
+		    if (!a) {
+		        return a;
+		    }
+		    if (a.slice && a.map) {
+		        return (a as any[]).map(elem => this.convertValues(elem, classs));
+		    } else if ("object" === typeof a) {
+		        if (asMap) {
+		            for (const key of Object.keys(a)) {
+		                a[key] = new classs(a[key]);
+		            }
+		            return a;
+		        }
+		        return new classs(a);
+		    }
+		    return a;
+		}
+	}
+	export class ContainerStatus {
+	    name: string;
+	    ready: boolean;
+	    restart_count: number;
+	    state: string;
+	    last_termination_reason?: string;
+	    last_exit_code?: number;
+	    // Go type: time
+	    last_terminated_at?: any;
+	
+	    static createFrom(source: any = {}) {
+	        return new ContainerStatus(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.name = source["name"];
+	        this.ready = source["ready"];
+	        this.restart_count = source["restart_count"];
+	        this.state = source["state"];
+	        this.last_termination_reason = source["last_termination_reason"];
+	        this.last_exit_code = source["last_exit_code"];
+	        this.last_terminated_at = this.convertValues(source["last_terminated_at"], null);
+	    }
+	
+		convertValues(a: any, classs: any, asMap: boolean = false): any {
+		    if (!a) {
+		        return a;
+		    }
+		    if (a.slice && a.map) {
+		        return (a as any[]).map(elem => this.convertValues(elem, classs));
+		    } else if ("object" === typeof a) {
+		        if (asMap) {
+		            for (const key of Object.keys(a)) {
+		                a[key] = new classs(a[key]);
+		            }
+		            return a;
+		        }
+		        return new classs(a);
+		    }
+		    return a;
+		}
+	}
+	export class ExportOptions {
+	    format: string;
+	    compress: boolean;
+	    bundle: boolean;
+	
+	    static createFrom(source: any = {}) {
+	        return new ExportOptions(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.format = source["format"];
+	        this.compress = source["compress"];
+	        this.bundle = source["bundle"];
+	    }
+	}
+	export class Pod {
+	    name: string;
+	    containers: string[];
+	    state: string;
+	    replica_set: string;
+	    revision: string;
+	    node: string;
+	    ip: string;
+	    // Go type: time
+	    created: any;
+	    reason?: string;
+	    statuses: ContainerStatus[];
+	
+	    static createFrom(source: any = {}) {
+	        return new Pod(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.name = source["name"];
+	        this.containers = source["containers"];
+	        this.state = source["state"];
+	        this.replica_set = source["replica_set"];
+	        this.revision = source["revision"];
+	        this.node = source["node"];
+	        this.ip = source["ip"];
+	        this.created = this.convertValues(source["created"], null);
+	        this.reason = source["reason"];
+	        this.statuses = this.convertValues(source["statuses"], ContainerStatus);
+	    }
+	
+		convertValues(a: any, classs: any, asMap: boolean = false): any {
+		    if (!a) {
+		        return a;
+		    }
+		    if (a.slice && a.map) {
+		        return (a as any[]).map(elem => this.convertValues(elem, classs));
+		    } else if ("object" === typeof a) {
+		        if (asMap) {
+		            for (const key of Object.keys(a)) {
+		                a[key] = new classs(a[key]);
+		            }
+		            return a;
+		        }
+		        return new classs(a);
+		    }
+		    return a;
+		}
+	}
 	export class SearchResult {
 	    pod_name: string;
 	    matches: string[];
@@ -33,6 +312,40 @@ export namespace kube {
 	        this.matches = source["matches"];
 	    }
 	}
+	export class StreamStats {
+	    pod: string;
+	    container?: string;
+	    received: number;
+	    delivered: number;
+	    dropped: number;
+	
+	    static createFrom(source: any = {}) {
+	        return new StreamStats(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.pod = source["pod"];
+	        this.container = source["container"];
+	        this.received = source["received"];
+	        this.delivered = source["delivered"];
+	        this.dropped = source["dropped"];
+	    }
+	}
+	export class SubscribeOptions {
+	    buffer: number;
+	    overflow: string;
+	
+	    static createFrom(source: any = {}) {
+	        return new SubscribeOptions(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.buffer = source["buffer"];
+	        this.overflow = source["overflow"];
+	    }
+	}
 
 }
 
@@ -29,3 +29,33 @@ func (u *UI) OpenFile(title string) string {
 
 	return file
 }
+
+var yamlFilter = zenity.FileFilters{{Name: "YAML", Patterns: []string{"*.yaml", "*.yml"}}}
+
+func (u *UI) OpenYAMLFile(title string) string {
+	if title == "" {
+		title = "Choose a file"
+	}
+
+	file, err := zenity.SelectFile(zenity.Title(title), zenity.Modal(), yamlFilter)
+
+	if err != nil && err != zenity.ErrCanceled {
+		zenity.Error("Error while opening file", zenity.ErrorIcon)
+	}
+
+	return file
+}
+
+func (u *UI) SaveYAMLFile(title string) string {
+	if title == "" {
+		title = "Save as"
+	}
+
+	file, err := zenity.SelectFileSave(zenity.ConfirmOverwrite(), zenity.Title(title), zenity.Modal(), yamlFilter)
+
+	if err != nil && err != zenity.ErrCanceled {
+		zenity.Error("Error while saving file", zenity.ErrorIcon)
+	}
+
+	return file
+}
@@ -0,0 +1,114 @@
+package kube
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+const (
+	// AggregateByPod counts the matches in each pod
+	AggregateByPod = "pod"
+	// AggregateByMessage counts matches with the same message once numbers, ids and timestamps are masked
+	AggregateByMessage = "message"
+)
+
+func ValidAggregation(by string) bool {
+	switch by {
+	case "", AggregateByPod, AggregateByMessage:
+		return true
+	}
+	return false
+}
+
+type Aggregate struct {
+	Key   string    `json:"key"`
+	Count int       `json:"count"`
+	First time.Time `json:"first"`
+	Last  time.Time `json:"last"`
+	// Example is the first line seen, for message aggregates where the key has been masked
+	Example string `json:"example,omitempty"`
+}
+
+// variable parts of a message, so "took 12ms for 3f2a..." and "took 40ms for 9b1c..." count as one
+var maskPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}`),
+	regexp.MustCompile(`\b[0-9a-f]{8,}\b`),
+	regexp.MustCompile(`\d+`),
+}
+
+func maskMessage(message string) string {
+	for _, p := range maskPatterns {
+		message = p.ReplaceAllString(message, "#")
+	}
+	return message
+}
+
+// AggregateResults counts the lines matching query, context lines included in the results are skipped.
+// Aggregates are sorted by count, highest first.
+func AggregateResults(results []SearchResult, query string, by string) ([]Aggregate, error) {
+	if !ValidAggregation(by) || by == "" {
+		return nil, fmt.Errorf("unknown aggregation %q, use %v or %v", by, AggregateByPod, AggregateByMessage)
+	}
+	query = strings.ToLower(query)
+	aggregates := make(map[string]*Aggregate)
+	for _, res := range results {
+		for _, line := range res.Matches {
+			if !strings.Contains(strings.ToLower(line), query) {
+				continue
+			}
+			t, message := ParseLogLine(line)
+			key := res.PodName
+			if by == AggregateByMessage {
+				key = maskMessage(message)
+			}
+			a, ok := aggregates[key]
+			if !ok {
+				a = &Aggregate{Key: key, First: t, Last: t}
+				if by == AggregateByMessage {
+					a.Example = message
+				}
+				aggregates[key] = a
+			}
+			a.Count++
+			if !t.IsZero() && (a.First.IsZero() || t.Before(a.First)) {
+				a.First = t
+			}
+			if t.After(a.Last) {
+				a.Last = t
+			}
+		}
+	}
+	sorted := make([]Aggregate, 0, len(aggregates))
+	for _, a := range aggregates {
+		sorted = append(sorted, *a)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].Count != sorted[j].Count {
+			return sorted[i].Count > sorted[j].Count
+		}
+		return sorted[i].Key < sorted[j].Key
+	})
+	return sorted, nil
+}
+
+func FormatAggregates(aggregates []Aggregate) string {
+	var b strings.Builder
+	w := tabwriter.NewWriter(&b, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "COUNT\tFIRST\tLAST\tKEY")
+	for _, a := range aggregates {
+		fmt.Fprintf(w, "%v\t%v\t%v\t%v\n", a.Count, formatAggregateTime(a.First), formatAggregateTime(a.Last), a.Key)
+	}
+	w.Flush()
+	return b.String()
+}
+
+func formatAggregateTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.Format(time.RFC3339)
+}
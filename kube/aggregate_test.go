@@ -0,0 +1,54 @@
+package kube
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestWithContext(t *testing.T) {
+	logs := []string{"0", "1", "2 match", "3", "4 match", "5", "6", "7", "8", "9 match"}
+	lines := withContext(logs, []int{2, 4, 9}, 1)
+	expected := []string{"1", "2 match", "3", "4 match", "5", "8", "9 match"}
+	if !slices.Equal(lines, expected) {
+		t.Errorf("expected %v got %v", expected, lines)
+	}
+	if lines = withContext(logs, []int{2, 4}, 0); !slices.Equal(lines, []string{"2 match", "4 match"}) {
+		t.Errorf("expected only the matches without context got %v", lines)
+	}
+}
+
+func TestAggregateResults(t *testing.T) {
+	results := []SearchResult{
+		{PodName: "web-1", Matches: []string{
+			"2023-09-01T12:00:00Z request 1f2e3d4c5b6a failed after 120ms",
+			"2023-09-01T12:00:01Z retrying",
+			"2023-09-01T12:00:05Z request 9a8b7c6d5e4f failed after 80ms",
+		}},
+		{PodName: "web-2", Matches: []string{
+			"2023-09-01T11:59:00Z request aaaa1111bbbb failed after 3ms",
+			"2023-09-01T12:01:00Z user 5 not found, Failed",
+		}},
+	}
+	byPod, err := AggregateResults(results, "failed", AggregateByPod)
+	if err != nil || len(byPod) != 2 || byPod[0].Count != 2 || byPod[1].Count != 2 {
+		t.Fatalf("expected the context line skipped got %+v %v", byPod, err)
+	}
+	if byPod[0].Key != "web-1" || byPod[0].Last.Sub(byPod[0].First).Seconds() != 5 {
+		t.Errorf("unexpected first and last for web-1 %+v", byPod[0])
+	}
+
+	byMessage, err := AggregateResults(results, "failed", AggregateByMessage)
+	if err != nil || len(byMessage) != 2 {
+		t.Fatalf("expected the requests to collapse into one got %+v %v", byMessage, err)
+	}
+	if byMessage[0].Count != 3 || byMessage[0].Key != "request # failed after #ms" {
+		t.Errorf("unexpected aggregate %+v", byMessage[0])
+	}
+	if !strings.Contains(FormatAggregates(byMessage), "3      2023-09-01T11:59:00Z") {
+		t.Errorf("unexpected table\n%v", FormatAggregates(byMessage))
+	}
+	if _, err = AggregateResults(results, "failed", "node"); err == nil {
+		t.Errorf("expected an unknown aggregation to fail")
+	}
+}
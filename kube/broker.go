@@ -0,0 +1,324 @@
+package kube
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// What a subscriber's buffer does with a new line when it is full
+const (
+	// OverflowBlock waits for the subscriber, holding up the upstream stream and every other subscriber of it
+	OverflowBlock = "block"
+	// OverflowDropOldest makes room by discarding the oldest buffered line
+	OverflowDropOldest = "drop-oldest"
+	// OverflowDropNewest discards the new line
+	OverflowDropNewest = "drop-newest"
+)
+
+func ValidOverflow(overflow string) bool {
+	switch overflow {
+	case "", OverflowBlock, OverflowDropOldest, OverflowDropNewest:
+		return true
+	}
+	return false
+}
+
+type SubscribeOptions struct {
+	// Buffer is how many lines the subscriber can fall behind by, 10 if not set
+	Buffer   int    `json:"buffer"`
+	Overflow string `json:"overflow"`
+}
+
+type streamKey struct {
+	pod       string
+	container string
+}
+
+/*
+Broker holds one follow stream per pod and container and fans its lines out to any number of
+subscribers, so a console, an alert rule and a file can all follow the same pod without each opening
+a stream or taking lines from the others.
+The upstream stream starts with the first subscriber and is closed when the last one leaves
+*/
+type Broker struct {
+	client  *KubeClient
+	context context.Context
+	mu      sync.Mutex
+	streams map[streamKey]*brokerStream
+	lines   LineOptions
+	// delivered is the time of the last line of each stream, so following a pod again carries on from there
+	// rather than sending its tail a second time
+	delivered map[streamKey]time.Time
+	// open starts following a pod's container after since, or from its tail when since is zero, replaced in tests.
+	// Once the lines are closed err is why the stream ended early, nil if it didn't
+	open func(ctx context.Context, key streamKey, since time.Time) (lines <-chan string, err func() error)
+}
+
+type brokerStream struct {
+	cancel      context.CancelFunc
+	subscribers map[*Subscription]bool
+}
+
+func NewBroker(client *KubeClient, ctx context.Context) *Broker {
+	b := &Broker{client: client, context: ctx, streams: make(map[streamKey]*brokerStream), delivered: make(map[streamKey]time.Time)}
+	b.open = b.openPodLog
+	return b
+}
+
+func (b *Broker) openPodLog(ctx context.Context, key streamKey, since time.Time) (<-chan string, func() error) {
+	pl := NewPodLog(key.pod, b.client, ctx)
+	pl.Container = key.container
+	pl.Lines = b.lines
+	pl.Since = since
+	go pl.StreamLogs()
+	return pl.Messages, pl.Err
+}
+
+// SetLineOptions applies to the streams opened from now on
+func (b *Broker) SetLineOptions(opts LineOptions) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.lines = opts
+}
+
+// Subscription is one subscriber's buffered view of a stream, Messages is closed when the stream
+// ends or the subscription is closed
+type Subscription struct {
+	Messages  <-chan string
+	messages  chan string
+	overflow  string
+	key       streamKey
+	broker    *Broker
+	done      chan struct{}
+	closeOnce sync.Once
+	err       error
+	// mu guards closed and sending, it is never held while waiting on the subscriber
+	mu       sync.Mutex
+	closed   bool
+	sending  sync.WaitGroup
+	received atomic.Int64
+	dropped  atomic.Int64
+}
+
+// StreamStats counts the lines of a pod's stream: received from the pod, delivered to the
+// subscriber and dropped by its overflow policy. The difference is what is still buffered
+type StreamStats struct {
+	Pod       string `json:"pod"`
+	Container string `json:"container,omitempty"`
+	Received  int64  `json:"received"`
+	Delivered int64  `json:"delivered"`
+	Dropped   int64  `json:"dropped"`
+}
+
+// Subscribe follows the pod's container, the default container when container is empty
+func (b *Broker) Subscribe(pod string, container string, opts SubscribeOptions) (*Subscription, error) {
+	if !ValidOverflow(opts.Overflow) {
+		return nil, fmt.Errorf("unknown overflow policy %q, use %v, %v or %v", opts.Overflow, OverflowBlock, OverflowDropOldest, OverflowDropNewest)
+	}
+	if opts.Buffer < 1 {
+		opts.Buffer = 10
+	}
+	key := streamKey{pod: pod, container: container}
+	messages := make(chan string, opts.Buffer)
+	sub := &Subscription{Messages: messages, messages: messages, overflow: opts.Overflow, key: key, broker: b, done: make(chan struct{})}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	stream, ok := b.streams[key]
+	if !ok {
+		stream = b.start(key)
+	}
+	stream.subscribers[sub] = true
+	return sub, nil
+}
+
+func (b *Broker) start(key streamKey) *brokerStream {
+	ctx, cancel := context.WithCancel(b.context)
+	stream := &brokerStream{cancel: cancel, subscribers: make(map[*Subscription]bool)}
+	b.streams[key] = stream
+	messages, streamErr := b.open(ctx, key, b.delivered[key])
+	go func() {
+		defer cancel()
+		var last time.Time
+		for m := range messages {
+			if t, _ := ParseLogLine(m); !t.IsZero() {
+				last = t
+			}
+			for _, sub := range b.subscribers(stream) {
+				sub.deliver(m)
+			}
+		}
+		var err error
+		if streamErr != nil {
+			err = streamErr()
+		}
+		b.mu.Lock()
+		// a stream that ended by itself is carried on from its last line when the pod is followed again,
+		// one its subscribers left or whose pod was removed starts over
+		if b.streams[key] == stream {
+			delete(b.streams, key)
+			if last.After(b.delivered[key]) {
+				b.delivered[key] = last
+			}
+		}
+		subscribers := stream.subscribers
+		stream.subscribers = nil
+		b.mu.Unlock()
+		for sub := range subscribers {
+			sub.endWith(err)
+		}
+	}()
+	return stream
+}
+
+// subscribers are copied so lines are delivered without holding the lock, a blocking subscriber
+// mustn't stop others subscribing or leaving
+func (b *Broker) subscribers(stream *brokerStream) []*Subscription {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	subscribers := make([]*Subscription, 0, len(stream.subscribers))
+	for sub := range stream.subscribers {
+		subscribers = append(subscribers, sub)
+	}
+	return subscribers
+}
+
+func (b *Broker) unsubscribe(sub *Subscription) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	stream, ok := b.streams[sub.key]
+	if !ok || !stream.subscribers[sub] {
+		return
+	}
+	delete(stream.subscribers, sub)
+	if len(stream.subscribers) == 0 {
+		delete(b.streams, sub.key)
+		stream.cancel()
+	}
+}
+
+// ClosePod ends every stream of the pod, closing all their subscriptions
+func (b *Broker) ClosePod(pod string) {
+	b.mu.Lock()
+	for key := range b.delivered {
+		if key.pod == pod {
+			delete(b.delivered, key)
+		}
+	}
+	var subscribers []*Subscription
+	for key, stream := range b.streams {
+		if key.pod != pod {
+			continue
+		}
+		for sub := range stream.subscribers {
+			subscribers = append(subscribers, sub)
+		}
+		stream.subscribers = nil
+		delete(b.streams, key)
+		stream.cancel()
+	}
+	b.mu.Unlock()
+	for _, sub := range subscribers {
+		sub.end()
+	}
+}
+
+// Streams is the number of upstream streams open
+func (b *Broker) Streams() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.streams)
+}
+
+func (s *Subscription) deliver(m string) {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return
+	}
+	// end waits for the send before closing the channel
+	s.sending.Add(1)
+	s.mu.Unlock()
+	defer s.sending.Done()
+	s.received.Add(1)
+	switch s.overflow {
+	case OverflowDropNewest:
+		select {
+		case s.messages <- m:
+		default:
+			s.dropped.Add(1)
+		}
+	case OverflowDropOldest:
+		for {
+			select {
+			case s.messages <- m:
+				return
+			default:
+			}
+			select {
+			case <-s.messages:
+				s.dropped.Add(1)
+			default:
+			}
+		}
+	default:
+		select {
+		case s.messages <- m:
+		case <-s.done:
+		}
+	}
+}
+
+// Dropped is how many lines the subscription's overflow policy has discarded
+func (s *Subscription) Dropped() int64 {
+	return s.dropped.Load()
+}
+
+func (s *Subscription) Stats() StreamStats {
+	received, dropped := s.received.Load(), s.dropped.Load()
+	stats := StreamStats{Pod: s.key.pod, Container: s.key.container, Received: received, Dropped: dropped}
+	stats.Delivered = received - dropped
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.closed {
+		stats.Delivered -= int64(len(s.messages))
+	}
+	return stats
+}
+
+// Close leaves the stream, closing it upstream if this was the last subscriber
+func (s *Subscription) Close() {
+	s.broker.unsubscribe(s)
+	s.end()
+}
+
+// Err is why the stream ended early, e.g. reading the pod's logs failed. Only set once Messages is closed
+func (s *Subscription) Err() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
+
+func (s *Subscription) end() {
+	s.endWith(nil)
+}
+
+func (s *Subscription) endWith(err error) {
+	s.mu.Lock()
+	if s.err == nil && !s.closed {
+		s.err = err
+	}
+	s.mu.Unlock()
+	s.closeOnce.Do(func() {
+		// done first so a blocked delivery gives up
+		close(s.done)
+		s.mu.Lock()
+		s.closed = true
+		s.mu.Unlock()
+		s.sending.Wait()
+		close(s.messages)
+	})
+}
@@ -0,0 +1,208 @@
+package kube
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testUpstreams stands in for the pod log streams, lines sent to a pod and container reach its stream
+type testUpstreams struct {
+	mu      sync.Mutex
+	streams map[streamKey]chan string
+	opened  int
+}
+
+func testBroker(t *testing.T) (*Broker, *testUpstreams) {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	upstreams := &testUpstreams{streams: make(map[streamKey]chan string)}
+	b := NewBroker(nil, ctx)
+	b.open = func(ctx context.Context, key streamKey, since time.Time) (<-chan string, func() error) {
+		upstream := upstreams.stream(key)
+		upstreams.opened++
+		messages := make(chan string)
+		go func() {
+			defer close(messages)
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case m := <-upstream:
+					messages <- m
+				}
+			}
+		}()
+		return messages, nil
+	}
+	return b, upstreams
+}
+
+func (u *testUpstreams) stream(key streamKey) chan string {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if _, ok := u.streams[key]; !ok {
+		u.streams[key] = make(chan string)
+	}
+	return u.streams[key]
+}
+
+func (u *testUpstreams) send(pod string, container string, lines ...string) {
+	upstream := u.stream(streamKey{pod: pod, container: container})
+	for _, line := range lines {
+		upstream <- line
+	}
+}
+
+func receive(t *testing.T, sub *Subscription) string {
+	select {
+	case m := <-sub.Messages:
+		return m
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting for a line")
+	}
+	return ""
+}
+
+func eventually(t *testing.T, what string, cond func() bool) {
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %v", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestBrokerFanOut(t *testing.T) {
+	b, upstreams := testBroker(t)
+	console, err := b.Subscribe("web-1", "", SubscribeOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	alerts, _ := b.Subscribe("web-1", "", SubscribeOptions{})
+	sidecar, _ := b.Subscribe("web-1", "sidecar", SubscribeOptions{})
+	if upstreams.opened != 2 || b.Streams() != 2 {
+		t.Fatalf("expected one upstream per container got %v", upstreams.opened)
+	}
+	upstreams.send("web-1", "", "hello")
+	upstreams.send("web-1", "sidecar", "world")
+	if receive(t, console) != "hello" || receive(t, alerts) != "hello" {
+		t.Errorf("expected both subscribers of the container to get the line")
+	}
+	if receive(t, sidecar) != "world" {
+		t.Errorf("expected the sidecar's own line")
+	}
+
+	console.Close()
+	if _, ok := <-console.Messages; ok {
+		t.Errorf("expected a closed subscription's channel to be closed")
+	}
+	if b.Streams() != 2 {
+		t.Errorf("expected the upstream kept open for the remaining subscriber")
+	}
+	alerts.Close()
+	b.ClosePod("web-1")
+	if _, ok := <-sidecar.Messages; ok || b.Streams() != 0 {
+		t.Errorf("expected the upstreams closed after the last subscriber left")
+	}
+
+	if _, err = b.Subscribe("web-1", "", SubscribeOptions{Overflow: "spill"}); err == nil {
+		t.Errorf("expected an unknown overflow policy to be rejected")
+	}
+}
+
+func TestBrokerOverflow(t *testing.T) {
+	b, upstreams := testBroker(t)
+	blocked, _ := b.Subscribe("web-1", "", SubscribeOptions{Buffer: 1})
+	oldest, _ := b.Subscribe("web-1", "", SubscribeOptions{Buffer: 2, Overflow: OverflowDropOldest})
+	newest, _ := b.Subscribe("web-1", "", SubscribeOptions{Buffer: 2, Overflow: OverflowDropNewest})
+	go upstreams.send("web-1", "", "1", "2", "3", "4")
+
+	got := ""
+	for i := 0; i < 4; i++ {
+		got += receive(t, blocked)
+	}
+	if got != "1234" || blocked.Dropped() != 0 {
+		t.Errorf("expected block to wait for the subscriber got %v", got)
+	}
+	eventually(t, "the lines to be dropped", func() bool { return oldest.Dropped() == 2 && newest.Dropped() == 2 })
+	if got = receive(t, oldest) + receive(t, oldest); got != "34" {
+		t.Errorf("expected drop-oldest to keep the latest lines got %v", got)
+	}
+	if got = receive(t, newest) + receive(t, newest); got != "12" {
+		t.Errorf("expected drop-newest to keep the first lines got %v", got)
+	}
+	if stats := newest.Stats(); stats.Received != 4 || stats.Delivered != 2 || stats.Dropped != 2 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+
+	// a subscriber that stops reading holds up the stream until it leaves
+	go upstreams.send("web-1", "", "5", "6", "7", "8")
+	receive(t, blocked)
+	time.Sleep(50 * time.Millisecond)
+	if newest.Dropped() == 4 {
+		t.Errorf("expected the blocked subscriber to hold up the stream")
+	}
+	stats := make(chan StreamStats)
+	go func() { stats <- blocked.Stats() }()
+	select {
+	case <-stats:
+	case <-time.After(time.Second):
+		t.Fatalf("expected stats of a blocked subscriber not to wait for it")
+	}
+	blocked.Close()
+	eventually(t, "the stream to carry on", func() bool { return newest.Dropped() == 4 })
+}
+
+func TestFormatStreamStats(t *testing.T) {
+	stats := []StreamStats{
+		addStreamStats(StreamStats{Pod: "web-1", Received: 10, Delivered: 10}, StreamStats{Pod: "web-1", Received: 5, Delivered: 3, Dropped: 2}),
+		{Pod: "web-2", Received: 4, Delivered: 4},
+	}
+	if got := FormatStreamStats(stats); got != "19 received, 17 delivered, 2 dropped (web-1 2)" {
+		t.Errorf("unexpected status %q", got)
+	}
+}
+
+func TestBrokerStreamError(t *testing.T) {
+	b, _ := testBroker(t)
+	b.open = func(ctx context.Context, key streamKey, since time.Time) (<-chan string, func() error) {
+		messages := make(chan string, 1)
+		messages <- "last line"
+		close(messages)
+		return messages, func() error { return errors.New("unexpected EOF") }
+	}
+	sub, _ := b.Subscribe("web-1", "", SubscribeOptions{})
+	receive(t, sub)
+	if _, ok := <-sub.Messages; ok || sub.Err() == nil {
+		t.Errorf("expected the stream's error once it ended got %v", sub.Err())
+	}
+}
+
+func TestBrokerResumesAfterTheLastLine(t *testing.T) {
+	b, _ := testBroker(t)
+	var opened []time.Time
+	b.open = func(ctx context.Context, key streamKey, since time.Time) (<-chan string, func() error) {
+		opened = append(opened, since)
+		messages := make(chan string, 1)
+		messages <- "2023-09-01T12:00:00.5Z last line"
+		close(messages)
+		return messages, nil
+	}
+	follow := func() {
+		sub, _ := b.Subscribe("web-1", "", SubscribeOptions{})
+		for range sub.Messages {
+		}
+	}
+	follow()
+	follow()
+	b.ClosePod("web-1")
+	follow()
+	last := time.Date(2023, 9, 1, 12, 0, 0, 5e8, time.UTC)
+	if len(opened) != 3 || !opened[0].IsZero() || !opened[1].Equal(last) || !opened[2].IsZero() {
+		t.Errorf("expected the tail first, then to carry on after the last line until the pod is removed got %v", opened)
+	}
+}
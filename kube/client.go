@@ -6,77 +6,139 @@ import (
 	"io"
 	v1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/watch"
 	"k8s.io/client-go/kubernetes"
 	_ "k8s.io/client-go/plugin/pkg/client/auth"
 	"k8s.io/client-go/rest"
+	metricsclient "k8s.io/metrics/pkg/client/clientset/versioned"
+	"time"
 )
 
 type KubeClient struct {
-	client    *kubernetes.Clientset
+	client    kubernetes.Interface
+	metrics   metricsclient.Interface
 	config    *rest.Config
 	namespace string
+	cluster   string
+	redactor  *Redactor
 }
 
 func NewKubeClient(config *rest.Config) *KubeClient {
 	client := KubeClient{config: config}
-	fmt.Println(config)
 	clientset, err := kubernetes.NewForConfig(config)
 	if err != nil {
 		panicMsg := fmt.Sprintf("Unable to setup client %v", err)
 		panic(panicMsg)
 	}
 	client.namespace = "default"
+	client.cluster = config.Host
 	client.client = clientset
+	metrics, err := metricsclient.NewForConfig(config)
+	if err != nil {
+		fmt.Printf("Unable to setup metrics client %v\n", err)
+	} else {
+		client.metrics = metrics
+	}
 	return &client
 }
 
+// NewKubeClientFromClientset wraps an existing clientset, e.g. the fake one in tests
+func NewKubeClientFromClientset(clientset kubernetes.Interface) *KubeClient {
+	return &KubeClient{client: clientset, config: &rest.Config{}, namespace: "default"}
+}
+
 func (kc *KubeClient) GetNamespaces(ctx context.Context) []string {
-	options := metav1.ListOptions{}
-	namespaceList, err := kc.client.CoreV1().Namespaces().List(ctx, options)
+	namespaces, err := kc.ListNamespaces(ctx)
 	if err != nil {
 		fmt.Printf("Error getting namespaces %v\n", err)
 		return []string{}
 	}
+	return namespaces
+}
+
+func (kc *KubeClient) ListNamespaces(ctx context.Context) ([]string, error) {
+	options := metav1.ListOptions{}
+	namespaceList, err := kc.client.CoreV1().Namespaces().List(ctx, options)
+	if err != nil {
+		return nil, wrapError(err)
+	}
 
 	var namespaces = make([]string, len(namespaceList.Items))
 	for i, namespace := range namespaceList.Items {
 		namespaces[i] = namespace.Name
 	}
-	return namespaces
+	return namespaces, nil
 }
 
 func (kc *KubeClient) SetNamespace(namespace string) {
 	kc.namespace = namespace
 }
 
+func (kc *KubeClient) Namespace() string {
+	return kc.namespace
+}
+
+// Cluster is the name log entries are tagged with, it defaults to the api server host
+func (kc *KubeClient) Cluster() string {
+	return kc.cluster
+}
+
+func (kc *KubeClient) SetCluster(cluster string) {
+	kc.cluster = cluster
+}
+
+// Redactor is applied to every log line and event read through the client, nil when nothing is redacted
+func (kc *KubeClient) Redactor() *Redactor {
+	return kc.redactor
+}
+
+func (kc *KubeClient) SetRedactor(r *Redactor) {
+	kc.redactor = r
+}
+
 func (kc *KubeClient) GetDeployments(ctx context.Context) []string {
-	options := metav1.ListOptions{}
-	deploymentList, err := kc.client.AppsV1().Deployments(kc.namespace).List(ctx, options)
+	deployments, err := kc.ListDeployments(ctx)
 	if err != nil {
 		fmt.Printf("Error getting deployments %v", err)
 		return []string{}
 	}
+	return deployments
+}
+
+func (kc *KubeClient) ListDeployments(ctx context.Context) ([]string, error) {
+	options := metav1.ListOptions{}
+	deploymentList, err := kc.client.AppsV1().Deployments(kc.namespace).List(ctx, options)
+	if err != nil {
+		return nil, wrapError(err)
+	}
 	var deployments = make([]string, len(deploymentList.Items))
 	for i, deployment := range deploymentList.Items {
 		deployments[i] = deployment.Name
 	}
-	return deployments
+	return deployments, nil
 }
 
 type Pod struct {
-	Name       string
-	Containers []string
-	State      string
+	Name       string                     `json:"name"`
+	Containers []string                   `json:"containers"`
+	State      string                     `json:"state"`
+	ReplicaSet string                     `json:"replica_set"`
+	Revision   string                     `json:"revision"`
+	Limits     map[string]ContainerLimits `json:"-"`
+	Node       string                     `json:"node"`
+	IP         string                     `json:"ip"`
+	Created    time.Time                  `json:"created"`
+	// Reason is why the pod is in its phase, e.g. Evicted, empty for most pods
+	Reason   string            `json:"reason,omitempty"`
+	Statuses []ContainerStatus `json:"statuses"`
 }
 
 func (kc *KubeClient) GetPods(ctx context.Context, deploymentName string) []Pod {
-	options := metav1.GetOptions{}
-	deployment, err := kc.client.AppsV1().Deployments(kc.namespace).Get(ctx, deploymentName, options)
-
-	labelSelector := metav1.LabelSelector{MatchLabels: deployment.Labels}
-	matchLabels := labels.Set(labelSelector.MatchLabels).String()
-	listOptions := metav1.ListOptions{LabelSelector: matchLabels}
+	listOptions, err := kc.podListOptions(ctx, deploymentName)
+	if err != nil {
+		fmt.Printf("Error getting deployment %v: %v", deploymentName, err)
+		return []Pod{}
+	}
 	podsList, err := kc.client.CoreV1().Pods(kc.namespace).List(ctx, listOptions)
 	if err != nil {
 		fmt.Printf("Error getting pods for deployment %v: %v", deploymentName, err)
@@ -84,22 +146,60 @@ func (kc *KubeClient) GetPods(ctx context.Context, deploymentName string) []Pod
 	}
 	var pods = make([]Pod, len(podsList.Items))
 	for i, pod := range podsList.Items {
-		containers := make([]string, len(pod.Spec.Containers))
-		for j, container := range pod.Spec.Containers {
-			containers[j] = container.Name
-		}
-		p := Pod{Name: pod.Name, Containers: containers, State: string(pod.Status.Phase)}
-		pods[i] = p
+		pods[i] = newPod(&pod)
 	}
 	return pods
 }
 
-func (kc *KubeClient) GetContainerLogs(ctx context.Context, podName string, options v1.PodLogOptions) io.ReadCloser {
-	logsRq := kc.client.CoreV1().Pods(kc.namespace).GetLogs(podName, &options)
-	logs, err := logsRq.Stream(ctx)
+func newPod(pod *v1.Pod) Pod {
+	containers := make([]string, len(pod.Spec.Containers))
+	limits := make(map[string]ContainerLimits)
+	for j, container := range pod.Spec.Containers {
+		containers[j] = container.Name
+		limits[container.Name] = containerLimits(container)
+	}
+	p := Pod{
+		Name:       pod.Name,
+		Containers: containers,
+		State:      string(pod.Status.Phase),
+		Limits:     limits,
+		Node:       pod.Spec.NodeName,
+		IP:         pod.Status.PodIP,
+		Created:    pod.CreationTimestamp.Time,
+		Reason:     pod.Status.Reason,
+		Statuses:   newContainerStatuses(pod),
+	}
+	for _, owner := range pod.OwnerReferences {
+		if owner.Kind == "ReplicaSet" {
+			p.ReplicaSet = owner.Name
+		}
+	}
+	return p
+}
+
+// WatchPods watches the pods selected by the deployment, so we notice pods coming and going during a rollout
+func (kc *KubeClient) WatchPods(ctx context.Context, deploymentName string) (watch.Interface, error) {
+	listOptions, err := kc.podListOptions(ctx, deploymentName)
+	if err != nil {
+		return nil, err
+	}
+	return kc.client.CoreV1().Pods(kc.namespace).Watch(ctx, listOptions)
+}
+
+func (kc *KubeClient) podListOptions(ctx context.Context, deploymentName string) (metav1.ListOptions, error) {
+	options := metav1.GetOptions{}
+	deployment, err := kc.client.AppsV1().Deployments(kc.namespace).Get(ctx, deploymentName, options)
+	if err != nil {
+		return metav1.ListOptions{}, err
+	}
+	selector, err := metav1.LabelSelectorAsSelector(deployment.Spec.Selector)
 	if err != nil {
-		fmt.Printf("Failed to get logs for %v- with error %v", podName, err)
-		return nil
+		return metav1.ListOptions{}, err
 	}
-	return logs
+	return metav1.ListOptions{LabelSelector: selector.String()}, nil
+}
+
+func (kc *KubeClient) GetContainerLogs(ctx context.Context, podName string, options v1.PodLogOptions) (io.ReadCloser, error) {
+	logsRq := kc.client.CoreV1().Pods(kc.namespace).GetLogs(podName, &options)
+	return logsRq.Stream(ctx)
 }
@@ -7,7 +7,7 @@ import (
 
 func TestGetDeploymentPods(t *testing.T) {
 	ctx := context.Background()
-	config, err := LoadConfig(ConfigParameters{})
+	config, err := LoadConfig(NewConfigFlags("", ""))
 	if err != nil {
 		t.Error(err)
 	}
@@ -25,7 +25,7 @@ func TestGetDeploymentPods(t *testing.T) {
 
 func TestGetNamespaces(t *testing.T) {
 	ctx := context.Background()
-	config, err := LoadConfig(ConfigParameters{})
+	config, err := LoadConfig(NewConfigFlags("", ""))
 	if err != nil {
 		t.Error(err)
 	}
@@ -2,59 +2,78 @@ package kube
 
 import (
 	"fmt"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
+	"sort"
 )
 
-type ConfigParameters struct {
-	Path    string
-	Context string
-	Save    bool
+// NewConfigFlags are kubectl's connection flags (--kubeconfig, --context, -n, --as, --request-timeout...),
+// anything left unset falls back to the kubeconfig the way kubectl does
+func NewConfigFlags(path string, context string) *genericclioptions.ConfigFlags {
+	// not persistent, so changing the context after a load takes effect
+	flags := genericclioptions.NewConfigFlags(false)
+	if path != "" {
+		flags.KubeConfig = &path
+	}
+	if context != "" {
+		flags.Context = &context
+	}
+	return flags
 }
 
-func LoadConfig(configParam ConfigParameters) (*rest.Config, error) {
-	configAccess := clientcmd.NewDefaultPathOptions()
+func LoadConfig(flags *genericclioptions.ConfigFlags) (*rest.Config, error) {
+	config, err := flags.ToRESTConfig()
+	return config, wrapError(err)
+}
 
-	loadingRules := *configAccess.LoadingRules
-	loadingRules.Precedence = configAccess.GetLoadingPrecedence()
-	if configParam.Path != "" {
-		loadingRules.ExplicitPath = configParam.Path
+// Namespace is the -n flag if given, otherwise the namespace of the kubeconfig context
+func Namespace(flags *genericclioptions.ConfigFlags) string {
+	namespace, _, err := flags.ToRawKubeConfigLoader().Namespace()
+	if err != nil || namespace == "" {
+		return "default"
 	}
+	return namespace
+}
 
-	configOverrides := clientcmd.ConfigOverrides{}
-	if configParam.Context != "" {
-		configOverrides.CurrentContext = configParam.Context
+// NewKubeClientFromFlags connects with the flags, starting in their namespace
+func NewKubeClientFromFlags(flags *genericclioptions.ConfigFlags) (*KubeClient, error) {
+	config, err := LoadConfig(flags)
+	if err != nil {
+		return nil, err
 	}
-	clientConfig := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(&loadingRules, &configOverrides)
-
-	if configParam.Save {
-		rawConf, err := clientConfig.RawConfig()
-		if err != nil {
-			fmt.Printf("Error %s, getting kubeconfig", err.Error())
+	kc := NewKubeClient(config)
+	kc.SetNamespace(Namespace(flags))
+	return kc, nil
+}
 
-		} else {
-			err = clientcmd.ModifyConfig(configAccess, rawConf, true)
-			if err != nil {
-				fmt.Printf("Error modifying kubeconfig, context will only be set for this command")
-			}
+// SaveContext makes the flags' context the current context of the kubeconfig, like kubectl config use-context
+func SaveContext(flags *genericclioptions.ConfigFlags) error {
+	loader := flags.ToRawKubeConfigLoader()
+	rawConf, err := loader.RawConfig()
+	if err != nil {
+		return err
+	}
+	if flags.Context != nil && *flags.Context != "" {
+		if _, ok := rawConf.Contexts[*flags.Context]; !ok {
+			return fmt.Errorf("%w: no context named %v", ErrUnknownContext, *flags.Context)
 		}
+		rawConf.CurrentContext = *flags.Context
 	}
-
-	return clientConfig.ClientConfig()
+	return clientcmd.ModifyConfig(loader.ConfigAccess(), rawConf, true)
 }
 
-func AvailableContexts(path string) []string {
-	if path == "" {
-		path = clientcmd.RecommendedHomeFile
+func AvailableContexts(flags *genericclioptions.ConfigFlags) []string {
+	rawConf, err := flags.ToRawKubeConfigLoader().RawConfig()
+	if err != nil {
+		fmt.Printf("Error %s, getting kubeconfig\n", err.Error())
+		return []string{}
 	}
-	config := clientcmd.GetConfigFromFileOrDie(path)
-
-	keys := make([]string, len(config.Contexts))
 
-	i := 0
-	for k := range config.Contexts {
-		keys[i] = k
-		i++
+	keys := make([]string, 0, len(rawConf.Contexts))
+	for k := range rawConf.Contexts {
+		keys = append(keys, k)
 	}
+	sort.Strings(keys)
 	return keys
 }
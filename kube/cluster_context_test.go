@@ -0,0 +1,92 @@
+package kube
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+const testKubeconfig = `apiVersion: v1
+kind: Config
+current-context: dev
+clusters:
+- name: dev
+  cluster:
+    server: https://dev.example.com
+- name: prod
+  cluster:
+    server: https://prod.example.com
+contexts:
+- name: dev
+  context:
+    cluster: dev
+    user: me
+    namespace: team-a
+- name: prod
+  context:
+    cluster: prod
+    user: me
+users:
+- name: me
+  user:
+    token: abc
+`
+
+func writeKubeconfig(t *testing.T) string {
+	path := filepath.Join(t.TempDir(), "config")
+	if err := os.WriteFile(path, []byte(testKubeconfig), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestConfigFlags(t *testing.T) {
+	path := writeKubeconfig(t)
+	flags := NewConfigFlags(path, "")
+	if contexts := AvailableContexts(flags); !slices.Equal(contexts, []string{"dev", "prod"}) {
+		t.Errorf("unexpected contexts %v", contexts)
+	}
+	config, err := LoadConfig(flags)
+	if err != nil || config.Host != "https://dev.example.com" {
+		t.Fatalf("expected the current context got %v %v", config, err)
+	}
+	if ns := Namespace(flags); ns != "team-a" {
+		t.Errorf("expected the context's namespace got %v", ns)
+	}
+
+	flags = NewConfigFlags(path, "prod")
+	as, timeout, namespace := "jane", "5s", "other"
+	flags.Impersonate, flags.Timeout = &as, &timeout
+	config, err = LoadConfig(flags)
+	if err != nil || config.Host != "https://prod.example.com" || config.Impersonate.UserName != "jane" || config.Timeout.String() != "5s" {
+		t.Fatalf("expected the flags to be honored got %+v %v", config, err)
+	}
+	if ns := Namespace(flags); ns != "default" {
+		t.Errorf("expected default for a context without a namespace got %v", ns)
+	}
+	flags.Namespace = &namespace
+	if ns := Namespace(flags); ns != "other" {
+		t.Errorf("expected -n to win got %v", ns)
+	}
+}
+
+func TestSaveContext(t *testing.T) {
+	path := writeKubeconfig(t)
+	if err := SaveContext(NewConfigFlags(path, "missing")); !errors.Is(err, ErrUnknownContext) {
+		t.Errorf("expected an unknown context error got %v", err)
+	}
+	if _, err := LoadConfig(NewConfigFlags(path, "missing")); !errors.Is(err, ErrUnknownContext) {
+		t.Errorf("expected loading an unknown context to fail got %v", err)
+	}
+	if err := SaveContext(NewConfigFlags(path, "prod")); err != nil {
+		t.Fatal(err)
+	}
+	config, err := clientcmd.LoadFromFile(path)
+	if err != nil || config.CurrentContext != "prod" {
+		t.Errorf("expected prod to be the current context got %v %v", config.CurrentContext, err)
+	}
+}
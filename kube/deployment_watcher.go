@@ -3,14 +3,11 @@ package kube
 import (
 	"bufio"
 	"context"
-	"errors"
 	"fmt"
-	"github.com/fatih/color"
 	v1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"math/rand"
+	"k8s.io/apimachinery/pkg/watch"
 	"os"
-	"path/filepath"
 	"slices"
 	"strings"
 	"sync"
@@ -29,6 +26,26 @@ type DeploymentWatcher struct {
 	context     context.Context
 	pods        map[string]Pod
 	podContexts map[string]PodContext
+	mu          sync.Mutex
+	// broker shares one stream per pod and container between every Stream of the watcher
+	broker   *Broker
+	canceled map[string]bool
+	// streamOptions are the buffer and overflow policy of each pod followed by Stream
+	streamOptions SubscribeOptions
+	// subscriptions are the pods being followed, ended keeps the counts of the ones that have stopped
+	// until the pod is removed
+	subscriptions map[*Subscription]bool
+	ended         map[string]StreamStats
+	lines         LineOptions
+	// replica sets of the deployment by name, with their revision once known
+	replicaSets     map[string]string
+	metrics         *MetricsHistory
+	statusListeners []func([]Pod)
+	errorListeners  []func(error)
+
+	removedListeners []func(string)
+	// foreignReplicaSets are named like the deployment's but belong to another, e.g. web-worker's for web
+	foreignReplicaSets map[string]bool
 }
 
 type SearchParameters struct {
@@ -37,6 +54,12 @@ type SearchParameters struct {
 	Since         time.Time
 	AllContainers bool
 	Limit         int64
+	// ContextLines includes this many lines before and after each match, like grep -C
+	ContextLines int
+	// only match lines logged while the pod was using more than this percent of its limits,
+	// needs metrics to have been sampled while the lines were logged
+	MemoryAbovePercent float64
+	CPUAbovePercent    float64
 }
 
 type SearchResult struct {
@@ -45,7 +68,7 @@ type SearchResult struct {
 }
 
 func NewDeploymentWatcher(name string, client *KubeClient, ctx context.Context) *DeploymentWatcher {
-	dl := DeploymentWatcher{name: name, client: client, context: ctx}
+	dl := DeploymentWatcher{name: name, client: client, context: ctx, broker: NewBroker(client, ctx), canceled: make(map[string]bool), subscriptions: make(map[*Subscription]bool), ended: make(map[string]StreamStats), replicaSets: make(map[string]string), foreignReplicaSets: make(map[string]bool), metrics: NewMetricsHistory(240)}
 	pods := client.GetPods(ctx, name)
 	dl.pods = make(map[string]Pod)
 	for _, p := range pods {
@@ -63,11 +86,30 @@ func (dl *DeploymentWatcher) resetPodContexts() {
 }
 
 func (dl *DeploymentWatcher) resetPodContext(name string) {
+	dl.podContexts[name] = dl.newPodContext(name)
+}
+
+func (dl *DeploymentWatcher) newPodContext(name string) PodContext {
 	childContext, cancel := context.WithCancel(dl.context)
-	dl.podContexts[name] = PodContext{PodLog: NewPodLog(name, dl.client, childContext), context: childContext, Cancel: cancel}
+	pl := NewPodLog(name, dl.client, childContext)
+	pl.Lines = dl.lines
+	return PodContext{PodLog: pl, context: childContext, Cancel: cancel}
+}
+
+// snapshotPods copies the pods so they can be worked on while the pod watch keeps updating them
+func (dl *DeploymentWatcher) snapshotPods() map[string]Pod {
+	dl.mu.Lock()
+	defer dl.mu.Unlock()
+	pods := make(map[string]Pod, len(dl.pods))
+	for name, pod := range dl.pods {
+		pods[name] = pod
+	}
+	return pods
 }
 
 func (dl *DeploymentWatcher) GetPods() []string {
+	dl.mu.Lock()
+	defer dl.mu.Unlock()
 	podNames := make([]string, len(dl.pods))
 	i := 0
 	for name := range dl.pods {
@@ -78,6 +120,8 @@ func (dl *DeploymentWatcher) GetPods() []string {
 }
 
 func (dl *DeploymentWatcher) StreamLogs() map[string]PodContext {
+	dl.mu.Lock()
+	defer dl.mu.Unlock()
 	for _, p := range dl.pods {
 		dl.resetPodContext(p.Name)
 		pc := dl.podContexts[p.Name]
@@ -88,60 +132,411 @@ func (dl *DeploymentWatcher) StreamLogs() map[string]PodContext {
 	return dl.podContexts
 }
 
-func (dl *DeploymentWatcher) StreamLogsConsole() {
-	logColors := make(map[string]*color.Color)
-	ignoreColors := []int{0, 15, 16, 231}
-	for _, p := range dl.pods {
-		pc := dl.podContexts[p.Name]
-		i := rand.Intn(231)
-		for {
-			if !slices.Contains(ignoreColors, i) {
-				ignoreColors = append(ignoreColors, i)
-				break
-			}
-			i = rand.Intn(231)
+// Stream follows every pod of the deployment and merges their lines, and the events involving
+// the deployment, into a single channel.
+// Pods started by a rollout or a restart are picked up as they become ready, the channel
+// is closed once the watcher's context is done and every pod stream has ended
+func (dl *DeploymentWatcher) Stream() <-chan LogEntry {
+	return dl.StreamContainer("")
+}
+
+// StreamContainer is Stream following the named container of each pod rather than its default one
+func (dl *DeploymentWatcher) StreamContainer(container string) <-chan LogEntry {
+	dl.mu.Lock()
+	entries := make(chan LogEntry, 10*len(dl.pods)+1)
+	names := dl.podNamesLocked()
+	dl.mu.Unlock()
+
+	var wg sync.WaitGroup
+	following := make(map[string]*Subscription)
+	follow := func(name string) {
+		sub, ok := dl.startPodStream(following, name, container)
+		if !ok {
+			return
 		}
-		logColor := color.New(color.Attribute(38), color.Attribute(5), color.Attribute(i))
-		logColors[p.Name] = logColor
+		wg.Add(1)
 		go func() {
-			pc.PodLog.StreamLogs()
+			defer wg.Done()
+			for m := range sub.Messages {
+				entries <- dl.newLogEntry(name, container, m)
+			}
+			if err := sub.Err(); err != nil {
+				dl.watchFailed(err)
+				entries <- dl.streamErrorEntry(name, container, err)
+			}
+			dl.podStreamEnded(following, name, sub)
 		}()
 	}
+	for _, name := range names {
+		follow(name)
+	}
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		dl.watchPods(follow)
+	}()
+	go func() {
+		defer wg.Done()
+		dl.watchEvents(func(e LogEntry) { entries <- e })
+	}()
+	go func() {
+		wg.Wait()
+		close(entries)
+	}()
+	return entries
+}
+
+func (dl *DeploymentWatcher) podNamesLocked() []string {
+	names := make([]string, 0, len(dl.pods))
+	for name := range dl.pods {
+		names = append(names, name)
+	}
+	return names
+}
+
+// startPodStream subscribes to the pod unless this stream already follows it or it was canceled
+func (dl *DeploymentWatcher) startPodStream(following map[string]*Subscription, name string, container string) (*Subscription, bool) {
+	dl.mu.Lock()
+	defer dl.mu.Unlock()
+	if following[name] != nil || dl.canceled[name] || dl.context.Err() != nil {
+		return nil, false
+	}
+	sub, err := dl.broker.Subscribe(name, container, dl.streamOptions)
+	if err != nil {
+		fmt.Printf("Unable to follow pod %v: %v\n", name, err)
+		return nil, false
+	}
+	following[name] = sub
+	dl.subscriptions[sub] = true
+	return sub, true
+}
+
+func (dl *DeploymentWatcher) podStreamEnded(following map[string]*Subscription, name string, sub *Subscription) {
+	dl.mu.Lock()
+	defer dl.mu.Unlock()
+	if following[name] == sub {
+		delete(following, name)
+	}
+	delete(dl.subscriptions, sub)
+	// a removed pod's counts went with it
+	if _, ok := dl.pods[name]; ok {
+		dl.ended[name] = addStreamStats(dl.ended[name], sub.Stats())
+	}
+}
+
+// SetStreamOptions sets the buffer and overflow policy of the pods Stream follows from now on.
+// Blocking, the default, never loses a line but a slow reader holds up the pod's stream
+func (dl *DeploymentWatcher) SetStreamOptions(opts SubscribeOptions) error {
+	if !ValidOverflow(opts.Overflow) {
+		return fmt.Errorf("unknown overflow policy %q, use %v, %v or %v", opts.Overflow, OverflowBlock, OverflowDropOldest, OverflowDropNewest)
+	}
+	dl.mu.Lock()
+	defer dl.mu.Unlock()
+	dl.streamOptions = opts
+	return nil
+}
+
+// SetLineOptions sets how lines over the maximum line size are handled when streaming and searching
+func (dl *DeploymentWatcher) SetLineOptions(opts LineOptions) error {
+	if err := opts.Validate(); err != nil {
+		return err
+	}
+	dl.mu.Lock()
+	defer dl.mu.Unlock()
+	dl.lines = opts
+	dl.broker.SetLineOptions(opts)
+	return nil
+}
+
+// OnWatchError calls onError whenever watching the pods or events or reading a pod's logs fails and is about to be retried
+func (dl *DeploymentWatcher) OnWatchError(onError func(error)) {
+	dl.mu.Lock()
+	defer dl.mu.Unlock()
+	dl.errorListeners = append(dl.errorListeners, onError)
+}
+
+// OnPodRemoved calls onRemoved with the name of every pod that goes away, e.g. replaced by a rollout
+func (dl *DeploymentWatcher) OnPodRemoved(onRemoved func(pod string)) {
+	dl.mu.Lock()
+	defer dl.mu.Unlock()
+	dl.removedListeners = append(dl.removedListeners, onRemoved)
+}
+
+func (dl *DeploymentWatcher) watchFailed(err error) {
+	err = wrapError(err)
+	dl.mu.Lock()
+	listeners := slices.Clone(dl.errorListeners)
+	dl.mu.Unlock()
+	for _, onError := range listeners {
+		onError(err)
+	}
+}
+
+// ActiveStreams is the number of pod streams Stream is following
+func (dl *DeploymentWatcher) ActiveStreams() int {
+	dl.mu.Lock()
+	defer dl.mu.Unlock()
+	return len(dl.subscriptions)
+}
+
+// StreamStats are the line counts of every pod Stream has followed, by pod
+func (dl *DeploymentWatcher) StreamStats() []StreamStats {
+	dl.mu.Lock()
+	byPod := make(map[string]StreamStats, len(dl.ended))
+	for pod, stats := range dl.ended {
+		byPod[pod] = stats
+	}
+	subscriptions := make([]*Subscription, 0, len(dl.subscriptions))
+	for sub := range dl.subscriptions {
+		subscriptions = append(subscriptions, sub)
+	}
+	dl.mu.Unlock()
+	for _, sub := range subscriptions {
+		stats := sub.Stats()
+		byPod[stats.Pod] = addStreamStats(byPod[stats.Pod], stats)
+	}
+	all := make([]StreamStats, 0, len(byPod))
+	for _, stats := range byPod {
+		all = append(all, stats)
+	}
+	slices.SortFunc(all, func(a, b StreamStats) int { return strings.Compare(a.Pod, b.Pod) })
+	return all
+}
+
+func addStreamStats(total StreamStats, stats StreamStats) StreamStats {
+	if total.Pod == "" {
+		total.Pod, total.Container = stats.Pod, stats.Container
+	} else if total.Container != stats.Container {
+		total.Container = ""
+	}
+	total.Received += stats.Received
+	total.Delivered += stats.Delivered
+	total.Dropped += stats.Dropped
+	return total
+}
+
+// FormatStreamStats sums the counts up for a status line, naming the pods that dropped lines
+func FormatStreamStats(stats []StreamStats) string {
+	var total StreamStats
+	var dropping []string
+	for _, s := range stats {
+		total.Received += s.Received
+		total.Delivered += s.Delivered
+		total.Dropped += s.Dropped
+		if s.Dropped > 0 {
+			dropping = append(dropping, fmt.Sprintf("%v %v", s.Pod, s.Dropped))
+		}
+	}
+	line := fmt.Sprintf("%v received, %v delivered, %v dropped", total.Received, total.Delivered, total.Dropped)
+	if len(dropping) > 0 {
+		line += " (" + strings.Join(dropping, ", ") + ")"
+	}
+	return line
+}
+
+// Subscribe follows a single pod's container, sharing the stream with the watcher's other subscribers
+func (dl *DeploymentWatcher) Subscribe(pod string, container string, opts SubscribeOptions) (*Subscription, error) {
+	return dl.broker.Subscribe(pod, container, opts)
+}
+
+// watchPods keeps our view of the deployment's pods up to date, following any pod once it is running
+func (dl *DeploymentWatcher) watchPods(follow func(string)) {
+	for dl.context.Err() == nil {
+		w, err := dl.client.WatchPods(dl.context, dl.name)
+		if err != nil {
+			fmt.Printf("Error watching pods for deployment %v: %v\n", dl.name, err)
+			dl.watchFailed(err)
+			sleepContext(dl.context, 5*time.Second)
+			continue
+		}
+		dl.handlePodEvents(w, follow)
+		w.Stop()
+	}
+}
 
+func (dl *DeploymentWatcher) handlePodEvents(w watch.Interface, follow func(string)) {
 	for {
-		for _, p := range dl.pods {
-			pc := dl.podContexts[p.Name]
-			select {
-			case m := <-pc.PodLog.Messages:
-				logColor := logColors[p.Name]
-				_, err := logColor.Println(p.Name, m)
-				if err != nil {
-					fmt.Println("unable to print log line")
+		select {
+		case <-dl.context.Done():
+			return
+		case event, ok := <-w.ResultChan():
+			if !ok {
+				return
+			}
+			pod, ok := event.Object.(*v1.Pod)
+			if !ok {
+				continue
+			}
+			switch event.Type {
+			case watch.Added, watch.Modified:
+				p := newPod(pod)
+				dl.setPod(p)
+				if !dl.knowsRevision(p.ReplicaSet) {
+					dl.loadReplicaSets()
 				}
-			case <-dl.context.Done():
-				if errors.Is(pc.context.Err(), context.Canceled) {
-					break
+				if pod.Status.Phase == v1.PodRunning {
+					follow(pod.Name)
 				}
-				pc.Cancel()
+			case watch.Deleted:
+				dl.removePod(pod.Name)
 			}
+			dl.podStatusChanged()
 		}
 	}
 }
 
+func (dl *DeploymentWatcher) setPod(pod Pod) {
+	dl.mu.Lock()
+	defer dl.mu.Unlock()
+	dl.pods[pod.Name] = pod
+}
+
+func (dl *DeploymentWatcher) removePod(name string) {
+	dl.mu.Lock()
+	delete(dl.pods, name)
+	delete(dl.ended, name)
+	pc, ok := dl.podContexts[name]
+	if ok {
+		pc.Cancel()
+		delete(dl.podContexts, name)
+	}
+	dl.broker.ClosePod(name)
+	listeners := slices.Clone(dl.removedListeners)
+	dl.mu.Unlock()
+	for _, onRemoved := range listeners {
+		onRemoved(name)
+	}
+}
+
+func sleepContext(ctx context.Context, d time.Duration) {
+	select {
+	case <-ctx.Done():
+	case <-time.After(d):
+	}
+}
+
+// StreamTo follows the deployment writing every line to all the sinks until the watcher's context is done
+func (dl *DeploymentWatcher) StreamTo(sinks ...LogSink) error {
+	return FanOut(dl.Stream(), sinks...)
+}
+
+func (dl *DeploymentWatcher) CancelPod(name string) {
+	dl.mu.Lock()
+	defer dl.mu.Unlock()
+	dl.canceled[name] = true
+	pc, ok := dl.podContexts[name]
+	if ok {
+		pc.Cancel()
+	}
+	dl.broker.ClosePod(name)
+}
+
+func (dl *DeploymentWatcher) newLogEntry(pod string, container string, line string) LogEntry {
+	t, message := ParseLogLine(line)
+	return LogEntry{
+		Time:       t,
+		Cluster:    dl.client.Cluster(),
+		Namespace:  dl.client.Namespace(),
+		Deployment: dl.name,
+		Pod:        pod,
+		Container:  container,
+		Kind:       EntryLog,
+		Message:    message,
+	}
+}
+
+// streamErrorEntry tells the stream's readers that following the pod's logs failed
+func (dl *DeploymentWatcher) streamErrorEntry(pod string, container string, err error) LogEntry {
+	return LogEntry{
+		Time:       time.Now(),
+		Cluster:    dl.client.Cluster(),
+		Namespace:  dl.client.Namespace(),
+		Deployment: dl.name,
+		Pod:        pod,
+		Container:  container,
+		Kind:       EntryEvent,
+		Level:      "warning",
+		Message:    fmt.Sprintf("Error reading logs: %v", err),
+	}
+}
+
 func (dl *DeploymentWatcher) LogAllPodsToDisk(path string, lines int64) {
+	_, err := dl.Export(path, lines, ExportOptions{Format: FormatText})
+	if err != nil {
+		fmt.Printf("Unable to write logs to %v\n", path)
+		fmt.Println(err)
+	}
+}
+
+// Export saves the last lines of every container of every pod, 0 lines saves everything
+func (dl *DeploymentWatcher) Export(path string, lines int64, opts ExportOptions) (string, error) {
 	var wg sync.WaitGroup
-	for podName, pc := range dl.podContexts {
-		wg.Add(1)
-		podName := podName
-		pc := pc
-		go func() {
-			defer wg.Done()
-			logs := pc.PodLog.GetLogs(lines)
-			logPath := filepath.Join(path, podName+".log")
-			WriteLinesToDisk(logPath, logs)
-		}()
+	var mu sync.Mutex
+	files := make([]ExportFile, 0)
+	for podName, pod := range dl.snapshotPods() {
+		for _, container := range pod.Containers {
+			wg.Add(1)
+			go func(podName string, container string) {
+				defer wg.Done()
+				pc := dl.newPodContext(podName)
+				defer pc.Cancel()
+				logOpts := v1.PodLogOptions{Timestamps: true, Container: container}
+				if lines > 0 {
+					logOpts.TailLines = &lines
+				}
+				logs := pc.PodLog.GetLogsWithOpt(logOpts)
+				file := ExportFile{Pod: podName, Container: container, Entries: dl.newLogEntries(podName, container, logs)}
+				mu.Lock()
+				files = append(files, file)
+				mu.Unlock()
+			}(podName, container)
+		}
 	}
 	wg.Wait()
+	sortExportFiles(files)
+	events, err := dl.Events()
+	if err != nil {
+		fmt.Printf("Unable to get events for %v: %v\n", dl.name, err)
+	} else if len(events) > 0 {
+		files = append(files, ExportFile{Pod: EventsName, Entries: events})
+	}
+	return WriteExport(path, files, dl.Manifest(""), opts)
+}
+
+// SearchResultFiles turns search results into something that can be exported
+func (dl *DeploymentWatcher) SearchResultFiles(results []SearchResult) []ExportFile {
+	files := make([]ExportFile, len(results))
+	for i, r := range results {
+		files[i] = ExportFile{Pod: r.PodName, Entries: dl.newLogEntries(r.PodName, "", r.Matches)}
+		if r.PodName == EventsName {
+			for j := range files[i].Entries {
+				files[i].Entries[j].Kind = EntryEvent
+			}
+		}
+	}
+	sortExportFiles(files)
+	return files
+}
+
+func (dl *DeploymentWatcher) Manifest(query string) Manifest {
+	return Manifest{Cluster: dl.client.Cluster(), Namespace: dl.client.Namespace(), Deployment: dl.name, Query: query}
+}
+
+func (dl *DeploymentWatcher) newLogEntries(pod string, container string, lines []string) []LogEntry {
+	entries := make([]LogEntry, len(lines))
+	for i, line := range lines {
+		entries[i] = dl.newLogEntry(pod, container, line)
+	}
+	return entries
+}
+
+func sortExportFiles(files []ExportFile) {
+	slices.SortFunc(files, func(a, b ExportFile) int {
+		if a.Pod != b.Pod {
+			return strings.Compare(a.Pod, b.Pod)
+		}
+		return strings.Compare(a.Container, b.Container)
+	})
 }
 
 func WriteLinesToDisk(path string, lines []string) {
@@ -167,12 +562,27 @@ func WriteLinesToDisk(path string, lines []string) {
 func (dl *DeploymentWatcher) SearchLogs(searchParams SearchParameters) []SearchResult {
 	var wg sync.WaitGroup
 	finalRes := make([]SearchResult, 0)
-	results := make(chan SearchResult, len(dl.pods))
-	for podName, pod := range dl.pods {
+	pods := dl.snapshotPods()
+	results := make(chan SearchResult, len(pods)+1)
+	if hasUsageConditions(searchParams) {
+		// make sure there is at least a reading for the last few moments
+		_, err := dl.SampleMetrics()
+		if err != nil {
+			fmt.Printf("Error getting metrics for deployment %v: %v\n", dl.name, err)
+		}
+	}
+	for podName, pod := range pods {
+		wg.Add(1)
+		pc := dl.newPodContext(podName)
+		go searchPodLogs(&wg, searchParams, podName, pc, pod, dl.usageFilter(searchParams, podName), results)
+	}
+
+	if !hasUsageConditions(searchParams) && (searchParams.AllContainers || searchParams.Container == "") {
 		wg.Add(1)
-		dl.resetPodContext(podName)
-		pc := dl.podContexts[podName]
-		go searchPodLogs(&wg, searchParams, podName, pc, pod, results)
+		go func() {
+			defer wg.Done()
+			results <- dl.searchEvents(searchParams)
+		}()
 	}
 
 	wg.Wait()
@@ -185,29 +595,30 @@ func (dl *DeploymentWatcher) SearchLogs(searchParams SearchParameters) []SearchR
 	return finalRes
 }
 
-func searchPodLogs(wg *sync.WaitGroup, searchParams SearchParameters, podname string, pc PodContext, pod Pod, resultChannel chan<- SearchResult) {
+func searchPodLogs(wg *sync.WaitGroup, searchParams SearchParameters, podname string, pc PodContext, pod Pod, keep func(string) bool, resultChannel chan<- SearchResult) {
 	defer wg.Done()
+	defer pc.Cancel()
 	matches := make([]string, 0)
 	opts := v1.PodLogOptions{Timestamps: true}
 	if searchParams.AllContainers {
 		for _, c := range pod.Containers {
-			matches = append(matches, searchContainerLog(opts, searchParams, pc, c)...)
+			matches = append(matches, searchContainerLog(opts, searchParams, pc, c, keep)...)
 		}
 	} else {
-		matches = searchContainerLog(opts, searchParams, pc, searchParams.Container)
+		matches = searchContainerLog(opts, searchParams, pc, searchParams.Container, keep)
 	}
 
 	res := SearchResult{PodName: podname, Matches: matches}
 	resultChannel <- res
 }
 
-func searchContainerLog(opts v1.PodLogOptions, searchParams SearchParameters, pc PodContext, container string) []string {
+func searchContainerLog(opts v1.PodLogOptions, searchParams SearchParameters, pc PodContext, container string, keep func(string) bool) []string {
 	opts.Container = container
 
 	if !searchParams.Since.IsZero() {
 		opts.SinceTime = &metav1.Time{Time: searchParams.Since}
 	}
-	matches := make([]string, 0)
+	matches := make([]int, 0)
 	logs := pc.PodLog.GetLogsWithOpt(opts)
 	if searchParams.Limit == 0 {
 		searchParams.Limit = int64(len(logs))
@@ -219,10 +630,23 @@ func searchContainerLog(opts v1.PodLogOptions, searchParams SearchParameters, pc
 		}
 		l := logs[i]
 		match := strings.Index(strings.ToLower(l), strings.ToLower(searchParams.Query))
-		if match > -1 {
-			matches = append(matches, l)
+		if match > -1 && (keep == nil || keep(l)) {
+			matches = append(matches, i)
 		}
 	}
 	slices.Reverse(matches)
-	return matches
+	return withContext(logs, matches, searchParams.ContextLines)
+}
+
+// withContext returns the matching lines with up to n lines either side, overlapping context is only included once
+func withContext(logs []string, matches []int, n int) []string {
+	lines := make([]string, 0, len(matches))
+	next := 0
+	for _, i := range matches {
+		start := max(i-n, next, 0)
+		end := min(i+n+1, len(logs))
+		lines = append(lines, logs[start:end]...)
+		next = end
+	}
+	return lines
 }
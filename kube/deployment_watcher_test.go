@@ -2,13 +2,20 @@ package kube
 
 import (
 	"context"
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
 	"os"
 	"testing"
+	"time"
 )
 
 func TestLogAllPodsToDisk(t *testing.T) {
 	ctx := context.Background()
-	config, err := LoadConfig(ConfigParameters{})
+	config, err := LoadConfig(NewConfigFlags("", ""))
 	if err != nil {
 		t.Error(err)
 	}
@@ -29,7 +36,7 @@ func TestLogAllPodsToDisk(t *testing.T) {
 
 func TestSearchLogs(t *testing.T) {
 	ctx := context.Background()
-	config, err := LoadConfig(ConfigParameters{})
+	config, err := LoadConfig(NewConfigFlags("", ""))
 	if err != nil {
 		t.Error(err)
 	}
@@ -47,3 +54,83 @@ func TestSearchLogs(t *testing.T) {
 		}
 	}
 }
+
+func testDeployment(name string) *appsv1.Deployment {
+	labels := map[string]string{"app": name}
+	return &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", Labels: labels},
+		Spec:       appsv1.DeploymentSpec{Selector: &metav1.LabelSelector{MatchLabels: labels}},
+	}
+}
+
+func testPod(name string, app string, phase v1.PodPhase) *v1.Pod {
+	return &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", Labels: map[string]string{"app": app}},
+		Spec:       v1.PodSpec{Containers: []v1.Container{{Name: "main"}}},
+		Status:     v1.PodStatus{Phase: phase},
+	}
+}
+
+func TestStreamPicksUpNewPods(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	clientset := fake.NewSimpleClientset(testDeployment("test"), testPod("test-1", "test", v1.PodRunning), testPod("other-1", "other", v1.PodRunning))
+	podWatch := watch.NewFake()
+	clientset.PrependWatchReactor("pods", k8stesting.DefaultWatchReactor(podWatch, nil))
+	kc := NewKubeClientFromClientset(clientset)
+	dl := NewDeploymentWatcher("test", kc, ctx)
+	if len(dl.GetPods()) != 1 {
+		t.Fatalf("expected only the deployment's pod got %v", dl.GetPods())
+	}
+
+	if err := dl.SetStreamOptions(SubscribeOptions{Overflow: "spill"}); err == nil {
+		t.Errorf("expected an unknown overflow policy to be rejected")
+	}
+	dl.SetStreamOptions(SubscribeOptions{Buffer: 100, Overflow: OverflowDropOldest})
+	entries := dl.Stream()
+	seen := make(map[string]bool)
+	waitFor := func(pod string) {
+		for !seen[pod] {
+			select {
+			case e, ok := <-entries:
+				if !ok {
+					t.Fatalf("stream closed before seeing %v", pod)
+				}
+				if e.Deployment != "test" || e.Namespace != "default" {
+					t.Errorf("unexpected entry %v", e)
+				}
+				seen[e.Pod] = true
+			case <-ctx.Done():
+				t.Fatalf("timed out waiting for logs from %v", pod)
+			}
+		}
+	}
+	waitFor("test-1")
+
+	// a rollout creates a pending pod which later starts running
+	pending := testPod("test-2", "test", v1.PodPending)
+	podWatch.Add(pending)
+	running := pending.DeepCopy()
+	running.Status.Phase = v1.PodRunning
+	podWatch.Modify(running)
+	waitFor("test-2")
+
+	if seen["other-1"] {
+		t.Errorf("streamed a pod that doesn't belong to the deployment")
+	}
+	stats := dl.StreamStats()
+	if len(stats) != 2 || stats[0].Pod != "test-1" || stats[0].Received != 1 || stats[0].Delivered != 1 {
+		t.Errorf("unexpected stream stats %+v", stats)
+	}
+
+	podWatch.Delete(running)
+	for stats = dl.StreamStats(); len(stats) != 1; stats = dl.StreamStats() {
+		if ctx.Err() != nil {
+			t.Fatalf("expected the removed pod's stream stats dropped got %+v", stats)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	cancel()
+	for range entries {
+	}
+}
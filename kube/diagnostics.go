@@ -0,0 +1,321 @@
+package kube
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+)
+
+const redacted = "[REDACTED]"
+
+/*
+A diagnostics bundle is everything another team asks for when we escalate:
+  - the deployment and its replica sets
+  - each pod's yaml with env values and credential flags redacted
+  - container statuses and why they last restarted
+  - recent events for the deployment, replica sets and pods
+  - current and previous container logs
+*/
+func (dl *DeploymentWatcher) WriteDiagnosticsBundle(path string, lines int64) (string, error) {
+	ctx := dl.context
+	deployment, err := dl.client.GetDeployment(ctx, dl.name)
+	if err != nil {
+		return "", err
+	}
+	replicaSets, err := dl.client.GetReplicaSets(ctx, deployment)
+	if err != nil {
+		return "", err
+	}
+	pods, err := dl.client.GetPodObjects(ctx, dl.name)
+	if err != nil {
+		return "", err
+	}
+	objects := map[string]bool{objectKey("Deployment", deployment.Name): true}
+	for _, rs := range replicaSets {
+		objects[objectKey("ReplicaSet", rs.Name)] = true
+	}
+	for _, pod := range pods {
+		objects[objectKey("Pod", pod.Name)] = true
+	}
+	events, err := dl.client.GetEvents(ctx, objects)
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	root := fmt.Sprintf("%v-diagnostics-%v", dl.name, now.Format("20060102T150405"))
+	bundlePath := filepath.Join(path, root+".tar.gz")
+	bw, err := NewBundleWriter(bundlePath, root)
+	if err != nil {
+		return "", err
+	}
+	err = dl.writeDiagnostics(bw, deployment, replicaSets, pods, events, lines, now)
+	if closeErr := bw.Close(); err == nil {
+		err = closeErr
+	}
+	return bundlePath, err
+}
+
+func (dl *DeploymentWatcher) writeDiagnostics(bw *BundleWriter, deployment *appsv1.Deployment, replicaSets []appsv1.ReplicaSet, pods []v1.Pod, events []v1.Event, lines int64, now time.Time) error {
+	redactor := dl.client.Redactor()
+	deployment = deployment.DeepCopy()
+	deployment.TypeMeta = metav1.TypeMeta{APIVersion: "apps/v1", Kind: "Deployment"}
+	redactObjectMeta(&deployment.ObjectMeta)
+	redactPodSpec(&deployment.Spec.Template.Spec)
+	if err := addYAML(bw, redactor, "deployment.yaml", deployment); err != nil {
+		return err
+	}
+
+	rsList := appsv1.ReplicaSetList{TypeMeta: metav1.TypeMeta{APIVersion: "v1", Kind: "List"}}
+	for _, rs := range replicaSets {
+		rs = *rs.DeepCopy()
+		rs.TypeMeta = metav1.TypeMeta{APIVersion: "apps/v1", Kind: "ReplicaSet"}
+		redactObjectMeta(&rs.ObjectMeta)
+		redactPodSpec(&rs.Spec.Template.Spec)
+		rsList.Items = append(rsList.Items, rs)
+	}
+	if err := addYAML(bw, redactor, "replicasets.yaml", rsList); err != nil {
+		return err
+	}
+
+	if err := bw.Add("events.log", []byte(redactor.Redact(FormatEvents(events)))); err != nil {
+		return err
+	}
+	if err := bw.Add("status.txt", []byte(redactor.Redact(FormatPodStatuses(pods, now)))); err != nil {
+		return err
+	}
+
+	for _, pod := range pods {
+		pod := *pod.DeepCopy()
+		pod.TypeMeta = metav1.TypeMeta{APIVersion: "v1", Kind: "Pod"}
+		redactObjectMeta(&pod.ObjectMeta)
+		redactPodSpec(&pod.Spec)
+		if err := addYAML(bw, redactor, filepath.Join("pods", pod.Name+".yaml"), &pod); err != nil {
+			return err
+		}
+		if err := dl.addContainerLogs(bw, pod, lines); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (dl *DeploymentWatcher) addContainerLogs(bw *BundleWriter, pod v1.Pod, lines int64) error {
+	restarts := make(map[string]int32)
+	for _, cs := range pod.Status.ContainerStatuses {
+		restarts[cs.Name] = cs.RestartCount
+	}
+	pc := dl.newPodContext(pod.Name)
+	defer pc.Cancel()
+	for _, c := range pod.Spec.Containers {
+		opts := v1.PodLogOptions{Timestamps: true, Container: c.Name}
+		if lines > 0 {
+			opts.TailLines = &lines
+		}
+		logs := pc.PodLog.GetLogsWithOpt(opts)
+		if err := bw.Add(filepath.Join("logs", pod.Name, c.Name+".log"), []byte(joinLines(logs))); err != nil {
+			return err
+		}
+		if restarts[c.Name] == 0 {
+			continue
+		}
+		opts.Previous = true
+		previous := pc.PodLog.GetLogsWithOpt(opts)
+		if err := bw.Add(filepath.Join("logs", pod.Name, c.Name+".previous.log"), []byte(joinLines(previous))); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func joinLines(lines []string) string {
+	if len(lines) == 0 {
+		return ""
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// addYAML redacts the marshalled text as well, statuses have IPs, termination messages and annotations
+func addYAML(bw *BundleWriter, redactor *Redactor, name string, obj any) error {
+	content, err := yaml.Marshal(obj)
+	if err != nil {
+		return err
+	}
+	return bw.Add(name, []byte(redactor.Redact(string(content))))
+}
+
+// secretName matches the names of flags and annotations whose values are likely credentials
+var secretName = regexp.MustCompile(`(?i)pass(word|wd)?|secret|token|api[_-]?key|credential|private[_-]?key|auth`)
+
+// redactObjectMeta drops the managed fields, noise to the reader, and the last applied configuration, a copy of
+// the spec with its env values. Annotations that look like credentials are redacted
+func redactObjectMeta(meta *metav1.ObjectMeta) {
+	meta.ManagedFields = nil
+	delete(meta.Annotations, "kubectl.kubernetes.io/last-applied-configuration")
+	for name := range meta.Annotations {
+		if secretName.MatchString(name) {
+			meta.Annotations[name] = redacted
+		}
+	}
+}
+
+// redactPodSpec hides env values and the values of credential flags in commands and args, references to
+// secrets and config maps are left so they can be followed up
+func redactPodSpec(spec *v1.PodSpec) {
+	for i := range spec.InitContainers {
+		redactEnv(spec.InitContainers[i].Env)
+		redactArgs(spec.InitContainers[i].Command)
+		redactArgs(spec.InitContainers[i].Args)
+	}
+	for i := range spec.Containers {
+		redactEnv(spec.Containers[i].Env)
+		redactArgs(spec.Containers[i].Command)
+		redactArgs(spec.Containers[i].Args)
+	}
+	for i := range spec.EphemeralContainers {
+		redactEnv(spec.EphemeralContainers[i].Env)
+		redactArgs(spec.EphemeralContainers[i].Command)
+		redactArgs(spec.EphemeralContainers[i].Args)
+	}
+}
+
+func redactEnv(env []v1.EnvVar) {
+	for i := range env {
+		if env[i].Value != "" {
+			env[i].Value = redacted
+		}
+	}
+}
+
+// redactArgs hides the value of any flag named like a credential, --password=value or --password value
+func redactArgs(args []string) {
+	for i := 0; i < len(args); i++ {
+		if !strings.HasPrefix(args[i], "-") {
+			continue
+		}
+		flag, _, hasValue := strings.Cut(args[i], "=")
+		if !secretName.MatchString(flag) {
+			continue
+		}
+		if hasValue {
+			args[i] = flag + "=" + redacted
+		} else if i+1 < len(args) && !strings.HasPrefix(args[i+1], "-") {
+			args[i+1] = redacted
+			i++
+		}
+	}
+}
+
+func FormatEvents(events []v1.Event) string {
+	var b strings.Builder
+	for _, e := range events {
+		b.WriteString(eventTime(e).Format(time.RFC3339) + " " + formatEvent(e) + "\n")
+	}
+	return b.String()
+}
+
+func FormatPodStatuses(pods []v1.Pod, now time.Time) string {
+	var b strings.Builder
+	for _, pod := range pods {
+		fmt.Fprintf(&b, "%v phase=%v node=%v ip=%v age=%v\n", pod.Name, pod.Status.Phase, pod.Spec.NodeName, pod.Status.PodIP, now.Sub(pod.CreationTimestamp.Time).Round(time.Second))
+		if pod.Status.Reason != "" {
+			fmt.Fprintf(&b, "  reason=%v %v\n", pod.Status.Reason, pod.Status.Message)
+		}
+		for _, cs := range pod.Status.ContainerStatuses {
+			fmt.Fprintf(&b, "  %v ready=%v restarts=%v state=%v", cs.Name, cs.Ready, cs.RestartCount, containerState(cs.State))
+			if t := cs.LastTerminationState.Terminated; t != nil {
+				fmt.Fprintf(&b, " last_termination=%v exit_code=%v finished=%v", t.Reason, t.ExitCode, t.FinishedAt.Format(time.RFC3339))
+			}
+			b.WriteString("\n")
+		}
+	}
+	return b.String()
+}
+
+func containerState(state v1.ContainerState) string {
+	switch {
+	case state.Running != nil:
+		return "running"
+	case state.Waiting != nil:
+		return "waiting:" + state.Waiting.Reason
+	case state.Terminated != nil:
+		return "terminated:" + state.Terminated.Reason
+	}
+	return "unknown"
+}
+
+func eventTime(e v1.Event) time.Time {
+	switch {
+	case !e.LastTimestamp.IsZero():
+		return e.LastTimestamp.Time
+	case !e.EventTime.IsZero():
+		return e.EventTime.Time
+	case !e.FirstTimestamp.IsZero():
+		return e.FirstTimestamp.Time
+	}
+	return e.CreationTimestamp.Time
+}
+
+func (kc *KubeClient) GetDeployment(ctx context.Context, name string) (*appsv1.Deployment, error) {
+	deployment, err := kc.client.AppsV1().Deployments(kc.namespace).Get(ctx, name, metav1.GetOptions{})
+	return deployment, wrapError(err)
+}
+
+// GetReplicaSets returns the replica sets owned by the deployment
+func (kc *KubeClient) GetReplicaSets(ctx context.Context, deployment *appsv1.Deployment) ([]appsv1.ReplicaSet, error) {
+	selector, err := metav1.LabelSelectorAsSelector(deployment.Spec.Selector)
+	if err != nil {
+		return nil, err
+	}
+	rsList, err := kc.client.AppsV1().ReplicaSets(kc.namespace).List(ctx, metav1.ListOptions{LabelSelector: selector.String()})
+	if err != nil {
+		return nil, err
+	}
+	owned := make([]appsv1.ReplicaSet, 0, len(rsList.Items))
+	for _, rs := range rsList.Items {
+		for _, owner := range rs.OwnerReferences {
+			if owner.UID == deployment.UID && owner.Kind == "Deployment" {
+				owned = append(owned, rs)
+				break
+			}
+		}
+	}
+	return owned, nil
+}
+
+func (kc *KubeClient) GetPodObjects(ctx context.Context, deploymentName string) ([]v1.Pod, error) {
+	listOptions, err := kc.podListOptions(ctx, deploymentName)
+	if err != nil {
+		return nil, err
+	}
+	podList, err := kc.client.CoreV1().Pods(kc.namespace).List(ctx, listOptions)
+	if err != nil {
+		return nil, err
+	}
+	return podList.Items, nil
+}
+
+// GetEvents returns the namespace's events involving any of the objects, keyed by objectKey, oldest first
+func (kc *KubeClient) GetEvents(ctx context.Context, involved map[string]bool) ([]v1.Event, error) {
+	eventList, err := kc.ListEvents(ctx)
+	if err != nil {
+		return nil, err
+	}
+	events := make([]v1.Event, 0)
+	for _, e := range eventList.Items {
+		if involved[objectKey(e.InvolvedObject.Kind, e.InvolvedObject.Name)] {
+			events = append(events, e)
+		}
+	}
+	sort.SliceStable(events, func(i, j int) bool { return eventTime(events[i]).Before(eventTime(events[j])) })
+	return events, nil
+}
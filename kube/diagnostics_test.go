@@ -0,0 +1,126 @@
+package kube
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func readBundle(t *testing.T, path string) map[string]string {
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := tar.NewReader(zr)
+	contents := make(map[string]string)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return contents
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		var buf bytes.Buffer
+		io.Copy(&buf, tr)
+		_, name, _ := strings.Cut(header.Name, "/")
+		contents[name] = buf.String()
+	}
+}
+
+func TestWriteDiagnosticsBundle(t *testing.T) {
+	deployment := testDeployment("web")
+	deployment.UID = "web-uid"
+	rs := &appsv1.ReplicaSet{ObjectMeta: metav1.ObjectMeta{
+		Name: "web-abc", Namespace: "default", Labels: map[string]string{"app": "web"},
+		OwnerReferences: []metav1.OwnerReference{{Kind: "Deployment", Name: "web", UID: "web-uid"}},
+	}}
+	pod := testPod("web-abc-1", "web", v1.PodRunning)
+	pod.Spec.Containers[0].Env = []v1.EnvVar{{Name: "DB_PASSWORD", Value: "hunter2"}}
+	pod.Spec.Containers[0].Command = []string{"server", "--db-password=hunter3"}
+	pod.Spec.Containers[0].Args = []string{"--api-token", "hunter4", "--port", "8080"}
+	pod.Annotations = map[string]string{"example.com/auth-secret": "hunter5", "example.com/team": "payments"}
+	pod.Status.ContainerStatuses = []v1.ContainerStatus{{
+		Name: "main", RestartCount: 2,
+		LastTerminationState: v1.ContainerState{Terminated: &v1.ContainerStateTerminated{Reason: "OOMKilled", ExitCode: 137}},
+	}}
+	event := &v1.Event{
+		ObjectMeta:     metav1.ObjectMeta{Name: "web-abc-1.1", Namespace: "default"},
+		InvolvedObject: v1.ObjectReference{Kind: "Pod", Name: "web-abc-1"},
+		Reason:         "BackOff", Message: "Back-off restarting failed container", Type: "Warning",
+	}
+	unrelated := &v1.Event{
+		ObjectMeta:     metav1.ObjectMeta{Name: "other.1", Namespace: "default"},
+		InvolvedObject: v1.ObjectReference{Kind: "Pod", Name: "other"},
+		Reason:         "Pulled",
+	}
+	kc := NewKubeClientFromClientset(fake.NewSimpleClientset(deployment, rs, pod, event, unrelated))
+	dl := NewDeploymentWatcher("web", kc, context.Background())
+
+	path, err := dl.WriteDiagnosticsBundle(t.TempDir(), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	contents := readBundle(t, path)
+	names := make([]string, 0, len(contents))
+	for name := range contents {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	expected := "deployment.yaml,events.log,logs/web-abc-1/main.log,logs/web-abc-1/main.previous.log,pods/web-abc-1.yaml,replicasets.yaml,status.txt"
+	if strings.Join(names, ",") != expected {
+		t.Errorf("unexpected bundle contents %v", names)
+	}
+	podYAML := contents["pods/web-abc-1.yaml"]
+	if strings.Contains(podYAML, "hunter") || !strings.Contains(podYAML, redacted) {
+		t.Errorf("expected env values, credential flags and annotations to be redacted:\n%v", podYAML)
+	}
+	if !strings.Contains(podYAML, "8080") || !strings.Contains(podYAML, "payments") {
+		t.Errorf("expected the other flags and annotations kept:\n%v", podYAML)
+	}
+	if !strings.Contains(contents["replicasets.yaml"], "web-abc") {
+		t.Errorf("expected the owned replica set:\n%v", contents["replicasets.yaml"])
+	}
+	if !strings.Contains(contents["events.log"], "BackOff") || strings.Contains(contents["events.log"], "Pulled") {
+		t.Errorf("expected only the pod's events:\n%v", contents["events.log"])
+	}
+	if !strings.Contains(contents["status.txt"], "restarts=2") || !strings.Contains(contents["status.txt"], "last_termination=OOMKilled exit_code=137") {
+		t.Errorf("expected restart reasons in the status:\n%v", contents["status.txt"])
+	}
+}
+
+func TestWriteDiagnosticsBundleRedacts(t *testing.T) {
+	pod := testPod("web-abc-1", "web", v1.PodRunning)
+	pod.Status.PodIP = "10.1.2.3"
+	pod.Status.HostIP = "192.168.0.7"
+	kc := NewKubeClientFromClientset(fake.NewSimpleClientset(testDeployment("web"), pod))
+	r, _ := NewRedactor(RedactOptions{Detectors: []string{DetectIP}})
+	kc.SetRedactor(r)
+	dl := NewDeploymentWatcher("web", kc, context.Background())
+
+	path, err := dl.WriteDiagnosticsBundle(t.TempDir(), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range readBundle(t, path) {
+		if strings.Contains(content, "10.1.2.3") || strings.Contains(content, "192.168.0.7") {
+			t.Errorf("expected the IPs redacted from %v:\n%v", name, content)
+		}
+	}
+}
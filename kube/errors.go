@@ -0,0 +1,45 @@
+package kube
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// Errors returned by the client are wrapped in one of these so callers can tell them apart with errors.Is
+// without depending on the kubernetes api packages
+var (
+	ErrNotFound       = errors.New("not found")
+	ErrForbidden      = errors.New("forbidden")
+	ErrUnauthorized   = errors.New("unauthorized")
+	ErrUnknownContext = errors.New("unknown context")
+	ErrUnavailable    = errors.New("cluster unavailable")
+)
+
+func wrapError(err error) error {
+	if err == nil {
+		return nil
+	}
+	switch {
+	case apierrors.IsNotFound(err):
+		return fmt.Errorf("%w: %v", ErrNotFound, err)
+	case apierrors.IsForbidden(err):
+		return fmt.Errorf("%w: %v", ErrForbidden, err)
+	case apierrors.IsUnauthorized(err):
+		return fmt.Errorf("%w: %v", ErrUnauthorized, err)
+	case clientcmd.IsContextNotFound(err), isMissingContext(err):
+		return fmt.Errorf("%w: %v", ErrUnknownContext, err)
+	case apierrors.IsServiceUnavailable(err), apierrors.IsTimeout(err), apierrors.IsServerTimeout(err), apierrors.IsTooManyRequests(err):
+		return fmt.Errorf("%w: %v", ErrUnavailable, err)
+	}
+	return err
+}
+
+// isMissingContext catches the untyped error clientcmd gives when --context names a context the kubeconfig doesn't have
+func isMissingContext(err error) bool {
+	message := err.Error()
+	return strings.HasPrefix(message, "context ") && strings.HasSuffix(message, " does not exist")
+}
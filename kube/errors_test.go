@@ -0,0 +1,33 @@
+package kube
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestTypedErrors(t *testing.T) {
+	clientset := fake.NewSimpleClientset(testDeployment("web"))
+	clientset.PrependReactor("list", "namespaces", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, apierrors.NewForbidden(schema.GroupResource{Resource: "namespaces"}, "", errors.New("no access"))
+	})
+	kc := NewKubeClientFromClientset(clientset)
+	ctx := context.Background()
+
+	if _, err := kc.ListNamespaces(ctx); !errors.Is(err, ErrForbidden) {
+		t.Errorf("expected forbidden got %v", err)
+	}
+	if _, err := kc.GetDeployment(ctx, "missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected not found got %v", err)
+	}
+	deployments, err := kc.ListDeployments(ctx)
+	if err != nil || len(deployments) != 1 {
+		t.Errorf("expected the deployment got %v %v", deployments, err)
+	}
+}
@@ -0,0 +1,252 @@
+package kube
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/watch"
+)
+
+// EventsName is used in place of a pod name for events about the deployment or its replica sets
+const EventsName = "events"
+
+func (kc *KubeClient) ListEvents(ctx context.Context) (*v1.EventList, error) {
+	return kc.client.CoreV1().Events(kc.namespace).List(ctx, metav1.ListOptions{})
+}
+
+// WatchEvents watches for the events after resourceVersion, the version of an earlier list or event
+func (kc *KubeClient) WatchEvents(ctx context.Context, resourceVersion string) (watch.Interface, error) {
+	return kc.client.CoreV1().Events(kc.namespace).Watch(ctx, metav1.ListOptions{ResourceVersion: resourceVersion, AllowWatchBookmarks: true})
+}
+
+// objectKey is how involvedObjects names an object, by kind and name, so a pod named like a replica set isn't taken for it
+func objectKey(kind string, name string) string {
+	return kind + "/" + name
+}
+
+// involvedObjects is the deployment, its replica sets and its pods, anything an event we care about could be for
+func (dl *DeploymentWatcher) involvedObjects() map[string]bool {
+	dl.mu.Lock()
+	defer dl.mu.Unlock()
+	objects := map[string]bool{objectKey("Deployment", dl.name): true}
+	for name, pod := range dl.pods {
+		objects[objectKey("Pod", name)] = true
+		if pod.ReplicaSet != "" {
+			objects[objectKey("ReplicaSet", pod.ReplicaSet)] = true
+		}
+	}
+	for name := range dl.replicaSets {
+		objects[objectKey("ReplicaSet", name)] = true
+	}
+	return objects
+}
+
+func (dl *DeploymentWatcher) loadReplicaSets() {
+	deployment, err := dl.client.GetDeployment(dl.context, dl.name)
+	if err != nil {
+		return
+	}
+	replicaSets, err := dl.client.GetReplicaSets(dl.context, deployment)
+	if err != nil {
+		return
+	}
+	dl.mu.Lock()
+	defer dl.mu.Unlock()
+	for _, rs := range replicaSets {
+		dl.replicaSets[rs.Name] = rs.Annotations[revisionAnnotation]
+	}
+}
+
+// knowsRevision is false for a replica set we haven't loaded yet, e.g. the new one of a rollout
+func (dl *DeploymentWatcher) knowsRevision(replicaSet string) bool {
+	if replicaSet == "" {
+		return true
+	}
+	dl.mu.Lock()
+	defer dl.mu.Unlock()
+	return dl.replicaSets[replicaSet] != ""
+}
+
+func (dl *DeploymentWatcher) involves(e *v1.Event) bool {
+	key := objectKey(e.InvolvedObject.Kind, e.InvolvedObject.Name)
+	if dl.involvedObjects()[key] {
+		return true
+	}
+	// a rollout's new replica set can have events before any of its pods show up. Replica sets are named after
+	// their deployment, but so are another deployment's like web-worker's, so only the ones it owns count.
+	// Those found not to be are remembered, so their events don't list the replica sets every time
+	if e.InvolvedObject.Kind != "ReplicaSet" || !strings.HasPrefix(e.InvolvedObject.Name, dl.name+"-") || dl.notOurs(e.InvolvedObject.Name) {
+		return false
+	}
+	dl.loadReplicaSets()
+	if dl.involvedObjects()[key] {
+		return true
+	}
+	dl.mu.Lock()
+	defer dl.mu.Unlock()
+	dl.foreignReplicaSets[e.InvolvedObject.Name] = true
+	return false
+}
+
+func (dl *DeploymentWatcher) notOurs(replicaSet string) bool {
+	dl.mu.Lock()
+	defer dl.mu.Unlock()
+	return dl.foreignReplicaSets[replicaSet]
+}
+
+// watchEvents emits every event involving the deployment until the watcher's context is done.
+// The events are listed once and watched from there, reconnecting from the last version seen so a
+// reconnect doesn't replay them. Only when that version has expired are they listed again
+func (dl *DeploymentWatcher) watchEvents(emit func(LogEntry)) {
+	dl.loadReplicaSets()
+	resourceVersion := ""
+	// latest is when the newest emitted event happened, a list after the first only emits what was missed
+	var latest time.Time
+	first := true
+	emitEvent := func(e *v1.Event) {
+		if at := eventTime(*e); at.After(latest) {
+			latest = at
+		}
+		emit(dl.newEventEntry(e))
+	}
+	for dl.context.Err() == nil {
+		if resourceVersion == "" {
+			list, err := dl.client.ListEvents(dl.context)
+			if err != nil {
+				fmt.Printf("Error listing events for deployment %v: %v\n", dl.name, err)
+				dl.watchFailed(err)
+				sleepContext(dl.context, 5*time.Second)
+				continue
+			}
+			events := list.Items
+			sort.SliceStable(events, func(i, j int) bool { return eventTime(events[i]).Before(eventTime(events[j])) })
+			for i := range events {
+				if dl.involves(&events[i]) && (first || eventTime(events[i]).After(latest)) {
+					emitEvent(&events[i])
+				}
+			}
+			first = false
+			resourceVersion = list.ResourceVersion
+		}
+		w, err := dl.client.WatchEvents(dl.context, resourceVersion)
+		if err != nil {
+			if apierrors.IsResourceExpired(err) || apierrors.IsGone(err) {
+				resourceVersion = ""
+				continue
+			}
+			fmt.Printf("Error watching events for deployment %v: %v\n", dl.name, err)
+			dl.watchFailed(err)
+			sleepContext(dl.context, 5*time.Second)
+			continue
+		}
+		resourceVersion = dl.handleEvents(w, resourceVersion, emitEvent)
+		w.Stop()
+	}
+}
+
+// handleEvents emits the watched events involving the deployment, returning the version to watch from
+// next, empty once it has expired and the events have to be listed again
+func (dl *DeploymentWatcher) handleEvents(w watch.Interface, resourceVersion string, emit func(*v1.Event)) string {
+	for {
+		select {
+		case <-dl.context.Done():
+			return resourceVersion
+		case event, ok := <-w.ResultChan():
+			if !ok {
+				return resourceVersion
+			}
+			if event.Type == watch.Error {
+				if status, ok := event.Object.(*metav1.Status); ok && status.Code == http.StatusGone {
+					return ""
+				}
+				return resourceVersion
+			}
+			e, ok := event.Object.(*v1.Event)
+			if !ok {
+				continue
+			}
+			if e.ResourceVersion != "" {
+				resourceVersion = e.ResourceVersion
+			}
+			if event.Type == watch.Bookmark || event.Type == watch.Deleted || !dl.involves(e) {
+				continue
+			}
+			emit(e)
+		}
+	}
+}
+
+func (dl *DeploymentWatcher) newEventEntry(e *v1.Event) LogEntry {
+	pod := EventsName
+	if e.InvolvedObject.Kind == "Pod" {
+		pod = e.InvolvedObject.Name
+	}
+	level := "info"
+	if e.Type == v1.EventTypeWarning {
+		level = "warning"
+	}
+	return LogEntry{
+		Time:       eventTime(*e),
+		Cluster:    dl.client.Cluster(),
+		Namespace:  dl.client.Namespace(),
+		Deployment: dl.name,
+		Pod:        pod,
+		Kind:       EntryEvent,
+		Level:      level,
+		Message:    dl.client.Redactor().Redact(formatEvent(*e)),
+	}
+}
+
+func formatEvent(e v1.Event) string {
+	message := fmt.Sprintf("%v %v %v/%v: %v", e.Type, e.Reason, e.InvolvedObject.Kind, e.InvolvedObject.Name, e.Message)
+	if e.Count > 1 {
+		message += fmt.Sprintf(" (x%v)", e.Count)
+	}
+	return message
+}
+
+// Events returns the recent events involving the deployment as entries, oldest first
+func (dl *DeploymentWatcher) Events() ([]LogEntry, error) {
+	dl.loadReplicaSets()
+	events, err := dl.client.GetEvents(dl.context, dl.involvedObjects())
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]LogEntry, len(events))
+	for i := range events {
+		entries[i] = dl.newEventEntry(&events[i])
+	}
+	return entries, nil
+}
+
+func (dl *DeploymentWatcher) searchEvents(searchParams SearchParameters) SearchResult {
+	res := SearchResult{PodName: EventsName, Matches: make([]string, 0)}
+	entries, err := dl.Events()
+	if err != nil {
+		fmt.Printf("Error searching events for deployment %v: %v\n", dl.name, err)
+		return res
+	}
+	query := strings.ToLower(searchParams.Query)
+	for i := len(entries) - 1; i >= 0; i-- {
+		if searchParams.Limit > 0 && len(res.Matches) >= int(searchParams.Limit) {
+			break
+		}
+		e := entries[i]
+		if !searchParams.Since.IsZero() && e.Time.Before(searchParams.Since) {
+			continue
+		}
+		if strings.Contains(strings.ToLower(e.Message), query) {
+			res.Matches = append(res.Matches, e.Line())
+		}
+	}
+	slices.Reverse(res.Matches)
+	return res
+}
@@ -0,0 +1,191 @@
+package kube
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func testEvent(name string, kind string, object string, eventType string, reason string, at time.Time) *v1.Event {
+	return &v1.Event{
+		ObjectMeta:     metav1.ObjectMeta{Name: name, Namespace: "default"},
+		InvolvedObject: v1.ObjectReference{Kind: kind, Name: object},
+		Type:           eventType,
+		Reason:         reason,
+		Message:        strings.ToLower(reason) + " happened",
+		LastTimestamp:  metav1.NewTime(at),
+	}
+}
+
+func TestHandleEvents(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	pod := testPod("web-abc-1", "web", v1.PodRunning)
+	pod.OwnerReferences = []metav1.OwnerReference{{Kind: "ReplicaSet", Name: "web-abc"}}
+	deployment := testDeployment("web")
+	deployment.UID = "web-uid"
+	// the new replica set of a rollout, with no pods yet, and another deployment's named like ours
+	rollout := &appsv1.ReplicaSet{ObjectMeta: metav1.ObjectMeta{
+		Name: "web-def", Namespace: "default", Labels: map[string]string{"app": "web"},
+		OwnerReferences: []metav1.OwnerReference{{Kind: "Deployment", Name: "web", UID: "web-uid"}},
+	}}
+	worker := &appsv1.ReplicaSet{ObjectMeta: metav1.ObjectMeta{
+		Name: "web-worker-7d9f", Namespace: "default", Labels: map[string]string{"app": "web"},
+		OwnerReferences: []metav1.OwnerReference{{Kind: "Deployment", Name: "web-worker", UID: "worker-uid"}},
+	}}
+	clientset := fake.NewSimpleClientset(deployment, pod)
+	kc := NewKubeClientFromClientset(clientset)
+	dl := NewDeploymentWatcher("web", kc, ctx)
+	kc.client.AppsV1().ReplicaSets("default").Create(ctx, rollout, metav1.CreateOptions{})
+	kc.client.AppsV1().ReplicaSets("default").Create(ctx, worker, metav1.CreateOptions{})
+
+	w := watch.NewFake()
+	emitted := make(chan LogEntry, 10)
+	go dl.handleEvents(w, "1", func(e *v1.Event) { emitted <- dl.newEventEntry(e) })
+
+	now := time.Now()
+	w.Add(testEvent("1", "Pod", "web-abc-1", v1.EventTypeWarning, "OOMKilling", now))
+	w.Add(testEvent("2", "Pod", "api-1", v1.EventTypeNormal, "Pulled", now))
+	w.Add(testEvent("3", "ReplicaSet", "web-abc", v1.EventTypeNormal, "SuccessfulCreate", now))
+	w.Add(testEvent("4", "Deployment", "web", v1.EventTypeNormal, "ScalingReplicaSet", now))
+	w.Add(testEvent("5", "ReplicaSet", "web-worker-7d9f", v1.EventTypeWarning, "FailedCreate", now))
+	w.Add(testEvent("6", "ReplicaSet", "web-def", v1.EventTypeNormal, "SuccessfulDelete", now))
+	lists := len(clientset.Actions())
+	w.Add(testEvent("7", "ReplicaSet", "web-worker-7d9f", v1.EventTypeWarning, "FailedCreate", now))
+	w.Add(testEvent("8", "Pod", "web-abc", v1.EventTypeNormal, "Pulled", now))
+	w.Stop()
+
+	expected := []struct{ pod, level, reason string }{
+		{"web-abc-1", "warning", "OOMKilling"},
+		{EventsName, "info", "SuccessfulCreate"},
+		{EventsName, "info", "ScalingReplicaSet"},
+		{EventsName, "info", "SuccessfulDelete"},
+	}
+	for _, ex := range expected {
+		select {
+		case e := <-emitted:
+			if !e.IsEvent() || e.Pod != ex.pod || e.Level != ex.level || !strings.Contains(e.Message, ex.reason) || e.Deployment != "web" {
+				t.Errorf("expected a %v event for %v got %+v", ex.reason, ex.pod, e)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for the %v event", ex.reason)
+		}
+	}
+	select {
+	case e := <-emitted:
+		t.Errorf("unexpected event %+v", e)
+	default:
+	}
+	if len(clientset.Actions()) != lists {
+		t.Errorf("expected another deployment's replica set to be remembered got %v", clientset.Actions()[lists:])
+	}
+}
+
+func TestWatchEventsResumes(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	clientset := fake.NewSimpleClientset(testDeployment("web"), testPod("web-abc-1", "web", v1.PodRunning))
+	now := time.Now()
+	lists := make(chan bool, 10)
+	clientset.PrependReactor("list", "events", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		lists <- true
+		old := testEvent("old", "Pod", "web-abc-1", v1.EventTypeWarning, "BackOff", now.Add(-time.Hour))
+		return true, &v1.EventList{ListMeta: metav1.ListMeta{ResourceVersion: "10"}, Items: []v1.Event{*old}}, nil
+	})
+	watches := make(chan string, 10)
+	watchers := make(chan *watch.FakeWatcher, 10)
+	clientset.PrependWatchReactor("events", func(action k8stesting.Action) (bool, watch.Interface, error) {
+		w := watch.NewFake()
+		watches <- action.(k8stesting.WatchAction).GetWatchRestrictions().ResourceVersion
+		watchers <- w
+		return true, w, nil
+	})
+	dl := NewDeploymentWatcher("web", NewKubeClientFromClientset(clientset), ctx)
+	emitted := make(chan LogEntry, 10)
+	go dl.watchEvents(func(e LogEntry) { emitted <- e })
+
+	expectWatch := func(version string) *watch.FakeWatcher {
+		select {
+		case got := <-watches:
+			if got != version {
+				t.Errorf("expected to watch from %q got %q", version, got)
+			}
+			return <-watchers
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting to watch from %q", version)
+		}
+		return nil
+	}
+	expectEvent := func(reason string) {
+		select {
+		case e := <-emitted:
+			if !strings.Contains(e.Message, reason) {
+				t.Errorf("expected the %v event got %v", reason, e.Message)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for the %v event", reason)
+		}
+	}
+
+	expectEvent("BackOff")
+	w := expectWatch("10")
+	created := testEvent("new", "Pod", "web-abc-1", v1.EventTypeNormal, "Started", now)
+	created.ResourceVersion = "11"
+	w.Add(created)
+	expectEvent("Started")
+	w.Stop()
+
+	// a closed watch carries on from the last event without listing the events again
+	w = expectWatch("11")
+	if len(lists) != 1 {
+		t.Errorf("expected a reconnect not to list the events again")
+	}
+	// until that version has expired, then the list only emits what was missed
+	w.Error(&metav1.Status{Code: http.StatusGone})
+	expectWatch("10")
+	if len(lists) != 2 {
+		t.Errorf("expected the events listed again once the version expired")
+	}
+	select {
+	case e := <-emitted:
+		t.Errorf("expected the events already emitted not to be replayed got %v", e.Message)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestSearchLogsIncludesEvents(t *testing.T) {
+	deployment := testDeployment("web")
+	deployment.UID = "web-uid"
+	rs := &appsv1.ReplicaSet{ObjectMeta: metav1.ObjectMeta{
+		Name: "web-abc", Namespace: "default", Labels: map[string]string{"app": "web"},
+		OwnerReferences: []metav1.OwnerReference{{Kind: "Deployment", Name: "web", UID: "web-uid"}},
+	}}
+	now := time.Now()
+	kc := NewKubeClientFromClientset(fake.NewSimpleClientset(
+		deployment, rs, testPod("web-abc-1", "web", v1.PodRunning),
+		testEvent("1", "Pod", "web-abc-1", v1.EventTypeWarning, "BackOff", now.Add(-2*time.Hour)),
+		testEvent("2", "ReplicaSet", "web-abc", v1.EventTypeWarning, "FailedCreate", now),
+		testEvent("3", "Pod", "api-1", v1.EventTypeWarning, "BackOff", now),
+	))
+	dl := NewDeploymentWatcher("web", kc, context.Background())
+
+	results := dl.SearchLogs(SearchParameters{Query: "backoff", AllContainers: true})
+	if len(results) != 1 || results[0].PodName != EventsName || len(results[0].Matches) != 1 || !strings.Contains(results[0].Matches[0], "Pod/web-abc-1") {
+		t.Errorf("expected only the deployment's pod event to match got %v", results)
+	}
+
+	results = dl.SearchLogs(SearchParameters{Query: "fail", AllContainers: true, Since: now.Add(-time.Hour)})
+	if len(results) != 1 || len(results[0].Matches) != 1 || !strings.Contains(results[0].Matches[0], "FailedCreate") {
+		t.Errorf("expected the replica set event got %v", results)
+	}
+}
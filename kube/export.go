@@ -0,0 +1,274 @@
+package kube
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	FormatText      = "text"
+	FormatJSONLines = "jsonl"
+	FormatCSV       = "csv"
+)
+
+type ExportOptions struct {
+	Format string `json:"format"`
+	// Compress gzips each file, bundles are always compressed
+	Compress bool `json:"compress"`
+	// Bundle writes a single .tar.gz holding every file plus a manifest.json
+	Bundle bool `json:"bundle"`
+}
+
+type ExportFile struct {
+	Pod       string
+	Container string
+	Entries   []LogEntry
+}
+
+type ManifestFile struct {
+	Name      string `json:"name"`
+	Pod       string `json:"pod"`
+	Container string `json:"container,omitempty"`
+	Lines     int    `json:"lines"`
+}
+
+// Manifest describes what went into an export, so whoever opens a bundle attached to a ticket knows what they are looking at
+type Manifest struct {
+	Cluster    string         `json:"cluster"`
+	Namespace  string         `json:"namespace"`
+	Deployment string         `json:"deployment"`
+	Query      string         `json:"query,omitempty"`
+	From       time.Time      `json:"from"`
+	To         time.Time      `json:"to"`
+	Format     string         `json:"format"`
+	CreatedAt  time.Time      `json:"created_at"`
+	Files      []ManifestFile `json:"files"`
+}
+
+func ValidExportFormat(format string) bool {
+	switch format {
+	case FormatText, FormatJSONLines, FormatCSV:
+		return true
+	}
+	return false
+}
+
+func exportExtension(format string) string {
+	switch format {
+	case FormatJSONLines:
+		return ".jsonl"
+	case FormatCSV:
+		return ".csv"
+	}
+	return ".log"
+}
+
+// exportName is <pod>.<ext>, pods with more than one container get <pod>-<container>.<ext>
+func exportName(file ExportFile, multiContainer bool, format string) string {
+	name := file.Pod
+	if multiContainer && file.Container != "" {
+		name += "-" + file.Container
+	}
+	return name + exportExtension(format)
+}
+
+func EncodeEntries(w io.Writer, entries []LogEntry, format string) error {
+	switch format {
+	case FormatJSONLines:
+		encoder := json.NewEncoder(w)
+		for _, e := range entries {
+			if err := encoder.Encode(e); err != nil {
+				return err
+			}
+		}
+		return nil
+	case FormatCSV:
+		cw := csv.NewWriter(w)
+		cw.Write([]string{"time", "cluster", "namespace", "deployment", "pod", "container", "kind", "level", "message"})
+		for _, e := range entries {
+			ts := ""
+			if !e.Time.IsZero() {
+				ts = e.Time.Format(time.RFC3339Nano)
+			}
+			cw.Write([]string{ts, e.Cluster, e.Namespace, e.Deployment, e.Pod, e.Container, e.Kind, e.Level, e.Message})
+		}
+		cw.Flush()
+		return cw.Error()
+	case FormatText, "":
+		for _, e := range entries {
+			if _, err := io.WriteString(w, e.Line()+"\n"); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown export format %q", format)
+}
+
+// WriteExport writes the files to the directory at path, the current directory if empty, or as a single
+// bundle inside it, returning the path of what was written
+func WriteExport(path string, files []ExportFile, manifest Manifest, opts ExportOptions) (string, error) {
+	if path == "" {
+		path = "."
+	}
+	if opts.Format == "" {
+		opts.Format = FormatText
+	}
+	if !ValidExportFormat(opts.Format) {
+		return "", fmt.Errorf("unknown export format %q", opts.Format)
+	}
+	err := os.MkdirAll(path, 0o755)
+	if err != nil {
+		return "", err
+	}
+
+	containers := make(map[string]int)
+	for _, f := range files {
+		containers[f.Pod]++
+	}
+	manifest.Format = opts.Format
+	manifest.CreatedAt = time.Now()
+	manifest.Files = make([]ManifestFile, 0, len(files))
+	for _, f := range files {
+		name := exportName(f, containers[f.Pod] > 1, opts.Format)
+		if opts.Compress && !opts.Bundle {
+			name += ".gz"
+		}
+		manifest.Files = append(manifest.Files, ManifestFile{Name: name, Pod: f.Pod, Container: f.Container, Lines: len(f.Entries)})
+		for _, e := range f.Entries {
+			if e.Time.IsZero() {
+				continue
+			}
+			if manifest.From.IsZero() || e.Time.Before(manifest.From) {
+				manifest.From = e.Time
+			}
+			if e.Time.After(manifest.To) {
+				manifest.To = e.Time
+			}
+		}
+	}
+
+	if opts.Bundle {
+		bundlePath := filepath.Join(path, bundleName(manifest))
+		return bundlePath, writeBundle(bundlePath, files, manifest)
+	}
+	for i, f := range files {
+		err = writeExportFile(filepath.Join(path, manifest.Files[i].Name), f.Entries, opts)
+		if err != nil {
+			return path, err
+		}
+	}
+	return path, nil
+}
+
+func bundleName(manifest Manifest) string {
+	name := manifest.Deployment
+	if name == "" {
+		name = "logs"
+	}
+	return fmt.Sprintf("%v-%v.tar.gz", name, manifest.CreatedAt.Format("20060102T150405"))
+}
+
+func writeExportFile(path string, entries []LogEntry, opts ExportOptions) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	var w io.Writer = f
+	var zw *gzip.Writer
+	if opts.Compress {
+		zw = gzip.NewWriter(f)
+		w = zw
+	}
+	err = EncodeEntries(w, entries, opts.Format)
+	if zw != nil {
+		if closeErr := zw.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+// BundleWriter writes a gzipped tarball with every file under a single top level directory
+type BundleWriter struct {
+	f    *os.File
+	zw   *gzip.Writer
+	tw   *tar.Writer
+	root string
+}
+
+func NewBundleWriter(path string, root string) (*BundleWriter, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	zw := gzip.NewWriter(f)
+	return &BundleWriter{f: f, zw: zw, tw: tar.NewWriter(zw), root: root}, nil
+}
+
+func (b *BundleWriter) Add(name string, content []byte) error {
+	header := tar.Header{
+		Name:    strings.TrimPrefix(filepath.ToSlash(filepath.Join(b.root, name)), "/"),
+		Mode:    0o644,
+		Size:    int64(len(content)),
+		ModTime: time.Now(),
+	}
+	if err := b.tw.WriteHeader(&header); err != nil {
+		return err
+	}
+	_, err := b.tw.Write(content)
+	return err
+}
+
+func (b *BundleWriter) AddJSON(name string, v any) error {
+	content, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	return b.Add(name, content)
+}
+
+func (b *BundleWriter) Close() error {
+	err := b.tw.Close()
+	if closeErr := b.zw.Close(); err == nil {
+		err = closeErr
+	}
+	if closeErr := b.f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+func writeBundle(path string, files []ExportFile, manifest Manifest) error {
+	root := strings.TrimSuffix(filepath.Base(path), ".tar.gz")
+	bw, err := NewBundleWriter(path, root)
+	if err != nil {
+		return err
+	}
+	for i, f := range files {
+		var buf bytes.Buffer
+		if err = EncodeEntries(&buf, f.Entries, manifest.Format); err != nil {
+			break
+		}
+		if err = bw.Add(manifest.Files[i].Name, buf.Bytes()); err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = bw.AddJSON("manifest.json", manifest)
+	}
+	if closeErr := bw.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
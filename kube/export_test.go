@@ -0,0 +1,125 @@
+package kube
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testExportFiles() []ExportFile {
+	t1 := time.Date(2023, 9, 1, 12, 0, 0, 0, time.UTC)
+	t2 := t1.Add(time.Minute)
+	return []ExportFile{
+		{Pod: "web-1", Container: "app", Entries: []LogEntry{{Time: t1, Pod: "web-1", Container: "app", Kind: EntryLog, Level: "info", Message: "hello, world"}}},
+		{Pod: "web-1", Container: "sidecar", Entries: []LogEntry{{Time: t2, Pod: "web-1", Container: "sidecar", Message: "proxy up"}}},
+		{Pod: "web-2", Container: "app", Entries: []LogEntry{{Time: t2, Pod: "web-2", Container: "app", Message: "hi"}}},
+	}
+}
+
+func TestWriteExportFormats(t *testing.T) {
+	dir := t.TempDir()
+	_, err := WriteExport(dir, testExportFiles(), Manifest{Deployment: "web"}, ExportOptions{Format: FormatCSV})
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(filepath.Join(dir, "web-1-app.csv"))
+	if err != nil {
+		t.Fatalf("expected multi container pods to get a file per container: %v", err)
+	}
+	records, err := csv.NewReader(f).ReadAll()
+	f.Close()
+	if err != nil || len(records) != 2 || records[0][6] != "kind" || records[1][6] != EntryLog || records[1][7] != "info" || records[1][8] != "hello, world" {
+		t.Errorf("unexpected csv %v - %v", records, err)
+	}
+
+	_, err = WriteExport(dir, testExportFiles(), Manifest{}, ExportOptions{Format: FormatJSONLines, Compress: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err = os.Open(filepath.Join(dir, "web-2.jsonl.gz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var entry LogEntry
+	if err = json.NewDecoder(zr).Decode(&entry); err != nil || entry.Message != "hi" {
+		t.Errorf("unexpected json line %v - %v", entry, err)
+	}
+
+	if _, err = WriteExport(dir, testExportFiles(), Manifest{}, ExportOptions{Format: "xml"}); err == nil {
+		t.Errorf("expected an unknown format to fail")
+	}
+}
+
+func TestWriteExportToCurrentDirectory(t *testing.T) {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	if err = os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	if _, err = WriteExport("", testExportFiles(), Manifest{}, ExportOptions{}); err != nil {
+		t.Fatalf("expected no path to write to the current directory: %v", err)
+	}
+	if _, err = os.Stat(filepath.Join(dir, "web-2.log")); err != nil {
+		t.Errorf("expected the logs in the current directory: %v", err)
+	}
+}
+
+func TestWriteExportBundle(t *testing.T) {
+	dir := t.TempDir()
+	path, err := WriteExport(dir, testExportFiles(), Manifest{Cluster: "prod", Namespace: "shop", Deployment: "web", Query: "hello"}, ExportOptions{Bundle: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(filepath.Base(path), "web-") || !strings.HasSuffix(path, ".tar.gz") {
+		t.Errorf("unexpected bundle name %v", path)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := tar.NewReader(zr)
+	names := make([]string, 0)
+	var manifest Manifest
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, filepath.Base(header.Name))
+		if filepath.Base(header.Name) == "manifest.json" {
+			json.NewDecoder(tr).Decode(&manifest)
+		}
+	}
+	if strings.Join(names, ",") != "web-1-app.log,web-1-sidecar.log,web-2.log,manifest.json" {
+		t.Errorf("unexpected bundle contents %v", names)
+	}
+	if manifest.Cluster != "prod" || manifest.Query != "hello" || len(manifest.Files) != 3 || manifest.To.Sub(manifest.From) != time.Minute {
+		t.Errorf("unexpected manifest %+v", manifest)
+	}
+}
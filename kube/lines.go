@@ -0,0 +1,113 @@
+package kube
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"unicode/utf8"
+)
+
+// What happens to a line longer than the maximum line size
+const (
+	// LongLinesTruncate keeps the start of the line, ending it with TruncatedMarker
+	LongLinesTruncate = "truncate"
+	// LongLinesSplit breaks the line into pieces, each but the last ending with SplitMarker
+	LongLinesSplit = "split"
+
+	DefaultMaxLineSize = 1024 * 1024
+	TruncatedMarker    = " [truncated]"
+	SplitMarker        = " [continued]"
+)
+
+type LineOptions struct {
+	// MaxLineSize is the longest line in bytes passed on whole, DefaultMaxLineSize if not set
+	MaxLineSize int `json:"max_line_size"`
+	// LongLines is LongLinesTruncate, the default, or LongLinesSplit
+	LongLines string `json:"long_lines"`
+}
+
+func (o LineOptions) Validate() error {
+	if o.MaxLineSize < 0 {
+		return fmt.Errorf("the max line size can't be negative")
+	}
+	if o.LongLines != "" && o.LongLines != LongLinesTruncate && o.LongLines != LongLinesSplit {
+		return fmt.Errorf("unknown long line handling %q, use %v or %v", o.LongLines, LongLinesTruncate, LongLinesSplit)
+	}
+	return nil
+}
+
+// lineReader reads log lines of any length, a line over the maximum size is cut up rather than
+// ending the stream like bufio.Scanner's token limit does
+type lineReader struct {
+	scanner *bufio.Scanner
+	split   bool
+	max     int
+	// cut is set when the last line read was cut short, skipping while the rest of a truncated line is read past
+	cut      bool
+	skipping bool
+}
+
+func newLineReader(r io.Reader, opts LineOptions) *lineReader {
+	lr := &lineReader{scanner: bufio.NewScanner(r), split: opts.LongLines == LongLinesSplit, max: opts.MaxLineSize}
+	if lr.max <= 0 {
+		lr.max = DefaultMaxLineSize
+	}
+	// room for a \r\n past the maximum so a line that is too long can be told apart from one that isn't
+	lr.scanner.Buffer(nil, lr.max+2)
+	lr.scanner.Split(lr.scanLines)
+	return lr
+}
+
+func (lr *lineReader) scanLines(data []byte, atEOF bool) (int, []byte, error) {
+	if lr.skipping {
+		if i := bytes.IndexByte(data, '\n'); i >= 0 {
+			lr.skipping = false
+			return i + 1, nil, nil
+		}
+		return len(data), nil, nil
+	}
+	lr.cut = false
+	if i := bytes.IndexByte(data, '\n'); i >= 0 {
+		if line := bytes.TrimSuffix(data[:i], []byte("\r")); len(line) <= lr.max {
+			return i + 1, line, nil
+		}
+	}
+	if len(data) > lr.max {
+		lr.cut = true
+		lr.skipping = !lr.split
+		// don't cut a character in half
+		end := lr.max
+		for end > 0 && !utf8.RuneStart(data[end]) {
+			end--
+		}
+		if end == 0 {
+			end = lr.max
+		}
+		return end, data[:end], nil
+	}
+	if atEOF && len(data) > 0 {
+		return len(data), bytes.TrimSuffix(data, []byte("\r")), nil
+	}
+	return 0, nil, nil
+}
+
+func (lr *lineReader) Scan() bool {
+	return lr.scanner.Scan()
+}
+
+// Text is the line read, with a marker if it was cut short
+func (lr *lineReader) Text() string {
+	text := lr.scanner.Text()
+	switch {
+	case lr.cut && lr.split:
+		return text + SplitMarker
+	case lr.cut:
+		return text + TruncatedMarker
+	}
+	return text
+}
+
+func (lr *lineReader) Err() error {
+	return lr.scanner.Err()
+}
@@ -0,0 +1,49 @@
+package kube
+
+import (
+	"strings"
+	"testing"
+)
+
+func readLines(input string, opts LineOptions) []string {
+	reader := newLineReader(strings.NewReader(input), opts)
+	var lines []string
+	for reader.Scan() {
+		lines = append(lines, reader.Text())
+	}
+	return lines
+}
+
+func TestLineReader(t *testing.T) {
+	long := strings.Repeat("x", 25)
+	input := "first\r\n" + long + "\nafter\nlast"
+	cases := map[string]struct {
+		opts     LineOptions
+		expected []string
+	}{
+		"default": {LineOptions{}, []string{"first", long, "after", "last"}},
+		"truncate": {LineOptions{MaxLineSize: 10}, []string{
+			"first", "xxxxxxxxxx" + TruncatedMarker, "after", "last",
+		}},
+		"split": {LineOptions{MaxLineSize: 10, LongLines: LongLinesSplit}, []string{
+			"first", "xxxxxxxxxx" + SplitMarker, "xxxxxxxxxx" + SplitMarker, "xxxxx", "after", "last",
+		}},
+		"crlf line of the max size": {LineOptions{MaxLineSize: 5}, []string{"first", "xxxxx" + TruncatedMarker, "after", "last"}},
+	}
+	for name, c := range cases {
+		lines := readLines(input, c.opts)
+		if strings.Join(lines, "|") != strings.Join(c.expected, "|") {
+			t.Errorf("%v: expected %q got %q", name, c.expected, lines)
+		}
+	}
+
+	// a multi-byte character isn't cut in half
+	lines := readLines("aaé\n", LineOptions{MaxLineSize: 3, LongLines: LongLinesSplit})
+	if len(lines) != 2 || lines[0] != "aa"+SplitMarker || lines[1] != "é" {
+		t.Errorf("unexpected split %q", lines)
+	}
+
+	if err := (LineOptions{LongLines: "wrap"}).Validate(); err == nil {
+		t.Errorf("expected unknown long line handling to be rejected")
+	}
+}
@@ -0,0 +1,75 @@
+package kube
+
+import (
+	"errors"
+	"strings"
+	"time"
+)
+
+const (
+	EntryLog   = "log"
+	EntryEvent = "event"
+)
+
+type LogEntry struct {
+	Time       time.Time `json:"time"`
+	Cluster    string    `json:"cluster,omitempty"`
+	Namespace  string    `json:"namespace"`
+	Deployment string    `json:"deployment"`
+	Pod        string    `json:"pod"`
+	Container  string    `json:"container,omitempty"`
+	Kind       string    `json:"kind"`
+	Level      string    `json:"level,omitempty"`
+	Message    string    `json:"message"`
+}
+
+func (e LogEntry) IsEvent() bool {
+	return e.Kind == EntryEvent
+}
+
+// LogSink is anywhere the merged log stream of a watcher can be written to
+type LogSink interface {
+	Write(entry LogEntry) error
+	Close() error
+}
+
+// ParseLogLine splits off the timestamp kubernetes prefixes lines with when PodLogOptions.Timestamps is set,
+// lines without one are returned as is with a zero time
+func ParseLogLine(line string) (time.Time, string) {
+	ts, rest, found := strings.Cut(line, " ")
+	if !found {
+		ts = line
+	}
+	t, err := time.Parse(time.RFC3339Nano, ts)
+	if err != nil {
+		return time.Time{}, line
+	}
+	return t, rest
+}
+
+// Line renders the entry the way kubernetes gave it to us, timestamp first
+func (e LogEntry) Line() string {
+	if e.Time.IsZero() {
+		return e.Message
+	}
+	return e.Time.Format(time.RFC3339Nano) + " " + e.Message
+}
+
+// FanOut writes every entry to every sink until the channel is closed, then closes the sinks.
+// A failing sink doesn't stop the others, all errors are returned once the stream ends.
+func FanOut(entries <-chan LogEntry, sinks ...LogSink) error {
+	failed := make([]error, len(sinks))
+	for entry := range entries {
+		for i, sink := range sinks {
+			if err := sink.Write(entry); err != nil && failed[i] == nil {
+				failed[i] = err
+			}
+		}
+	}
+	for i, sink := range sinks {
+		if err := sink.Close(); err != nil && failed[i] == nil {
+			failed[i] = err
+		}
+	}
+	return errors.Join(failed...)
+}
@@ -0,0 +1,269 @@
+package kube
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metricsv1beta1 "k8s.io/metrics/pkg/apis/metrics/v1beta1"
+	metricsclient "k8s.io/metrics/pkg/client/clientset/versioned"
+)
+
+type ContainerLimits struct {
+	CPUMillis   int64 `json:"cpu_millis,omitempty"`
+	MemoryBytes int64 `json:"memory_bytes,omitempty"`
+}
+
+type ContainerUsage struct {
+	Container        string `json:"container"`
+	CPUMillis        int64  `json:"cpu_millis"`
+	MemoryBytes      int64  `json:"memory_bytes"`
+	CPULimitMillis   int64  `json:"cpu_limit_millis,omitempty"`
+	MemoryLimitBytes int64  `json:"memory_limit_bytes,omitempty"`
+}
+
+// MetricsSample is a pod's usage averaged over [Time-Window, Time]
+type MetricsSample struct {
+	Time       time.Time        `json:"time"`
+	Window     time.Duration    `json:"window"`
+	Pod        string           `json:"pod"`
+	Containers []ContainerUsage `json:"containers"`
+}
+
+func (s MetricsSample) usage(value func(ContainerUsage) (int64, int64)) (int64, int64) {
+	var used, limit int64
+	for _, c := range s.Containers {
+		u, l := value(c)
+		used += u
+		limit += l
+	}
+	return used, limit
+}
+
+func (s MetricsSample) CPUMillis() int64 {
+	used, _ := s.usage(func(c ContainerUsage) (int64, int64) { return c.CPUMillis, 0 })
+	return used
+}
+
+func (s MetricsSample) MemoryBytes() int64 {
+	used, _ := s.usage(func(c ContainerUsage) (int64, int64) { return c.MemoryBytes, 0 })
+	return used
+}
+
+// MemoryPercent is usage as a percent of the limits of the containers that have one, -1 if none do
+func (s MetricsSample) MemoryPercent() float64 {
+	return percent(s.usage(func(c ContainerUsage) (int64, int64) {
+		if c.MemoryLimitBytes == 0 {
+			return 0, 0
+		}
+		return c.MemoryBytes, c.MemoryLimitBytes
+	}))
+}
+
+// CPUPercent is usage as a percent of the limits of the containers that have one, -1 if none do
+func (s MetricsSample) CPUPercent() float64 {
+	return percent(s.usage(func(c ContainerUsage) (int64, int64) {
+		if c.CPULimitMillis == 0 {
+			return 0, 0
+		}
+		return c.CPUMillis, c.CPULimitMillis
+	}))
+}
+
+func percent(used int64, limit int64) float64 {
+	if limit == 0 {
+		return -1
+	}
+	return float64(used) / float64(limit) * 100
+}
+
+func FormatMetricsSample(s MetricsSample) string {
+	status := fmt.Sprintf("%v cpu=%vm mem=%vMi", s.Pod, s.CPUMillis(), s.MemoryBytes()/(1024*1024))
+	if p := s.CPUPercent(); p >= 0 {
+		status += fmt.Sprintf(" cpu_limit=%.0f%%", p)
+	}
+	if p := s.MemoryPercent(); p >= 0 {
+		status += fmt.Sprintf(" mem_limit=%.0f%%", p)
+	}
+	return status
+}
+
+// MetricsHistory keeps the most recent samples for each pod
+type MetricsHistory struct {
+	mu      sync.Mutex
+	max     int
+	samples map[string][]MetricsSample
+}
+
+func NewMetricsHistory(max int) *MetricsHistory {
+	return &MetricsHistory{max: max, samples: make(map[string][]MetricsSample)}
+}
+
+func (h *MetricsHistory) Add(s MetricsSample) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	samples := h.samples[s.Pod]
+	if len(samples) > 0 && !samples[len(samples)-1].Time.Before(s.Time) {
+		// metrics-server hasn't scraped since we last asked
+		return
+	}
+	samples = append(samples, s)
+	if h.max > 0 && len(samples) > h.max {
+		samples = samples[len(samples)-h.max:]
+	}
+	h.samples[s.Pod] = samples
+}
+
+func (h *MetricsHistory) Samples(pod string) []MetricsSample {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return append([]MetricsSample(nil), h.samples[pod]...)
+}
+
+// At returns the sample covering t, a sample covers its own window and the gap back to the sample before it
+func (h *MetricsHistory) At(pod string, t time.Time) (MetricsSample, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	samples := h.samples[pod]
+	i := sort.Search(len(samples), func(i int) bool { return !samples[i].Time.Before(t) })
+	if i == len(samples) {
+		return MetricsSample{}, false
+	}
+	start := samples[i].Time.Add(-samples[i].Window)
+	if i > 0 && samples[i-1].Time.Before(start) {
+		start = samples[i-1].Time
+	}
+	if t.Before(start) {
+		return MetricsSample{}, false
+	}
+	return samples[i], true
+}
+
+func (kc *KubeClient) SetMetricsClient(metrics metricsclient.Interface) {
+	kc.metrics = metrics
+}
+
+// GetPodMetrics returns the current usage of the deployment's pods, the limits are filled in from the pods given
+func (kc *KubeClient) GetPodMetrics(ctx context.Context, deploymentName string, pods map[string]Pod) ([]MetricsSample, error) {
+	if kc.metrics == nil {
+		return nil, fmt.Errorf("no metrics client configured")
+	}
+	listOptions, err := kc.podListOptions(ctx, deploymentName)
+	if err != nil {
+		return nil, err
+	}
+	metricsList, err := kc.metrics.MetricsV1beta1().PodMetricses(kc.namespace).List(ctx, listOptions)
+	if err != nil {
+		return nil, err
+	}
+	samples := make([]MetricsSample, 0, len(metricsList.Items))
+	for _, pm := range metricsList.Items {
+		samples = append(samples, newMetricsSample(pm, pods[pm.Name]))
+	}
+	return samples, nil
+}
+
+func newMetricsSample(pm metricsv1beta1.PodMetrics, pod Pod) MetricsSample {
+	s := MetricsSample{Time: pm.Timestamp.Time, Window: pm.Window.Duration, Pod: pm.Name}
+	for _, c := range pm.Containers {
+		usage := ContainerUsage{Container: c.Name, CPUMillis: c.Usage.Cpu().MilliValue(), MemoryBytes: c.Usage.Memory().Value()}
+		limits := pod.Limits[c.Name]
+		usage.CPULimitMillis = limits.CPUMillis
+		usage.MemoryLimitBytes = limits.MemoryBytes
+		s.Containers = append(s.Containers, usage)
+	}
+	return s
+}
+
+func containerLimits(container v1.Container) ContainerLimits {
+	limits := ContainerLimits{}
+	if cpu, ok := container.Resources.Limits[v1.ResourceCPU]; ok {
+		limits.CPUMillis = cpu.MilliValue()
+	}
+	if memory, ok := container.Resources.Limits[v1.ResourceMemory]; ok {
+		limits.MemoryBytes = memory.Value()
+	}
+	return limits
+}
+
+// SampleMetrics takes one reading of every pod, recording it in the watcher's history
+func (dl *DeploymentWatcher) SampleMetrics() ([]MetricsSample, error) {
+	samples, err := dl.client.GetPodMetrics(dl.context, dl.name, dl.snapshotPods())
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range samples {
+		dl.metrics.Add(s)
+	}
+	return samples, nil
+}
+
+// maxMetricsBackoff is the longest PollMetrics waits between readings while they are failing
+const maxMetricsBackoff = 10 * time.Minute
+
+// PollMetrics samples every interval until the watcher's context is done, handing each reading to onSample.
+// While readings fail, e.g. the cluster has no metrics-server, the error is written to stderr once and
+// polling backs off
+func (dl *DeploymentWatcher) PollMetrics(interval time.Duration, onSample func(MetricsSample)) {
+	wait := interval
+	failing := false
+	for {
+		samples, err := dl.SampleMetrics()
+		if err != nil {
+			if !failing {
+				fmt.Fprintf(os.Stderr, "Error getting metrics for deployment %v, backing off: %v\n", dl.name, err)
+			}
+			failing = true
+			wait = min(2*wait, max(interval, maxMetricsBackoff))
+		} else {
+			failing = false
+			wait = interval
+		}
+		for _, s := range samples {
+			if onSample != nil {
+				onSample(s)
+			}
+		}
+		select {
+		case <-dl.context.Done():
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+func (dl *DeploymentWatcher) MetricsHistory(pod string) []MetricsSample {
+	return dl.metrics.Samples(pod)
+}
+
+// usageFilter keeps the lines logged while the pod was over the usage thresholds in the search parameters
+func (dl *DeploymentWatcher) usageFilter(searchParams SearchParameters, pod string) func(line string) bool {
+	if !hasUsageConditions(searchParams) {
+		return nil
+	}
+	return func(line string) bool {
+		t, _ := ParseLogLine(line)
+		if t.IsZero() {
+			return false
+		}
+		s, ok := dl.metrics.At(pod, t)
+		if !ok {
+			return false
+		}
+		if searchParams.MemoryAbovePercent > 0 && s.MemoryPercent() <= searchParams.MemoryAbovePercent {
+			return false
+		}
+		if searchParams.CPUAbovePercent > 0 && s.CPUPercent() <= searchParams.CPUAbovePercent {
+			return false
+		}
+		return true
+	}
+}
+
+func hasUsageConditions(searchParams SearchParameters) bool {
+	return searchParams.MemoryAbovePercent > 0 || searchParams.CPUAbovePercent > 0
+}
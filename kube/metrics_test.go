@@ -0,0 +1,90 @@
+package kube
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	metricsv1beta1 "k8s.io/metrics/pkg/apis/metrics/v1beta1"
+	metricsfake "k8s.io/metrics/pkg/client/clientset/versioned/fake"
+)
+
+func testPodMetrics(pod string, app string, at time.Time, memory string) *metricsv1beta1.PodMetrics {
+	return &metricsv1beta1.PodMetrics{
+		ObjectMeta: metav1.ObjectMeta{Name: pod, Namespace: "default", Labels: map[string]string{"app": app}},
+		Timestamp:  metav1.NewTime(at),
+		Window:     metav1.Duration{Duration: 30 * time.Second},
+		Containers: []metricsv1beta1.ContainerMetrics{{
+			Name:  "main",
+			Usage: v1.ResourceList{v1.ResourceCPU: resource.MustParse("250m"), v1.ResourceMemory: resource.MustParse(memory)},
+		}},
+	}
+}
+
+func TestSampleMetrics(t *testing.T) {
+	pod := testPod("web-1", "web", v1.PodRunning)
+	pod.Spec.Containers[0].Resources.Limits = v1.ResourceList{v1.ResourceCPU: resource.MustParse("500m"), v1.ResourceMemory: resource.MustParse("100Mi")}
+	kc := NewKubeClientFromClientset(fake.NewSimpleClientset(testDeployment("web"), pod))
+	now := time.Now().Truncate(time.Second)
+	metrics := metricsfake.NewSimpleClientset()
+	// the fake metrics client lists PodMetrics under the pods resource
+	podMetrics := metricsv1beta1.SchemeGroupVersion.WithResource("pods")
+	metrics.Tracker().Create(podMetrics, testPodMetrics("web-1", "web", now, "90Mi"), "default")
+	metrics.Tracker().Create(podMetrics, testPodMetrics("api-1", "api", now, "10Mi"), "default")
+	kc.SetMetricsClient(metrics)
+	dl := NewDeploymentWatcher("web", kc, context.Background())
+
+	samples, err := dl.SampleMetrics()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(samples) != 1 || samples[0].Pod != "web-1" {
+		t.Fatalf("expected a sample for the deployment's pod got %v", samples)
+	}
+	s := samples[0]
+	if s.CPUMillis() != 250 || s.CPUPercent() != 50 || s.MemoryPercent() != 90 {
+		t.Errorf("unexpected usage cpu=%v cpu%%=%v mem%%=%v", s.CPUMillis(), s.CPUPercent(), s.MemoryPercent())
+	}
+	if len(dl.MetricsHistory("web-1")) != 1 {
+		t.Errorf("expected the sample to be recorded")
+	}
+
+	keep := dl.usageFilter(SearchParameters{MemoryAbovePercent: 80}, "web-1")
+	inWindow := now.Add(-10*time.Second).Format(time.RFC3339Nano) + " allocating"
+	beforeWindow := now.Add(-time.Hour).Format(time.RFC3339Nano) + " allocating"
+	if !keep(inWindow) {
+		t.Errorf("expected a line logged during the sample window to match")
+	}
+	if keep(beforeWindow) || keep("no timestamp") {
+		t.Errorf("expected lines outside any sample to not match")
+	}
+	if dl.usageFilter(SearchParameters{MemoryAbovePercent: 95}, "web-1")(inWindow) {
+		t.Errorf("expected the line not to match a higher threshold")
+	}
+}
+
+func TestMetricsHistoryAt(t *testing.T) {
+	h := NewMetricsHistory(3)
+	start := time.Date(2023, 9, 1, 12, 0, 0, 0, time.UTC)
+	for i := 0; i < 5; i++ {
+		h.Add(MetricsSample{Pod: "web-1", Time: start.Add(time.Duration(i) * time.Minute), Window: 15 * time.Second})
+	}
+	h.Add(MetricsSample{Pod: "web-1", Time: start.Add(4 * time.Minute)})
+	if len(h.Samples("web-1")) != 3 {
+		t.Errorf("expected the history to be capped and duplicates dropped, got %v", len(h.Samples("web-1")))
+	}
+	s, ok := h.At("web-1", start.Add(3*time.Minute+30*time.Second))
+	if !ok || !s.Time.Equal(start.Add(4*time.Minute)) {
+		t.Errorf("expected the gap between samples to be covered by the later one got %v %v", s.Time, ok)
+	}
+	if _, ok = h.At("web-1", start.Add(time.Minute)); ok {
+		t.Errorf("expected no sample for a time before the history starts")
+	}
+	if _, ok = h.At("web-1", start.Add(5*time.Minute)); ok {
+		t.Errorf("expected no sample after the last one")
+	}
+}
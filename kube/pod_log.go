@@ -1,9 +1,14 @@
 package kube
 
 import (
-	"bufio"
 	"context"
+	"errors"
+	"fmt"
 	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"os"
+	"sync"
+	"time"
 )
 
 /*
@@ -21,8 +26,17 @@ type PodLog struct {
 	Messages <-chan string
 	messages chan string
 	PodName  string
-	client   *KubeClient
-	context  context.Context
+	// Container is the container StreamLogs follows, the pod's default container when empty
+	Container string
+	// Lines is how lines over the maximum line size are handled
+	Lines LineOptions
+	// Since makes StreamLogs carry on after this time instead of starting from the last 100 lines,
+	// e.g. when a pod that was already followed is followed again
+	Since   time.Time
+	client  *KubeClient
+	context context.Context
+	mu      sync.Mutex
+	err     error
 }
 
 func NewPodLog(name string, client *KubeClient, context context.Context, args ...int) *PodLog {
@@ -45,37 +59,71 @@ func (pl *PodLog) GetLogs(lines int64) []string {
 }
 
 func (pl *PodLog) GetLogsWithOpt(opts v1.PodLogOptions) []string {
-	logs := pl.client.GetContainerLogs(pl.context, pl.PodName, opts)
+	logs, err := pl.client.GetContainerLogs(pl.context, pl.PodName, opts)
 	logLines := make([]string, 0)
-	reader := bufio.NewScanner(logs)
-	for {
-		if !reader.Scan() {
-			break
-		}
-		line := reader.Text()
-		logLines = append(logLines, line)
+	if err != nil {
+		pl.setErr(err)
+		return logLines
+	}
+	defer logs.Close()
+	reader := newLineReader(logs, pl.Lines)
+	redactor := pl.client.Redactor()
+	for reader.Scan() {
+		logLines = append(logLines, redactor.RedactLine(reader.Text()))
 	}
+	pl.setErr(reader.Err())
 	return logLines
 }
 
+// Err is why the last read of the logs failed or ended early, nil if it read them all or was canceled
+func (pl *PodLog) Err() error {
+	pl.mu.Lock()
+	defer pl.mu.Unlock()
+	return pl.err
+}
+
+func (pl *PodLog) setErr(err error) {
+	if err == nil || errors.Is(err, context.Canceled) || pl.context.Err() != nil {
+		return
+	}
+	fmt.Fprintf(os.Stderr, "Error reading logs for pod %v: %v\n", pl.PodName, err)
+	pl.mu.Lock()
+	defer pl.mu.Unlock()
+	pl.err = err
+}
+
 func (pl *PodLog) StreamLogs() {
 	defer close(pl.messages)
-	lines := int64(100)
-	options := v1.PodLogOptions{Timestamps: true, Follow: true, TailLines: &lines}
-	logs := pl.client.GetContainerLogs(pl.context, pl.PodName, options)
+	options := v1.PodLogOptions{Timestamps: true, Follow: true, Container: pl.Container}
+	if pl.Since.IsZero() {
+		lines := int64(100)
+		options.TailLines = &lines
+	} else {
+		// since is only to the second, the lines up to the one we got to are skipped below
+		since := metav1.NewTime(pl.Since)
+		options.SinceTime = &since
+	}
+	logs, err := pl.client.GetContainerLogs(pl.context, pl.PodName, options)
+	if err != nil {
+		pl.setErr(err)
+		return
+	}
 	defer logs.Close()
-	reader := bufio.NewScanner(logs)
-	var line string
-
-	for {
-		for reader.Scan() {
-			line = reader.Text()
-			select {
-			case <-pl.context.Done():
-				return
-			case pl.messages <- line:
+	reader := newLineReader(logs, pl.Lines)
+	redactor := pl.client.Redactor()
+	skipping := !pl.Since.IsZero()
+	for reader.Scan() {
+		if skipping {
+			if t, _ := ParseLogLine(reader.Text()); !t.After(pl.Since) {
 				continue
 			}
+			skipping = false
+		}
+		select {
+		case <-pl.context.Done():
+			return
+		case pl.messages <- redactor.RedactLine(reader.Text()):
 		}
 	}
+	pl.setErr(reader.Err())
 }
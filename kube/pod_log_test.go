@@ -2,14 +2,19 @@ package kube
 
 import (
 	"context"
+	"net/http"
+	"net/http/httptest"
 	"sync"
 	"testing"
 	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/client-go/rest"
 )
 
 func TestGetLog(t *testing.T) {
 	ctx := context.Background()
-	config, err := LoadConfig(ConfigParameters{})
+	config, err := LoadConfig(NewConfigFlags("", ""))
 	if err != nil {
 		t.Error(err)
 	}
@@ -29,7 +34,7 @@ func TestStreamLogs(t *testing.T) {
 	defer cancel()
 
 	//ctx := context.Background()
-	config, err := LoadConfig(ConfigParameters{})
+	config, err := LoadConfig(NewConfigFlags("", ""))
 	if err != nil {
 		t.Error(err)
 	}
@@ -52,3 +57,23 @@ func TestStreamLogs(t *testing.T) {
 		t.Errorf("Expected there to be 10 log lines waiting, found %v", len(pl.Messages))
 	}
 }
+
+func TestStreamLogsReportsOpenErrors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte(`{"kind":"Status","apiVersion":"v1","status":"Failure","reason":"Forbidden","code":403}`))
+	}))
+	defer server.Close()
+	kc := NewKubeClient(&rest.Config{Host: server.URL})
+
+	pl := NewPodLog("web-1", kc, context.Background())
+	pl.StreamLogs()
+	if _, open := <-pl.Messages; open || !apierrors.IsForbidden(pl.Err()) {
+		t.Errorf("expected the stream to end with the forbidden error got %v", pl.Err())
+	}
+	pl = NewPodLog("web-1", kc, context.Background())
+	if logs := pl.GetLogs(10); len(logs) != 0 || !apierrors.IsForbidden(pl.Err()) {
+		t.Errorf("expected no logs and the forbidden error got %v %v", logs, pl.Err())
+	}
+}
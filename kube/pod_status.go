@@ -0,0 +1,183 @@
+package kube
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+const revisionAnnotation = "deployment.kubernetes.io/revision"
+
+type ContainerStatus struct {
+	Name         string `json:"name"`
+	Ready        bool   `json:"ready"`
+	RestartCount int32  `json:"restart_count"`
+	State        string `json:"state"`
+	// why the container last stopped, empty if it never has
+	LastTerminationReason string    `json:"last_termination_reason,omitempty"`
+	LastExitCode          int32     `json:"last_exit_code,omitempty"`
+	LastTerminatedAt      time.Time `json:"last_terminated_at,omitempty"`
+}
+
+func newContainerStatuses(pod *v1.Pod) []ContainerStatus {
+	statuses := make([]ContainerStatus, 0, len(pod.Status.ContainerStatuses))
+	for _, cs := range pod.Status.ContainerStatuses {
+		status := ContainerStatus{Name: cs.Name, Ready: cs.Ready, RestartCount: cs.RestartCount, State: containerState(cs.State)}
+		if t := cs.LastTerminationState.Terminated; t != nil {
+			status.LastTerminationReason = t.Reason
+			status.LastExitCode = t.ExitCode
+			status.LastTerminatedAt = t.FinishedAt.Time
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses
+}
+
+// Ready is the number of ready containers out of all of them, like kubectl's READY column
+func (p Pod) Ready() (int, int) {
+	ready := 0
+	for _, cs := range p.Statuses {
+		if cs.Ready {
+			ready++
+		}
+	}
+	return ready, len(p.Containers)
+}
+
+func (p Pod) Restarts() int32 {
+	var restarts int32
+	for _, cs := range p.Statuses {
+		restarts += cs.RestartCount
+	}
+	return restarts
+}
+
+// Status is the phase unless a container is stuck, e.g. CrashLoopBackOff, or the pod has a reason like Evicted
+func (p Pod) Status() string {
+	if p.Reason != "" {
+		return p.Reason
+	}
+	for _, cs := range p.Statuses {
+		if reason, ok := strings.CutPrefix(cs.State, "waiting:"); ok && reason != "" {
+			return reason
+		}
+		if reason, ok := strings.CutPrefix(cs.State, "terminated:"); ok && reason != "" && p.State == string(v1.PodRunning) {
+			return reason
+		}
+	}
+	return p.State
+}
+
+// LastTermination is the container that stopped most recently, if any has
+func (p Pod) LastTermination() (ContainerStatus, bool) {
+	var last ContainerStatus
+	found := false
+	for _, cs := range p.Statuses {
+		if cs.LastTerminationReason == "" {
+			continue
+		}
+		if !found || cs.LastTerminatedAt.After(last.LastTerminatedAt) {
+			last = cs
+			found = true
+		}
+	}
+	return last, found
+}
+
+func (p Pod) Age(now time.Time) time.Duration {
+	if p.Created.IsZero() {
+		return 0
+	}
+	return now.Sub(p.Created)
+}
+
+// PodStatuses is the current state of every pod, sorted by name
+func (dl *DeploymentWatcher) PodStatuses() []Pod {
+	for _, pod := range dl.snapshotPods() {
+		if !dl.knowsRevision(pod.ReplicaSet) {
+			dl.loadReplicaSets()
+			break
+		}
+	}
+	dl.mu.Lock()
+	defer dl.mu.Unlock()
+	return dl.podStatusesLocked()
+}
+
+func (dl *DeploymentWatcher) podStatusesLocked() []Pod {
+	pods := make([]Pod, 0, len(dl.pods))
+	for _, pod := range dl.pods {
+		pod.Revision = dl.replicaSets[pod.ReplicaSet]
+		pods = append(pods, pod)
+	}
+	sort.Slice(pods, func(i, j int) bool { return pods[i].Name < pods[j].Name })
+	return pods
+}
+
+// OnPodStatus calls onChange with every pod whenever the pod watch sees one change, it only fires while
+// the watcher is streaming or watching status
+func (dl *DeploymentWatcher) OnPodStatus(onChange func([]Pod)) {
+	dl.mu.Lock()
+	defer dl.mu.Unlock()
+	dl.statusListeners = append(dl.statusListeners, onChange)
+}
+
+func (dl *DeploymentWatcher) podStatusChanged() {
+	dl.mu.Lock()
+	listeners := slices.Clone(dl.statusListeners)
+	pods := dl.podStatusesLocked()
+	dl.mu.Unlock()
+	for _, onChange := range listeners {
+		onChange(pods)
+	}
+}
+
+// WatchStatus keeps the pods up to date without streaming their logs, calling onChange with the current
+// pods and then on every change until the watcher's context is done
+func (dl *DeploymentWatcher) WatchStatus(onChange func([]Pod)) {
+	dl.OnPodStatus(onChange)
+	onChange(dl.PodStatuses())
+	dl.watchPods(func(string) {})
+}
+
+func FormatPodTable(pods []Pod, now time.Time) string {
+	var b strings.Builder
+	w := tabwriter.NewWriter(&b, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tREADY\tSTATUS\tRESTARTS\tLAST TERMINATION\tNODE\tIP\tAGE\tREVISION")
+	for _, pod := range pods {
+		ready, total := pod.Ready()
+		lastTermination := "-"
+		if cs, ok := pod.LastTermination(); ok {
+			lastTermination = fmt.Sprintf("%v %v (exit %v, %v ago)", cs.Name, cs.LastTerminationReason, cs.LastExitCode, FormatAge(now.Sub(cs.LastTerminatedAt)))
+		}
+		fmt.Fprintf(w, "%v\t%v/%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\n", pod.Name, ready, total, pod.Status(), pod.Restarts(), lastTermination,
+			orDash(pod.Node), orDash(pod.IP), FormatAge(pod.Age(now)), orDash(pod.Revision))
+	}
+	w.Flush()
+	return b.String()
+}
+
+// FormatAge rounds the way kubectl does, 45s, 12m, 5h, 3d
+func FormatAge(d time.Duration) string {
+	switch {
+	case d < time.Minute:
+		return fmt.Sprintf("%vs", int(d.Seconds()))
+	case d < time.Hour:
+		return fmt.Sprintf("%vm", int(d.Minutes()))
+	case d < 48*time.Hour:
+		return fmt.Sprintf("%vh", int(d.Hours()))
+	}
+	return fmt.Sprintf("%vd", int(d.Hours()/24))
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
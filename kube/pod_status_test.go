@@ -0,0 +1,100 @@
+package kube
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestNewPodStatus(t *testing.T) {
+	finished := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	pod := testPod("web-abc-1", "web", v1.PodRunning)
+	pod.Spec.Containers = append(pod.Spec.Containers, v1.Container{Name: "sidecar"})
+	pod.Spec.NodeName = "node-1"
+	pod.Status.PodIP = "10.0.0.5"
+	pod.CreationTimestamp = metav1.NewTime(finished.Add(-3 * time.Hour))
+	pod.Status.ContainerStatuses = []v1.ContainerStatus{
+		{
+			Name: "main", RestartCount: 3,
+			State:                v1.ContainerState{Waiting: &v1.ContainerStateWaiting{Reason: "CrashLoopBackOff"}},
+			LastTerminationState: v1.ContainerState{Terminated: &v1.ContainerStateTerminated{Reason: "OOMKilled", ExitCode: 137, FinishedAt: metav1.NewTime(finished)}},
+		},
+		{Name: "sidecar", Ready: true, State: v1.ContainerState{Running: &v1.ContainerStateRunning{}}},
+	}
+
+	p := newPod(pod)
+	if ready, total := p.Ready(); ready != 1 || total != 2 {
+		t.Errorf("expected 1/2 ready got %v/%v", ready, total)
+	}
+	if p.Restarts() != 3 {
+		t.Errorf("expected 3 restarts got %v", p.Restarts())
+	}
+	if p.Status() != "CrashLoopBackOff" {
+		t.Errorf("expected the waiting reason as status got %v", p.Status())
+	}
+	last, ok := p.LastTermination()
+	if !ok || last.LastTerminationReason != "OOMKilled" || last.LastExitCode != 137 {
+		t.Errorf("unexpected last termination %+v", last)
+	}
+	table := FormatPodTable([]Pod{p}, finished.Add(time.Minute))
+	for _, want := range []string{"1/2", "CrashLoopBackOff", "main OOMKilled (exit 137, 1m ago)", "node-1", "10.0.0.5", "3h"} {
+		if !strings.Contains(table, want) {
+			t.Errorf("expected %q in\n%v", want, table)
+		}
+	}
+}
+
+func TestWatchStatus(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	deployment := testDeployment("web")
+	deployment.UID = "web-uid"
+	rs := &appsv1.ReplicaSet{ObjectMeta: metav1.ObjectMeta{
+		Name: "web-abc", Namespace: "default", Labels: map[string]string{"app": "web"},
+		Annotations:     map[string]string{revisionAnnotation: "4"},
+		OwnerReferences: []metav1.OwnerReference{{Kind: "Deployment", Name: "web", UID: "web-uid"}},
+	}}
+	pod := testPod("web-abc-1", "web", v1.PodRunning)
+	pod.OwnerReferences = []metav1.OwnerReference{{Kind: "ReplicaSet", Name: "web-abc"}}
+	clientset := fake.NewSimpleClientset(deployment, rs, pod)
+	podWatch := watch.NewFake()
+	clientset.PrependWatchReactor("pods", k8stesting.DefaultWatchReactor(podWatch, nil))
+	dl := NewDeploymentWatcher("web", NewKubeClientFromClientset(clientset), ctx)
+
+	updates := make(chan []Pod, 10)
+	go dl.WatchStatus(func(pods []Pod) { updates <- pods })
+	next := func() []Pod {
+		select {
+		case pods := <-updates:
+			return pods
+		case <-ctx.Done():
+			t.Fatalf("timed out waiting for a status update")
+		}
+		return nil
+	}
+	pods := next()
+	if len(pods) != 1 || pods[0].Revision != "4" {
+		t.Fatalf("expected the pod at revision 4 got %+v", pods)
+	}
+
+	restarted := pod.DeepCopy()
+	restarted.Status.ContainerStatuses = []v1.ContainerStatus{{Name: "main", RestartCount: 1, Ready: true}}
+	podWatch.Modify(restarted)
+	pods = next()
+	if pods[0].Restarts() != 1 {
+		t.Errorf("expected the restart to show up got %+v", pods[0])
+	}
+
+	podWatch.Delete(restarted)
+	if pods = next(); len(pods) != 0 {
+		t.Errorf("expected the deleted pod to be gone got %+v", pods)
+	}
+}
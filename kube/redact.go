@@ -0,0 +1,260 @@
+package kube
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// How a redacted value is replaced
+const (
+	// RedactMask replaces the value with [REDACTED:<detector>]
+	RedactMask = "mask"
+	// RedactHash replaces the value with [<detector>:<hash>], so the same value can still be followed across lines
+	RedactHash = "hash"
+)
+
+// The built-in detectors
+const (
+	DetectBearer      = "bearer"
+	DetectJWT         = "jwt"
+	DetectAWSKey      = "aws-key"
+	DetectURLPassword = "url-password"
+	DetectEmail       = "email"
+	DetectCreditCard  = "credit-card"
+	DetectIP          = "ip"
+)
+
+// DetectAll turns on every built-in detector
+const DetectAll = "all"
+
+type detector struct {
+	name    string
+	pattern *regexp.Regexp
+	// valid rules out false positives the pattern can't, given the text and where the match is in it.
+	// nil when every match counts
+	valid func(s string, start int, end int) bool
+}
+
+// detectors run in this order, url passwords before emails so user:password@host isn't taken for an address.
+// When a pattern has a group only the group is the secret, e.g. the token after Bearer
+var detectors = []detector{
+	{name: DetectBearer, pattern: regexp.MustCompile(`(?i)\bbearer\s+([A-Za-z0-9\-._~+/]+=*)`)},
+	{name: DetectJWT, pattern: regexp.MustCompile(`\beyJ[A-Za-z0-9_-]+\.eyJ[A-Za-z0-9_-]+\.[A-Za-z0-9_-]*`)},
+	{name: DetectAWSKey, pattern: regexp.MustCompile(`\b(?:AKIA|ASIA)[A-Z0-9]{16}\b`)},
+	{name: DetectAWSKey, pattern: regexp.MustCompile(`(?i)aws_?secret_?access_?key["']?\s*[:=]\s*["']?([A-Za-z0-9/+=]{40})`)},
+	{name: DetectURLPassword, pattern: regexp.MustCompile(`[A-Za-z][A-Za-z0-9+.-]*://[^/\s:@]+:([^/\s@]+)@`)},
+	{name: DetectEmail, pattern: regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`)},
+	{name: DetectCreditCard, pattern: regexp.MustCompile(`\b(?:\d{13,19}|\d{4}[ -]\d{4}[ -]\d{4}[ -]\d{4}(?:[ -]\d{3})?|\d{4}[ -]\d{6}[ -]\d{5})\b`), valid: isCardNumber},
+	{name: DetectIP, pattern: regexp.MustCompile(`\b(?:(?:25[0-5]|2[0-4]\d|1\d\d|[1-9]?\d)\.){3}(?:25[0-5]|2[0-4]\d|1\d\d|[1-9]?\d)\b`)},
+	{name: DetectIP, pattern: regexp.MustCompile(`\b[0-9A-Fa-f]{0,4}(?::[0-9A-Fa-f]{0,4}){2,7}\b`), valid: isIPv6},
+}
+
+// RedactRule is a custom detector, Name labels what it replaces
+type RedactRule struct {
+	Name    string `json:"name"`
+	Pattern string `json:"pattern"`
+}
+
+/*
+RedactOptions pick what is redacted from log lines and events before anything sees them, e.g.
+
+	redaction:
+	  detectors: [all]
+	  mode: hash
+	  rules:
+	    - name: customer-id
+	      pattern: "cust_[0-9]+"
+*/
+type RedactOptions struct {
+	// Detectors are the built-in detectors to run, DetectAll for all of them
+	Detectors []string     `json:"detectors,omitempty"`
+	Rules     []RedactRule `json:"rules,omitempty"`
+	// Mode is RedactMask, the default, or RedactHash
+	Mode string `json:"mode,omitempty"`
+	// HashKey keys the hashes so they can't be reversed by hashing guesses, e.g. every IP or card number.
+	// A random key is made when it isn't set, so the hashes only match within one run
+	HashKey string `json:"hash_key,omitempty"`
+}
+
+func (o RedactOptions) Validate() error {
+	_, err := NewRedactor(o)
+	return err
+}
+
+// Enabled is whether the options redact anything
+func (o RedactOptions) Enabled() bool {
+	return len(o.Detectors) > 0 || len(o.Rules) > 0
+}
+
+// Redactor replaces secrets and personal data in text. A nil *Redactor leaves text as is
+type Redactor struct {
+	detectors []detector
+	hash      bool
+	key       []byte
+}
+
+func NewRedactor(opts RedactOptions) (*Redactor, error) {
+	r := &Redactor{key: []byte(opts.HashKey)}
+	switch opts.Mode {
+	case "", RedactMask:
+	case RedactHash:
+		r.hash = true
+		if len(r.key) == 0 {
+			r.key = make([]byte, 32)
+			if _, err := rand.Read(r.key); err != nil {
+				return nil, fmt.Errorf("unable to make a hash key: %w", err)
+			}
+		}
+	default:
+		return nil, fmt.Errorf("unknown redaction mode %q, use %v or %v", opts.Mode, RedactMask, RedactHash)
+	}
+	wanted := make(map[string]bool)
+	for _, name := range opts.Detectors {
+		if name != DetectAll && !knownDetector(name) {
+			return nil, fmt.Errorf("unknown detector %q, use %v or %v", name, strings.Join(DetectorNames(), ", "), DetectAll)
+		}
+		wanted[name] = true
+	}
+	for _, d := range detectors {
+		if wanted[DetectAll] || wanted[d.name] {
+			r.detectors = append(r.detectors, d)
+		}
+	}
+	for _, rule := range opts.Rules {
+		if rule.Name == "" {
+			return nil, fmt.Errorf("a redaction rule needs a name")
+		}
+		pattern, err := regexp.Compile(rule.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("redaction rule %v has an invalid pattern: %w", rule.Name, err)
+		}
+		r.detectors = append(r.detectors, detector{name: rule.Name, pattern: pattern})
+	}
+	return r, nil
+}
+
+// DetectorNames are the built-in detectors in the order they run
+func DetectorNames() []string {
+	var names []string
+	for _, d := range detectors {
+		if len(names) == 0 || names[len(names)-1] != d.name {
+			names = append(names, d.name)
+		}
+	}
+	return names
+}
+
+func knownDetector(name string) bool {
+	for _, d := range detectors {
+		if d.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Redact replaces everything the detectors find in s
+func (r *Redactor) Redact(s string) string {
+	if r == nil {
+		return s
+	}
+	for _, d := range r.detectors {
+		s = r.replace(d, s)
+	}
+	return s
+}
+
+// RedactLine redacts a log line, leaving the timestamp kubernetes prefixes it with alone
+func (r *Redactor) RedactLine(line string) string {
+	if r == nil {
+		return line
+	}
+	if t, rest := ParseLogLine(line); !t.IsZero() {
+		return line[:len(line)-len(rest)] + r.Redact(rest)
+	}
+	return r.Redact(line)
+}
+
+func (r *Redactor) replace(d detector, s string) string {
+	matches := d.pattern.FindAllStringSubmatchIndex(s, -1)
+	if len(matches) == 0 {
+		return s
+	}
+	var b strings.Builder
+	last := 0
+	for _, m := range matches {
+		start, end := m[0], m[1]
+		// only the group is secret when the pattern has one
+		if len(m) >= 4 && m[2] >= 0 {
+			start, end = m[2], m[3]
+		}
+		if d.valid != nil && !d.valid(s, start, end) {
+			continue
+		}
+		b.WriteString(s[last:start])
+		b.WriteString(r.replacement(d.name, s[start:end]))
+		last = end
+	}
+	b.WriteString(s[last:])
+	return b.String()
+}
+
+func (r *Redactor) replacement(name string, value string) string {
+	if !r.hash {
+		return "[REDACTED:" + name + "]"
+	}
+	mac := hmac.New(sha256.New, r.key)
+	mac.Write([]byte(value))
+	return "[" + name + ":" + hex.EncodeToString(mac.Sum(nil))[:12] + "]"
+}
+
+// isCardNumber is true for the lengths and prefixes card networks issue: Visa, Mastercard, American Express
+// and Discover, with a valid check digit, so timestamps and ids that merely have as many digits aren't taken for one
+func isCardNumber(s string, start int, end int) bool {
+	number := strings.NewReplacer(" ", "", "-", "").Replace(s[start:end])
+	prefix := func(from, to int) bool {
+		digits := len(strconv.Itoa(from))
+		n, _ := strconv.Atoi(number[:digits])
+		return n >= from && n <= to
+	}
+	var issued bool
+	switch len(number) {
+	case 13, 19:
+		issued = prefix(4, 4)
+	case 15:
+		issued = prefix(34, 34) || prefix(37, 37)
+	case 16:
+		issued = prefix(4, 4) || prefix(51, 55) || prefix(2221, 2720) || prefix(6011, 6011) || prefix(65, 65)
+	}
+	return issued && luhn(number)
+}
+
+// luhn checks a card number's check digit, ruling out most numbers that merely look like one
+func luhn(number string) bool {
+	sum := 0
+	double := false
+	for i := len(number) - 1; i >= 0; i-- {
+		d := int(number[i] - '0')
+		if double {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		double = !double
+	}
+	return sum%10 == 0
+}
+
+func isIPv6(s string, start int, end int) bool {
+	ip := net.ParseIP(s[start:end])
+	return ip != nil && strings.Contains(s[start:end], ":")
+}
@@ -0,0 +1,284 @@
+package metrics
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/farrjere/kube_watcher/kube"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+/*
+Metrics are the watcher's own metrics in the Prometheus format, for running it as a long-lived process:
+
+	kube_watcher_active_streams{workload}                 pod streams being followed
+	kube_watcher_lines_total{workload,pod,container}      lines streamed, rate() gives lines per second
+	kube_watcher_dropped_lines_total{workload,pod}        lines dropped by the overflow policy
+	kube_watcher_reconnects_total{workload}               connecting or watching failed and was retried
+	kube_watcher_api_errors_total{type}                   kubernetes api errors by kind, e.g. forbidden
+	kube_watcher_search_duration_seconds{source}          how long searches took
+	kube_watcher_alert_firings_total{rule,workload}       lines matching an alert rule
+	kube_watcher_log_matches_total{counter,workload,pod}  lines matching a LogCounter
+	kube_watcher_kafka_delivery_failures_total{workload,topic}  entries the kafka sink couldn't deliver
+	kube_watcher_sink_errors_total{workload,sink}         writes to a sink that failed, by the sink's type
+
+A nil *Metrics is valid and records nothing, so callers needn't check whether metrics are turned on
+*/
+type Metrics struct {
+	registry      *prometheus.Registry
+	lines         *prometheus.CounterVec
+	reconnects    *prometheus.CounterVec
+	apiErrors     *prometheus.CounterVec
+	searchLatency *prometheus.HistogramVec
+	alertFirings  *prometheus.CounterVec
+	logMatches    *prometheus.CounterVec
+	streams       *streamCollector
+
+	kafkaFailures *prometheus.CounterVec
+	sinkErrors    *prometheus.CounterVec
+}
+
+func New() *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		lines: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "kube_watcher_lines_total", Help: "Log lines streamed.",
+		}, []string{"workload", "pod", "container"}),
+		reconnects: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "kube_watcher_reconnects_total", Help: "Times connecting to a cluster or watching a workload failed and was retried.",
+		}, []string{"workload"}),
+		apiErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "kube_watcher_api_errors_total", Help: "Kubernetes API errors by type.",
+		}, []string{"type"}),
+		searchLatency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name: "kube_watcher_search_duration_seconds", Help: "How long log searches took.", Buckets: prometheus.ExponentialBuckets(0.05, 2, 10),
+		}, []string{"source"}),
+		alertFirings: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "kube_watcher_alert_firings_total", Help: "Log lines matching an alert rule.",
+		}, []string{"rule", "workload"}),
+		logMatches: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "kube_watcher_log_matches_total", Help: "Log lines matching a configured counter.",
+		}, []string{"counter", "workload", "pod"}),
+		streams: newStreamCollector(),
+		kafkaFailures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "kube_watcher_kafka_delivery_failures_total", Help: "Log entries the kafka sink couldn't deliver.",
+		}, []string{"workload", "topic"}),
+		sinkErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "kube_watcher_sink_errors_total", Help: "Writes to a sink that failed.",
+		}, []string{"workload", "sink"}),
+	}
+	m.registry.MustRegister(m.lines, m.reconnects, m.apiErrors, m.searchLatency, m.alertFirings, m.logMatches, m.streams, m.kafkaFailures, m.sinkErrors,
+		collectors.NewGoCollector(), collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
+	return m
+}
+
+// Handler serves the metrics, for mounting at /metrics
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// Watch reports the watcher's active streams and dropped lines as the workload's until Unwatch
+func (m *Metrics) Watch(workload string, dl *kube.DeploymentWatcher) {
+	if m == nil {
+		return
+	}
+	m.streams.add(workload, dl)
+	dl.OnWatchError(func(err error) {
+		m.Reconnect(workload)
+		m.APIError(err)
+	})
+	// pod names change with every rollout, their series are removed with them so they don't pile up
+	dl.OnPodRemoved(func(pod string) {
+		m.lines.DeletePartialMatch(prometheus.Labels{"workload": workload, "pod": pod})
+		m.logMatches.DeletePartialMatch(prometheus.Labels{"workload": workload, "pod": pod})
+	})
+}
+
+// Unwatch stops reporting the watcher, leaving alone a newer one started for the same workload.
+// The workload's per pod series are removed, a newer watcher starts them again
+func (m *Metrics) Unwatch(workload string, dl *kube.DeploymentWatcher) {
+	if m == nil {
+		return
+	}
+	if m.streams.remove(workload, dl) {
+		m.lines.DeletePartialMatch(prometheus.Labels{"workload": workload})
+		m.logMatches.DeletePartialMatch(prometheus.Labels{"workload": workload})
+	}
+}
+
+func (m *Metrics) Reconnect(workload string) {
+	if m == nil {
+		return
+	}
+	m.reconnects.WithLabelValues(workload).Inc()
+}
+
+// APIError counts an error from talking to the kubernetes api by its type
+func (m *Metrics) APIError(err error) {
+	if m == nil {
+		return
+	}
+	if t := ErrorType(err); t != "" {
+		m.apiErrors.WithLabelValues(t).Inc()
+	}
+}
+
+func (m *Metrics) ObserveSearch(source string, took time.Duration) {
+	if m == nil {
+		return
+	}
+	m.searchLatency.WithLabelValues(source).Observe(took.Seconds())
+}
+
+func (m *Metrics) AlertFired(rule string, workload string) {
+	if m == nil {
+		return
+	}
+	m.alertFirings.WithLabelValues(rule, workload).Inc()
+}
+
+func (m *Metrics) KafkaFailed(workload string, topic string, entries int) {
+	if m == nil {
+		return
+	}
+	m.kafkaFailures.WithLabelValues(workload, topic).Add(float64(entries))
+}
+
+// SinkError counts a failed write to one of the workload's sinks, sink is its type e.g. loki
+func (m *Metrics) SinkError(workload string, sink string) {
+	if m == nil {
+		return
+	}
+	m.sinkErrors.WithLabelValues(workload, sink).Inc()
+}
+
+// ErrorType names the kind of a kube error for the type label, other for any other error
+func ErrorType(err error) string {
+	switch {
+	case err == nil:
+		return ""
+	case errors.Is(err, kube.ErrNotFound):
+		return "not_found"
+	case errors.Is(err, kube.ErrForbidden):
+		return "forbidden"
+	case errors.Is(err, kube.ErrUnauthorized):
+		return "unauthorized"
+	case errors.Is(err, kube.ErrUnknownContext):
+		return "unknown_context"
+	case errors.Is(err, kube.ErrUnavailable):
+		return "unavailable"
+	}
+	return "other"
+}
+
+// LogCounter counts the lines matching Pattern per pod as kube_watcher_log_matches_total{counter=Name}
+type LogCounter struct {
+	Name    string `json:"name"`
+	Pattern string `json:"pattern"`
+	// Workloads limits the counter to the workloads with these names, all of them when empty
+	Workloads []string `json:"workloads,omitempty"`
+}
+
+func (c LogCounter) Validate() error {
+	if c.Name == "" {
+		return fmt.Errorf("a counter needs a name")
+	}
+	if _, err := regexp.Compile(c.Pattern); err != nil {
+		return fmt.Errorf("counter %v has an invalid pattern: %w", c.Name, err)
+	}
+	return nil
+}
+
+type counterSink struct {
+	m        *Metrics
+	workload string
+	names    []string
+	patterns []*regexp.Regexp
+}
+
+// Sink counts the lines streamed for the workload and the ones matching each counter,
+// the counters must have been validated
+func (m *Metrics) Sink(workload string, counters []LogCounter) kube.LogSink {
+	s := &counterSink{m: m, workload: workload}
+	for _, c := range counters {
+		s.names = append(s.names, c.Name)
+		s.patterns = append(s.patterns, regexp.MustCompile(c.Pattern))
+	}
+	return s
+}
+
+func (s *counterSink) Write(entry kube.LogEntry) error {
+	if s.m == nil || entry.IsEvent() {
+		return nil
+	}
+	s.m.lines.WithLabelValues(s.workload, entry.Pod, entry.Container).Inc()
+	for i, pattern := range s.patterns {
+		if pattern.MatchString(entry.Message) {
+			s.m.logMatches.WithLabelValues(s.names[i], s.workload, entry.Pod).Inc()
+		}
+	}
+	return nil
+}
+
+func (s *counterSink) Close() error {
+	return nil
+}
+
+// streamCollector reads the stream counts from the watchers when scraped
+type streamCollector struct {
+	mu       sync.Mutex
+	watchers map[string]*kube.DeploymentWatcher
+	active   *prometheus.Desc
+	dropped  *prometheus.Desc
+}
+
+func newStreamCollector() *streamCollector {
+	return &streamCollector{
+		watchers: make(map[string]*kube.DeploymentWatcher),
+		active:   prometheus.NewDesc("kube_watcher_active_streams", "Pod log streams being followed.", []string{"workload"}, nil),
+		dropped:  prometheus.NewDesc("kube_watcher_dropped_lines_total", "Log lines dropped by the overflow policy.", []string{"workload", "pod"}, nil),
+	}
+}
+
+func (c *streamCollector) add(workload string, dl *kube.DeploymentWatcher) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.watchers[workload] = dl
+}
+
+// remove is whether dl was the workload's watcher
+func (c *streamCollector) remove(workload string, dl *kube.DeploymentWatcher) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.watchers[workload] != dl {
+		return false
+	}
+	delete(c.watchers, workload)
+	return true
+}
+
+func (c *streamCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.active
+	ch <- c.dropped
+}
+
+func (c *streamCollector) Collect(ch chan<- prometheus.Metric) {
+	c.mu.Lock()
+	watchers := make(map[string]*kube.DeploymentWatcher, len(c.watchers))
+	for workload, dl := range c.watchers {
+		watchers[workload] = dl
+	}
+	c.mu.Unlock()
+	for workload, dl := range watchers {
+		ch <- prometheus.MustNewConstMetric(c.active, prometheus.GaugeValue, float64(dl.ActiveStreams()), workload)
+		for _, stats := range dl.StreamStats() {
+			ch <- prometheus.MustNewConstMetric(c.dropped, prometheus.CounterValue, float64(stats.Dropped), workload, stats.Pod)
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/farrjere/kube_watcher/kube"
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func scrape(t *testing.T, m *Metrics) string {
+	w := httptest.NewRecorder()
+	m.Handler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	body, _ := io.ReadAll(w.Body)
+	return string(body)
+}
+
+func TestMetrics(t *testing.T) {
+	m := New()
+	s := m.Sink("web", []LogCounter{{Name: "errors", Pattern: "level=error"}})
+	s.Write(kube.LogEntry{Pod: "web-1", Container: "main", Kind: kube.EntryLog, Message: "level=error failed"})
+	s.Write(kube.LogEntry{Pod: "web-1", Container: "main", Kind: kube.EntryLog, Message: "level=info ok"})
+	s.Write(kube.LogEntry{Pod: kube.EventsName, Kind: kube.EntryEvent, Message: "level=error BackOff"})
+	m.AlertFired("panics", "web")
+	m.KafkaFailed("web", "logs.web", 3)
+	m.SinkError("web", "loki")
+	m.Reconnect("web")
+	m.APIError(fmt.Errorf("%w: no access", kube.ErrForbidden))
+	m.APIError(errors.New("connection refused"))
+	m.ObserveSearch("api", 200*time.Millisecond)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	labels := map[string]string{"app": "web"}
+	pod := &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "web-1", Namespace: "default", Labels: labels}, Status: v1.PodStatus{Phase: v1.PodRunning}}
+	clientset := fake.NewSimpleClientset(
+		&appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default", Labels: labels},
+			Spec:       appsv1.DeploymentSpec{Selector: &metav1.LabelSelector{MatchLabels: labels}},
+		},
+		pod,
+	)
+	podWatch := watch.NewFake()
+	clientset.PrependWatchReactor("pods", k8stesting.DefaultWatchReactor(podWatch, nil))
+	dl := kube.NewDeploymentWatcher("web", kube.NewKubeClientFromClientset(clientset), ctx)
+	m.Watch("web", dl)
+
+	body := scrape(t, m)
+	for _, expected := range []string{
+		`kube_watcher_lines_total{container="main",pod="web-1",workload="web"} 2`,
+		`kube_watcher_log_matches_total{counter="errors",pod="web-1",workload="web"} 1`,
+		`kube_watcher_alert_firings_total{rule="panics",workload="web"} 1`,
+		`kube_watcher_kafka_delivery_failures_total{topic="logs.web",workload="web"} 3`,
+		`kube_watcher_sink_errors_total{sink="loki",workload="web"} 1`,
+		`kube_watcher_reconnects_total{workload="web"} 1`,
+		`kube_watcher_api_errors_total{type="forbidden"} 1`,
+		`kube_watcher_api_errors_total{type="other"} 1`,
+		`kube_watcher_search_duration_seconds_count{source="api"} 1`,
+		`kube_watcher_active_streams{workload="web"} 0`,
+	} {
+		if !strings.Contains(body, expected) {
+			t.Errorf("expected %v in the metrics", expected)
+		}
+	}
+
+	// a pod going away takes its series with it
+	go func() {
+		for range dl.Stream() {
+		}
+	}()
+	podWatch.Delete(pod)
+	deadline := time.Now().Add(5 * time.Second)
+	for body = scrape(t, m); strings.Contains(body, `lines_total{container="main",pod="web-1"`) || strings.Contains(body, `matches_total{counter="errors",pod="web-1"`) || strings.Contains(body, `dropped_lines_total{pod="web-1"`); body = scrape(t, m) {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the removed pod's series deleted")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	s.Write(kube.LogEntry{Pod: "web-2", Container: "main", Kind: kube.EntryLog, Message: "level=error failed"})
+	m.Unwatch("web", dl)
+	if body = scrape(t, m); strings.Contains(body, "kube_watcher_active_streams{") || strings.Contains(body, `lines_total{container="main",pod="web-2"`) {
+		t.Errorf("expected the watcher and its pods' series gone after unwatching")
+	}
+
+	var off *Metrics
+	off.AlertFired("panics", "web")
+	off.Watch("web", dl)
+	off.Sink("web", nil).Write(kube.LogEntry{Pod: "web-1", Kind: kube.EntryLog})
+}
@@ -0,0 +1,54 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+/*
+Notifications are how we tell people about things the watcher found
+without them having to be looking at a console, e.g.
+ 1. An alert rule matched a line
+ 2. A search returned hits
+*/
+type Notification struct {
+	Key        string    `json:"key,omitempty"`
+	Title      string    `json:"title"`
+	Text       string    `json:"text"`
+	Severity   string    `json:"severity,omitempty"`
+	Source     string    `json:"source,omitempty"`
+	Cluster    string    `json:"cluster,omitempty"`
+	Namespace  string    `json:"namespace,omitempty"`
+	Deployment string    `json:"deployment,omitempty"`
+	Pod        string    `json:"pod,omitempty"`
+	Lines      []string  `json:"lines,omitempty"`
+	Time       time.Time `json:"time"`
+}
+
+type Notifier interface {
+	Notify(ctx context.Context, n Notification) error
+}
+
+// DedupKey is the identity used to decide whether two notifications are the same,
+// if no Key was given we fall back to where it came from and what it says.
+func (n Notification) DedupKey() string {
+	if n.Key != "" {
+		return n.Key
+	}
+	return strings.Join([]string{n.Source, n.Cluster, n.Namespace, n.Deployment, n.Pod, n.Title, n.Text}, "|")
+}
+
+// New builds a notifier for one of the supported payload formats: webhook, slack or teams.
+func New(format string, url string) (Notifier, error) {
+	switch strings.ToLower(format) {
+	case "", "webhook", "json":
+		return NewWebhookNotifier(url), nil
+	case "slack":
+		return NewSlackNotifier(url), nil
+	case "teams":
+		return NewTeamsNotifier(url), nil
+	}
+	return nil, fmt.Errorf("unknown notifier format %q", format)
+}
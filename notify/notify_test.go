@@ -0,0 +1,116 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWebhookPayloads(t *testing.T) {
+	n := Notification{Title: "errors in checkout", Text: "3 matches", Severity: "error", Namespace: "shop", Deployment: "checkout", Lines: []string{"boom"}}
+	tests := []struct {
+		format string
+		check  func(map[string]any) bool
+	}{
+		{"webhook", func(m map[string]any) bool { return m["title"] == n.Title && m["deployment"] == "checkout" }},
+		{"slack", func(m map[string]any) bool { _, ok := m["text"].(string); return ok }},
+		{"teams", func(m map[string]any) bool { return m["@type"] == "MessageCard" && m["themeColor"] == "D70000" }},
+	}
+	for _, tt := range tests {
+		var got map[string]any
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("Content-Type") != "application/json" {
+				t.Errorf("%v: expected a json content type got %v", tt.format, r.Header.Get("Content-Type"))
+			}
+			json.NewDecoder(r.Body).Decode(&got)
+		}))
+		notifier, err := New(tt.format, server.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = notifier.Notify(context.Background(), n); err != nil {
+			t.Errorf("%v: unexpected error %v", tt.format, err)
+		}
+		if !tt.check(got) {
+			t.Errorf("%v: unexpected payload %v", tt.format, got)
+		}
+		server.Close()
+	}
+}
+
+func TestThrottledRetriesWithBackoff(t *testing.T) {
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+
+	opts := ThrottleOptions{MaxRetries: 3, Backoff: time.Millisecond}
+	throttled := NewThrottled(NewWebhookNotifier(server.URL), opts)
+	if err := throttled.Notify(context.Background(), Notification{Title: "retry"}); err != nil {
+		t.Errorf("expected the notification to eventually succeed, got %v", err)
+	}
+	if calls.Load() != 3 {
+		t.Errorf("expected 3 attempts got %v", calls.Load())
+	}
+}
+
+func TestThrottledDoesNotRetryClientErrors(t *testing.T) {
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer server.Close()
+
+	throttled := NewThrottled(NewWebhookNotifier(server.URL), ThrottleOptions{MaxRetries: 3, Backoff: time.Millisecond})
+	err := throttled.Notify(context.Background(), Notification{Title: "bad"})
+	var statusErr *StatusError
+	if !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected a 400 status error got %v", err)
+	}
+	if calls.Load() != 1 {
+		t.Errorf("expected a single attempt got %v", calls.Load())
+	}
+}
+
+func TestThrottledDedupAndRateLimit(t *testing.T) {
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+	}))
+	defer server.Close()
+
+	now := time.Now()
+	throttled := NewThrottled(NewWebhookNotifier(server.URL), ThrottleOptions{Rate: 0.001, Burst: 2, DedupWindow: time.Minute})
+	throttled.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	if err := throttled.Notify(ctx, Notification{Title: "a"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := throttled.Notify(ctx, Notification{Title: "a"}); !errors.Is(err, ErrDuplicate) {
+		t.Errorf("expected a duplicate got %v", err)
+	}
+	if err := throttled.Notify(ctx, Notification{Title: "b"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := throttled.Notify(ctx, Notification{Title: "c"}); !errors.Is(err, ErrRateLimited) {
+		t.Errorf("expected to be rate limited got %v", err)
+	}
+
+	now = now.Add(2 * time.Minute)
+	if err := throttled.Notify(ctx, Notification{Title: "a"}); !errors.Is(err, ErrRateLimited) {
+		t.Errorf("expected the dedup window to have passed and the limiter to kick in, got %v", err)
+	}
+	if calls.Load() != 2 {
+		t.Errorf("expected 2 notifications to be delivered got %v", calls.Load())
+	}
+}
@@ -0,0 +1,125 @@
+package notify
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+var (
+	ErrRateLimited = errors.New("notification dropped, rate limit exceeded")
+	ErrDuplicate   = errors.New("notification suppressed, already sent within the dedup window")
+)
+
+type ThrottleOptions struct {
+	// Rate is notifications per second, Burst how many can go out at once. A zero Rate disables limiting.
+	Rate  float64
+	Burst int
+	// Notifications with the same DedupKey inside this window are only sent once.
+	DedupWindow time.Duration
+	MaxRetries  int
+	Backoff     time.Duration
+	MaxBackoff  time.Duration
+}
+
+func DefaultThrottleOptions() ThrottleOptions {
+	return ThrottleOptions{Rate: 1, Burst: 5, DedupWindow: 5 * time.Minute, MaxRetries: 3, Backoff: time.Second, MaxBackoff: 30 * time.Second}
+}
+
+// Throttled wraps a notifier with rate limiting, deduplication and retries so a noisy
+// alert can't flood a channel or get us blocked by the webhook provider.
+type Throttled struct {
+	notifier Notifier
+	opts     ThrottleOptions
+	limiter  *rate.Limiter
+	mu       sync.Mutex
+	sent     map[string]time.Time
+	now      func() time.Time
+}
+
+func NewThrottled(notifier Notifier, opts ThrottleOptions) *Throttled {
+	t := Throttled{notifier: notifier, opts: opts, sent: make(map[string]time.Time), now: time.Now}
+	if opts.Rate > 0 {
+		burst := opts.Burst
+		if burst < 1 {
+			burst = 1
+		}
+		t.limiter = rate.NewLimiter(rate.Limit(opts.Rate), burst)
+	}
+	return &t
+}
+
+func (t *Throttled) Notify(ctx context.Context, n Notification) error {
+	key := n.DedupKey()
+	if !t.claim(key) {
+		return ErrDuplicate
+	}
+	if t.limiter != nil && !t.limiter.Allow() {
+		t.release(key)
+		return ErrRateLimited
+	}
+
+	backoff := t.opts.Backoff
+	var err error
+	for attempt := 0; ; attempt++ {
+		err = t.notifier.Notify(ctx, n)
+		if err == nil || attempt >= t.opts.MaxRetries || !retryable(err) {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			t.release(key)
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		if t.opts.MaxBackoff > 0 && backoff > t.opts.MaxBackoff {
+			backoff = t.opts.MaxBackoff
+		}
+	}
+	if err != nil {
+		t.release(key)
+	}
+	return err
+}
+
+// claim records the key as sent, returning false if it was already sent inside the window.
+func (t *Throttled) claim(key string) bool {
+	if t.opts.DedupWindow <= 0 {
+		return true
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := t.now()
+	for k, sentAt := range t.sent {
+		if now.Sub(sentAt) >= t.opts.DedupWindow {
+			delete(t.sent, k)
+		}
+	}
+	if _, ok := t.sent[key]; ok {
+		return false
+	}
+	t.sent[key] = now
+	return true
+}
+
+// release forgets a key that never made it out so the next attempt isn't treated as a duplicate.
+func (t *Throttled) release(key string) {
+	if t.opts.DedupWindow <= 0 {
+		return
+	}
+	t.mu.Lock()
+	delete(t.sent, key)
+	t.mu.Unlock()
+}
+
+func retryable(err error) bool {
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.Retryable()
+	}
+	return !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded)
+}
@@ -0,0 +1,132 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+type StatusError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("webhook returned %v: %v", e.StatusCode, e.Body)
+}
+
+// Retryable is true for throttling and server side failures, anything else will fail the same way again.
+func (e *StatusError) Retryable() bool {
+	return e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= 500
+}
+
+type WebhookNotifier struct {
+	URL     string
+	Client  *http.Client
+	Headers map[string]string
+	payload func(Notification) any
+}
+
+// NewWebhookNotifier posts the notification as is, as JSON.
+func NewWebhookNotifier(url string) *WebhookNotifier {
+	return newWebhookNotifier(url, func(n Notification) any { return n })
+}
+
+// NewSlackNotifier posts an incoming webhook message that Slack (and compatible chats) understand.
+func NewSlackNotifier(url string) *WebhookNotifier {
+	return newWebhookNotifier(url, slackPayload)
+}
+
+// NewTeamsNotifier posts a MessageCard for a Teams incoming webhook connector.
+func NewTeamsNotifier(url string) *WebhookNotifier {
+	return newWebhookNotifier(url, teamsPayload)
+}
+
+func newWebhookNotifier(url string, payload func(Notification) any) *WebhookNotifier {
+	return &WebhookNotifier{URL: url, Client: &http.Client{Timeout: 10 * time.Second}, payload: payload}
+}
+
+func (w *WebhookNotifier) Notify(ctx context.Context, n Notification) error {
+	if n.Time.IsZero() {
+		n.Time = time.Now()
+	}
+	body, err := json.Marshal(w.payload(n))
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range w.Headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := w.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return &StatusError{StatusCode: resp.StatusCode, Body: string(respBody)}
+	}
+	return nil
+}
+
+func where(n Notification) string {
+	parts := make([]string, 0, 4)
+	for _, p := range []string{n.Cluster, n.Namespace, n.Deployment, n.Pod} {
+		if p != "" {
+			parts = append(parts, p)
+		}
+	}
+	return strings.Join(parts, "/")
+}
+
+func slackPayload(n Notification) any {
+	text := "*" + n.Title + "*"
+	if w := where(n); w != "" {
+		text += " (" + w + ")"
+	}
+	if n.Text != "" {
+		text += "\n" + n.Text
+	}
+	if len(n.Lines) > 0 {
+		text += "\n```\n" + strings.Join(n.Lines, "\n") + "\n```"
+	}
+	return map[string]any{"text": text}
+}
+
+func teamsPayload(n Notification) any {
+	color := "0076D7"
+	switch strings.ToLower(n.Severity) {
+	case "critical", "error":
+		color = "D70000"
+	case "warning", "warn":
+		color = "FFA500"
+	}
+	facts := make([]map[string]string, 0)
+	for _, f := range [][2]string{{"Cluster", n.Cluster}, {"Namespace", n.Namespace}, {"Deployment", n.Deployment}, {"Pod", n.Pod}, {"Severity", n.Severity}} {
+		if f[1] != "" {
+			facts = append(facts, map[string]string{"name": f[0], "value": f[1]})
+		}
+	}
+	section := map[string]any{"activityTitle": n.Title, "text": n.Text, "facts": facts}
+	if len(n.Lines) > 0 {
+		section["text"] = n.Text + "\n\n" + "<pre>" + strings.Join(n.Lines, "\n") + "</pre>"
+	}
+	return map[string]any{
+		"@type":      "MessageCard",
+		"@context":   "https://schema.org/extensions",
+		"summary":    n.Title,
+		"themeColor": color,
+		"title":      n.Title,
+		"sections":   []any{section},
+	}
+}
@@ -0,0 +1,144 @@
+package sink
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"slices"
+	"sync"
+
+	"github.com/farrjere/kube_watcher/kube"
+	"github.com/fatih/color"
+)
+
+// Color schemes for the console sink
+const (
+	// ColorsRandom picks one of the 256 colors for each pod
+	ColorsRandom = "random"
+	// ColorsBasic cycles through the 8 standard colors, for terminals without 256 color support
+	ColorsBasic = "basic"
+	// ColorsNone turns color off
+	ColorsNone = "none"
+)
+
+func ValidColorScheme(scheme string) bool {
+	switch scheme {
+	case "", ColorsRandom, ColorsBasic, ColorsNone:
+		return true
+	}
+	return false
+}
+
+// NewConsole is the console sink for the color scheme
+func NewConsole(out io.Writer, scheme string) kube.LogSink {
+	if scheme == ColorsNone {
+		return NewPlainConsole(out)
+	}
+	c := NewColorConsole(out)
+	c.scheme = scheme
+	return c
+}
+
+// ColorConsole prints each pod's lines in its own randomly picked 256 color
+type ColorConsole struct {
+	out          io.Writer
+	mu           sync.Mutex
+	scheme       string
+	logColors    map[string]*color.Color
+	ignoreColors []int
+}
+
+var (
+	eventColor   = color.New(color.Bold, color.FgCyan)
+	warningColor = color.New(color.Bold, color.FgYellow)
+	// cyan and yellow are left out so pods don't look like events
+	basicColors = []color.Attribute{color.FgGreen, color.FgBlue, color.FgMagenta, color.FgRed, color.FgHiGreen, color.FgHiBlue, color.FgHiMagenta, color.FgHiRed}
+)
+
+func NewColorConsole(out io.Writer) *ColorConsole {
+	return &ColorConsole{out: out, scheme: ColorsRandom, logColors: make(map[string]*color.Color), ignoreColors: []int{0, 15, 16, 231}}
+}
+
+func (c *ColorConsole) Write(entry kube.LogEntry) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if entry.IsEvent() {
+		logColor := eventColor
+		if entry.Level == "warning" {
+			logColor = warningColor
+		}
+		_, err := logColor.Fprintln(c.out, entry.Pod, "EVENT", entry.Line())
+		return err
+	}
+	logColor, ok := c.logColors[entry.Pod]
+	if !ok {
+		logColor = c.nextColor()
+		c.logColors[entry.Pod] = logColor
+	}
+	_, err := logColor.Fprintln(c.out, entry.Pod, entry.Line())
+	return err
+}
+
+func (c *ColorConsole) nextColor() *color.Color {
+	if c.scheme == ColorsBasic {
+		return color.New(basicColors[len(c.logColors)%len(basicColors)])
+	}
+	i := rand.Intn(231)
+	// once every color is taken we have to start sharing
+	for len(c.ignoreColors) < 231 && slices.Contains(c.ignoreColors, i) {
+		i = rand.Intn(231)
+	}
+	c.ignoreColors = append(c.ignoreColors, i)
+	return color.New(color.Attribute(38), color.Attribute(5), color.Attribute(i))
+}
+
+func (c *ColorConsole) Close() error {
+	return nil
+}
+
+// PlainConsole prints "pod line" with no escape codes, for piping into other tools
+type PlainConsole struct {
+	out io.Writer
+	mu  sync.Mutex
+}
+
+func NewPlainConsole(out io.Writer) *PlainConsole {
+	return &PlainConsole{out: out}
+}
+
+func (c *PlainConsole) Write(entry kube.LogEntry) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var err error
+	if entry.IsEvent() {
+		_, err = fmt.Fprintln(c.out, entry.Pod, "EVENT", entry.Line())
+	} else {
+		_, err = fmt.Fprintln(c.out, entry.Pod, entry.Line())
+	}
+	return err
+}
+
+func (c *PlainConsole) Close() error {
+	return nil
+}
+
+// JSONLines writes one JSON object per entry
+type JSONLines struct {
+	encoder *json.Encoder
+	mu      sync.Mutex
+}
+
+func NewJSONLines(out io.Writer) *JSONLines {
+	return &JSONLines{encoder: json.NewEncoder(out)}
+}
+
+func (j *JSONLines) Write(entry kube.LogEntry) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.encoder.Encode(entry)
+}
+
+func (j *JSONLines) Close() error {
+	return nil
+}
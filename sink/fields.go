@@ -0,0 +1,64 @@
+package sink
+
+import (
+	"encoding/json"
+	"regexp"
+	"strings"
+
+	"github.com/farrjere/kube_watcher/kube"
+)
+
+// jsonFields are the fields of a structured JSON log line, nil for any other line
+func jsonFields(message string) map[string]any {
+	trimmed := strings.TrimSpace(message)
+	if !strings.HasPrefix(trimmed, "{") {
+		return nil
+	}
+	fields := make(map[string]any)
+	if json.Unmarshal([]byte(trimmed), &fields) != nil {
+		return nil
+	}
+	return fields
+}
+
+// stringField is the first of the named fields holding a string
+func stringField(fields map[string]any, names ...string) string {
+	for _, name := range names {
+		if value, ok := fields[name].(string); ok && value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+var (
+	// logfmt, e.g. level=error or lvl="warn"
+	logfmtLevel = regexp.MustCompile(`(?i)(?:^|\s)(?:level|lvl|severity)="?([a-z]+)`)
+	// klog, e.g. E1019 12:00:00.000000 1 main.go:10] failed
+	klogLevel = regexp.MustCompile(`^([IWEF])\d{4} \d{2}:\d{2}:\d{2}`)
+	// a level leading the line, possibly after the app's own timestamp, e.g. ERROR failed or 2023-09-01 12:00:00 [WARN] slow
+	prefixLevel = regexp.MustCompile(`(?i)^(?:[\d\-/T:.,+Z]+\s+){0,2}\[?(trace|debug|info|notice|warn|warning|error|err|fatal|panic|critical|crit)\]?(?:[\s:]|$)`)
+)
+
+var klogLevels = map[string]string{"I": "info", "W": "warning", "E": "error", "F": "fatal"}
+
+// entryLevel is the entry's level or else the one its line gives, from a JSON field, logfmt, a klog header or
+// a leading level, lowercased
+func entryLevel(entry kube.LogEntry, fields map[string]any) string {
+	if entry.Level != "" {
+		return strings.ToLower(entry.Level)
+	}
+	if fields != nil {
+		return strings.ToLower(stringField(fields, "level", "severity", "lvl"))
+	}
+	if m := klogLevel.FindStringSubmatch(entry.Message); m != nil {
+		return klogLevels[m[1]]
+	}
+	if m := logfmtLevel.FindStringSubmatch(entry.Message); m != nil {
+		return strings.ToLower(m[1])
+	}
+	if m := prefixLevel.FindStringSubmatch(entry.Message); m != nil {
+		return strings.ToLower(m[1])
+	}
+	return ""
+}
@@ -0,0 +1,396 @@
+package sink
+
+import (
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/farrjere/kube_watcher/kube"
+)
+
+// CombinedFile appends every pod's lines to one file as "pod line"
+type CombinedFile struct {
+	f  *os.File
+	w  *bufio.Writer
+	mu sync.Mutex
+}
+
+func NewCombinedFile(path string) (*CombinedFile, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	return &CombinedFile{f: f, w: bufio.NewWriter(f)}, nil
+}
+
+func (c *CombinedFile) Write(entry kube.LogEntry) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, err := c.w.WriteString(entry.Pod + " " + entry.Line() + "\n")
+	return err
+}
+
+func (c *CombinedFile) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	err := c.w.Flush()
+	if closeErr := c.f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+type RotateOptions struct {
+	// MaxSize rotates a pod's file once it would grow past this many bytes
+	MaxSize int64
+	// Interval rotates a pod's file once it has been open this long
+	Interval time.Duration
+	// MaxBackups is how many rotated segments to keep per pod, 0 keeps them all
+	MaxBackups int
+	// Compress gzips segments as they are rotated
+	Compress bool
+	// MaxTotalSize caps everything in the directory, oldest segments are removed first
+	MaxTotalSize int64
+	// MaxAge removes segments last written longer ago than this
+	MaxAge time.Duration
+
+	// FlushInterval is how often buffered lines are written out, 1s if not set
+	FlushInterval time.Duration
+	// CleanupInterval is how often the retention limits are enforced besides on rotation, 1m if not set
+	CleanupInterval time.Duration
+	// IdleTimeout closes a pod's file once nothing has been written to it for this long, e.g. the pod is gone.
+	// It is opened again if the pod writes after all, 10m if not set
+	IdleTimeout time.Duration
+}
+
+// PodFiles appends each pod's lines to <dir>/<pod>.log. Once the file is due for rotation it is
+// renamed to <pod>.<timestamp>.log (and gzipped if asked to) and a fresh <pod>.log is started.
+// Lines are flushed and the retention limits enforced in the background, so an idle capture keeps up too
+type PodFiles struct {
+	Dir     string
+	opts    RotateOptions
+	mu      sync.Mutex
+	files   map[string]*podFile
+	now     func() time.Time
+	err     error
+	done    chan struct{}
+	stopped chan struct{}
+}
+
+type podFile struct {
+	f       *os.File
+	w       *bufio.Writer
+	size    int64
+	opened  time.Time
+	written time.Time
+}
+
+var segmentPattern = regexp.MustCompile(`^(.+)\.(\d{8}T\d{6}\.\d{3})(-\d+)?\.log(\.gz)?$`)
+
+const segmentTimeFormat = "20060102T150405.000"
+
+func NewPodFiles(dir string, opts RotateOptions) (*PodFiles, error) {
+	err := os.MkdirAll(dir, 0o755)
+	if err != nil {
+		return nil, err
+	}
+	if opts.FlushInterval <= 0 {
+		opts.FlushInterval = time.Second
+	}
+	if opts.CleanupInterval <= 0 {
+		opts.CleanupInterval = time.Minute
+	}
+	if opts.IdleTimeout <= 0 {
+		opts.IdleTimeout = 10 * time.Minute
+	}
+	p := PodFiles{
+		Dir:     dir,
+		opts:    opts,
+		files:   make(map[string]*podFile),
+		now:     time.Now,
+		done:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	if err = p.cleanup(); err != nil {
+		return nil, err
+	}
+	go p.maintain()
+	return &p, nil
+}
+
+func (p *PodFiles) Write(entry kube.LogEntry) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	// report failures from the background once so the caller knows
+	if err := p.err; err != nil {
+		p.err = nil
+		return err
+	}
+	line := entry.Line() + "\n"
+	pf, err := p.open(entry.Pod)
+	if err != nil {
+		return err
+	}
+	if p.due(pf, int64(len(line))) {
+		if pf, err = p.rotate(entry.Pod); err != nil {
+			return err
+		}
+	}
+	n, err := pf.w.WriteString(line)
+	pf.size += int64(n)
+	pf.written = p.now()
+	return err
+}
+
+func (p *PodFiles) maintain() {
+	defer close(p.stopped)
+	flush := time.NewTicker(p.opts.FlushInterval)
+	defer flush.Stop()
+	cleanup := time.NewTicker(p.opts.CleanupInterval)
+	defer cleanup.Stop()
+	for {
+		var err error
+		select {
+		case <-p.done:
+			return
+		case <-flush.C:
+			p.mu.Lock()
+			for _, pf := range p.files {
+				if flushErr := pf.w.Flush(); err == nil {
+					err = flushErr
+				}
+			}
+		case <-cleanup.C:
+			p.mu.Lock()
+			err = p.closeIdle()
+			if cleanupErr := p.cleanup(); err == nil {
+				err = cleanupErr
+			}
+		}
+		if err != nil && p.err == nil {
+			p.err = err
+		}
+		p.mu.Unlock()
+	}
+}
+
+// closeIdle closes the files of pods that stopped writing, so the pods of old rollouts don't keep them open
+func (p *PodFiles) closeIdle() error {
+	var err error
+	for pod, pf := range p.files {
+		if p.now().Sub(pf.written) >= p.opts.IdleTimeout {
+			if closeErr := p.closeFile(pod); err == nil {
+				err = closeErr
+			}
+		}
+	}
+	return err
+}
+
+func (p *PodFiles) due(pf *podFile, incoming int64) bool {
+	if pf.size == 0 {
+		return false
+	}
+	if p.opts.MaxSize > 0 && pf.size+incoming > p.opts.MaxSize {
+		return true
+	}
+	return p.opts.Interval > 0 && p.now().Sub(pf.opened) >= p.opts.Interval
+}
+
+func (p *PodFiles) path(pod string) string {
+	return filepath.Join(p.Dir, pod+".log")
+}
+
+func (p *PodFiles) open(pod string) (*podFile, error) {
+	pf, ok := p.files[pod]
+	if ok {
+		return pf, nil
+	}
+	f, err := os.OpenFile(p.path(pod), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	pf = &podFile{f: f, w: bufio.NewWriter(f), size: info.Size(), opened: p.now(), written: p.now()}
+	p.files[pod] = pf
+	return pf, nil
+}
+
+func (p *PodFiles) rotate(pod string) (*podFile, error) {
+	if err := p.closeFile(pod); err != nil {
+		return nil, err
+	}
+	segment := p.segmentPath(pod)
+	if err := os.Rename(p.path(pod), segment); err != nil {
+		return nil, err
+	}
+	if p.opts.Compress {
+		if err := gzipFile(segment); err != nil {
+			return nil, err
+		}
+	}
+	if err := p.cleanup(); err != nil {
+		return nil, err
+	}
+	return p.open(pod)
+}
+
+func (p *PodFiles) segmentPath(pod string) string {
+	base := filepath.Join(p.Dir, pod+"."+p.now().Format(segmentTimeFormat))
+	segment := base + ".log"
+	for i := 1; exists(segment) || exists(segment+".gz"); i++ {
+		segment = fmt.Sprintf("%v-%v.log", base, i)
+	}
+	return segment
+}
+
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+func gzipFile(path string) error {
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(path + ".gz")
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(out)
+	_, err = io.Copy(zw, in)
+	if closeErr := zw.Close(); err == nil {
+		err = closeErr
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path + ".gz")
+		return err
+	}
+	return os.Remove(path)
+}
+
+type segment struct {
+	path    string
+	pod     string
+	size    int64
+	modTime time.Time
+}
+
+// cleanup enforces the retention limits, only ever removing rotated segments never a live file
+func (p *PodFiles) cleanup() error {
+	if p.opts.MaxBackups <= 0 && p.opts.MaxAge <= 0 && p.opts.MaxTotalSize <= 0 {
+		return nil
+	}
+	dirEntries, err := os.ReadDir(p.Dir)
+	if err != nil {
+		return err
+	}
+	segments := make([]segment, 0)
+	var total int64
+	for _, de := range dirEntries {
+		info, err := de.Info()
+		if err != nil || info.IsDir() {
+			continue
+		}
+		total += info.Size()
+		match := segmentPattern.FindStringSubmatch(de.Name())
+		if match == nil {
+			continue
+		}
+		segments = append(segments, segment{path: filepath.Join(p.Dir, de.Name()), pod: match[1], size: info.Size(), modTime: info.ModTime()})
+	}
+	// oldest first, the timestamp in the name breaks ties between segments written in the same instant
+	sort.Slice(segments, func(i, j int) bool {
+		if segments[i].modTime.Equal(segments[j].modTime) {
+			return segments[i].path < segments[j].path
+		}
+		return segments[i].modTime.Before(segments[j].modTime)
+	})
+
+	remove := make(map[string]bool)
+	if p.opts.MaxAge > 0 {
+		cutoff := p.now().Add(-p.opts.MaxAge)
+		for _, s := range segments {
+			if s.modTime.Before(cutoff) {
+				remove[s.path] = true
+			}
+		}
+	}
+	if p.opts.MaxBackups > 0 {
+		perPod := make(map[string]int)
+		for i := len(segments) - 1; i >= 0; i-- {
+			s := segments[i]
+			perPod[s.pod]++
+			if perPod[s.pod] > p.opts.MaxBackups {
+				remove[s.path] = true
+			}
+		}
+	}
+	for _, s := range segments {
+		if remove[s.path] {
+			total -= s.size
+		}
+	}
+	if p.opts.MaxTotalSize > 0 {
+		for _, s := range segments {
+			if total <= p.opts.MaxTotalSize {
+				break
+			}
+			if !remove[s.path] {
+				remove[s.path] = true
+				total -= s.size
+			}
+		}
+	}
+
+	var removeErr error
+	for path := range remove {
+		if err := os.Remove(path); err != nil && removeErr == nil {
+			removeErr = err
+		}
+	}
+	return removeErr
+}
+
+func (p *PodFiles) closeFile(pod string) error {
+	pf, ok := p.files[pod]
+	if !ok {
+		return nil
+	}
+	delete(p.files, pod)
+	err := pf.w.Flush()
+	if closeErr := pf.f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+func (p *PodFiles) Close() error {
+	close(p.done)
+	<-p.stopped
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	err := p.err
+	for pod := range p.files {
+		if closeErr := p.closeFile(pod); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
@@ -0,0 +1,176 @@
+package sink
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/farrjere/kube_watcher/kube"
+)
+
+// maxPendingBatches is how many full batches can wait for a slow endpoint before the oldest is dropped
+const maxPendingBatches = 10
+
+// defaultHTTPTimeout is how long a post can take when the Client has no timeout of its own
+const defaultHTTPTimeout = 10 * time.Second
+
+// HTTPBatcher POSTs entries as a JSON array once BatchSize entries are waiting or FlushInterval passes.
+// Batches are posted in the background so a slow endpoint doesn't hold up Write
+type HTTPBatcher struct {
+	URL           string
+	BatchSize     int
+	FlushInterval time.Duration
+	Client        *http.Client
+	mu            sync.Mutex
+	batch         []kube.LogEntry
+	pending       [][]kube.LogEntry
+	dropped       int
+	err           error
+	wake          chan struct{}
+	done          chan struct{}
+	stopped       chan struct{}
+}
+
+func NewHTTPBatcher(url string, batchSize int, flushInterval time.Duration) *HTTPBatcher {
+	if batchSize < 1 {
+		batchSize = 100
+	}
+	if flushInterval <= 0 {
+		flushInterval = 5 * time.Second
+	}
+	h := HTTPBatcher{
+		URL:           url,
+		BatchSize:     batchSize,
+		FlushInterval: flushInterval,
+		Client:        &http.Client{Timeout: defaultHTTPTimeout},
+		batch:         make([]kube.LogEntry, 0, batchSize),
+		wake:          make(chan struct{}, 1),
+		done:          make(chan struct{}),
+		stopped:       make(chan struct{}),
+	}
+	go h.send()
+	return &h
+}
+
+func (h *HTTPBatcher) Write(entry kube.LogEntry) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.batch = append(h.batch, entry)
+	if len(h.batch) >= h.BatchSize {
+		h.queue()
+		select {
+		case h.wake <- struct{}{}:
+		default:
+		}
+	}
+	// report failures from the background sends once so the caller knows lines went missing
+	err := h.err
+	if h.dropped > 0 {
+		err = errors.Join(fmt.Errorf("%v is falling behind, dropped %v log entries", h.host(), h.dropped), err)
+		h.dropped = 0
+	}
+	h.err = nil
+	return err
+}
+
+// queue hands the batch to the sender, dropping the oldest waiting batch if too many are. It expects the lock to be held
+func (h *HTTPBatcher) queue() {
+	if len(h.batch) == 0 {
+		return
+	}
+	if len(h.pending) >= maxPendingBatches {
+		h.dropped += len(h.pending[0])
+		h.pending = h.pending[1:]
+	}
+	h.pending = append(h.pending, h.batch)
+	h.batch = make([]kube.LogEntry, 0, h.BatchSize)
+}
+
+// send posts the full batches as they are queued and whatever is waiting every FlushInterval, until closed
+func (h *HTTPBatcher) send() {
+	defer close(h.stopped)
+	ticker := time.NewTicker(h.FlushInterval)
+	defer ticker.Stop()
+	for {
+		closing := false
+		select {
+		case <-h.done:
+			closing = true
+		case <-ticker.C:
+		case <-h.wake:
+		}
+		h.mu.Lock()
+		h.queue()
+		pending := h.pending
+		h.pending = nil
+		h.mu.Unlock()
+		for _, batch := range pending {
+			if err := h.post(batch); err != nil {
+				h.mu.Lock()
+				if h.err == nil {
+					h.err = err
+				}
+				h.mu.Unlock()
+			}
+		}
+		if closing {
+			return
+		}
+	}
+}
+
+func (h *HTTPBatcher) post(entries []kube.LogEntry) error {
+	body, err := json.Marshal(entries)
+	if err != nil {
+		return err
+	}
+	timeout := h.Client.Timeout
+	if timeout <= 0 {
+		timeout = defaultHTTPTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.URL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("invalid url for %v", h.host())
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := h.Client.Do(req)
+	if err != nil {
+		// the client's errors quote the whole url
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return fmt.Errorf("posting %v log entries to %v: %w", len(entries), h.host(), err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("posting %v log entries to %v returned %v", len(entries), h.host(), resp.StatusCode)
+	}
+	return nil
+}
+
+// host is the URL's host for errors, the URL itself can hold credentials in its user info or query
+func (h *HTTPBatcher) host() string {
+	u, err := url.Parse(h.URL)
+	if err != nil || u.Host == "" {
+		return "the http sink"
+	}
+	return u.Host
+}
+
+// Close posts what is waiting
+func (h *HTTPBatcher) Close() error {
+	close(h.done)
+	<-h.stopped
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.err
+}
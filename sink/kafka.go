@@ -0,0 +1,314 @@
+package sink
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/farrjere/kube_watcher/kube"
+	"github.com/segmentio/kafka-go"
+)
+
+// DefaultTopic is the topic entries are produced to when no template is given
+const DefaultTopic = "kube-watcher"
+
+// KafkaMessage is a record to produce, its Key picks the partition
+type KafkaMessage struct {
+	Topic string
+	Key   []byte
+	Value []byte
+}
+
+// Producer sends batches of messages to Kafka, retrying as configured.
+// When only some of the messages fail it returns ProduceErrors
+type Producer interface {
+	Produce(ctx context.Context, messages []KafkaMessage) error
+	Close() error
+}
+
+// ProduceErrors has the error of each message of a batch, nil for the ones delivered
+type ProduceErrors []error
+
+func (e ProduceErrors) Error() string {
+	failed := 0
+	var first error
+	for _, err := range e {
+		if err != nil {
+			failed++
+			if first == nil {
+				first = err
+			}
+		}
+	}
+	return fmt.Sprintf("%v of %v messages failed: %v", failed, len(e), first)
+}
+
+type KafkaOptions struct {
+	Brokers []string
+	// Topic is a template naming an entry's topic, {cluster}, {namespace}, {deployment}, {pod} and {container}
+	// are filled in from the entry
+	Topic string
+	// a batch is produced once it has BatchSize entries or FlushInterval passes
+	BatchSize     int
+	FlushInterval time.Duration
+	// Compression is none, gzip, snappy, lz4 or zstd
+	Compression string
+	// Acks is which replicas must have a batch before it is delivered: none, one (the leader) or all
+	Acks  string
+	Retry Retry
+	// Failed is told how many entries for a topic couldn't be delivered, e.g. to count them in the metrics
+	Failed func(topic string, entries int)
+}
+
+/*
+Kafka produces every entry as a JSON message keyed by its pod, so a pod's entries go to the same partition
+and stay in order
+*/
+type Kafka struct {
+	opts     KafkaOptions
+	producer Producer
+	topic    func(kube.LogEntry) string
+	mu       sync.Mutex
+	batch    []kube.LogEntry
+	err      error
+	done     chan struct{}
+	stopped  chan struct{}
+}
+
+func NewKafka(opts KafkaOptions) (*Kafka, error) {
+	if len(opts.Brokers) == 0 || slices.Contains(opts.Brokers, "") {
+		return nil, fmt.Errorf("kafka needs a list of brokers e.g. localhost:9092,localhost:9093")
+	}
+	compression, err := kafkaCompression(opts.Compression)
+	if err != nil {
+		return nil, err
+	}
+	acks, err := kafkaAcks(opts.Acks)
+	if err != nil {
+		return nil, err
+	}
+	opts = kafkaDefaults(opts)
+	producer := &kafkaWriter{writer: &kafka.Writer{
+		Addr:     kafka.TCP(opts.Brokers...),
+		Balancer: &kafka.Hash{},
+		// the sink batches, the writer sends what it is given straight away
+		BatchSize:       opts.BatchSize,
+		BatchTimeout:    time.Millisecond,
+		Compression:     compression,
+		RequiredAcks:    acks,
+		MaxAttempts:     opts.Retry.MaxRetries + 1,
+		WriteBackoffMin: opts.Retry.MinBackoff,
+		WriteBackoffMax: opts.Retry.MaxBackoff,
+	}}
+	return NewKafkaWith(producer, opts), nil
+}
+
+// NewKafkaWith produces with the given producer, ignoring the options about connecting
+func NewKafkaWith(producer Producer, opts KafkaOptions) *Kafka {
+	opts = kafkaDefaults(opts)
+	k := Kafka{
+		opts:     opts,
+		producer: producer,
+		topic:    topicTemplate(opts.Topic),
+		done:     make(chan struct{}),
+		stopped:  make(chan struct{}),
+	}
+	go k.flushPeriodically()
+	return &k
+}
+
+func kafkaDefaults(opts KafkaOptions) KafkaOptions {
+	if opts.Topic == "" {
+		opts.Topic = DefaultTopic
+	}
+	if opts.BatchSize < 1 {
+		opts.BatchSize = 500
+	}
+	if opts.FlushInterval <= 0 {
+		opts.FlushInterval = time.Second
+	}
+	if opts.Retry == (Retry{}) {
+		opts.Retry = DefaultRetry()
+	}
+	return opts
+}
+
+func (k *Kafka) Write(entry kube.LogEntry) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.batch = append(k.batch, entry)
+	if len(k.batch) >= k.opts.BatchSize {
+		k.flush()
+	}
+	// report failures from the background flushes once so the caller knows lines went missing
+	err := k.err
+	k.err = nil
+	return err
+}
+
+func (k *Kafka) flushPeriodically() {
+	defer close(k.stopped)
+	ticker := time.NewTicker(k.opts.FlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-k.done:
+			return
+		case <-ticker.C:
+			k.mu.Lock()
+			k.flush()
+			k.mu.Unlock()
+		}
+	}
+}
+
+// flush expects the lock to be held
+func (k *Kafka) flush() {
+	if len(k.batch) == 0 {
+		return
+	}
+	err := k.produce(k.batch)
+	if err != nil && k.err == nil {
+		k.err = fmt.Errorf("producing %v log entries to kafka: %w", len(k.batch), err)
+	}
+	k.batch = nil
+}
+
+func (k *Kafka) produce(entries []kube.LogEntry) error {
+	messages := make([]KafkaMessage, len(entries))
+	for i, entry := range entries {
+		value, err := json.Marshal(entry)
+		if err != nil {
+			return err
+		}
+		messages[i] = KafkaMessage{Topic: k.topic(entry), Key: []byte(entry.Pod), Value: value}
+	}
+	err := k.producer.Produce(context.Background(), messages)
+	if err == nil || k.opts.Failed == nil {
+		return err
+	}
+	var perMessage ProduceErrors
+	partial := errors.As(err, &perMessage) && len(perMessage) == len(messages)
+	failed := make(map[string]int)
+	for i, m := range messages {
+		if !partial || perMessage[i] != nil {
+			failed[m.Topic]++
+		}
+	}
+	for topic, n := range failed {
+		k.opts.Failed(topic, n)
+	}
+	return err
+}
+
+func (k *Kafka) Close() error {
+	close(k.done)
+	<-k.stopped
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.flush()
+	return errors.Join(k.err, k.producer.Close())
+}
+
+// topicTemplate fills in the entry's fields, replacing anything kafka doesn't allow in a topic name with _
+func topicTemplate(template string) func(kube.LogEntry) string {
+	return func(e kube.LogEntry) string {
+		topic := strings.NewReplacer(
+			"{cluster}", e.Cluster,
+			"{namespace}", e.Namespace,
+			"{deployment}", e.Deployment,
+			"{pod}", e.Pod,
+			"{container}", e.Container,
+		).Replace(template)
+		topic = strings.Map(func(r rune) rune {
+			if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '.' || r == '_' || r == '-' {
+				return r
+			}
+			return '_'
+		}, topic)
+		return topic[:min(len(topic), 249)]
+	}
+}
+
+func kafkaCompression(name string) (kafka.Compression, error) {
+	switch name {
+	case "", "none":
+		return 0, nil
+	case "gzip":
+		return kafka.Gzip, nil
+	case "snappy":
+		return kafka.Snappy, nil
+	case "lz4":
+		return kafka.Lz4, nil
+	case "zstd":
+		return kafka.Zstd, nil
+	}
+	return 0, fmt.Errorf("unknown kafka compression %q, use none, gzip, snappy, lz4 or zstd", name)
+}
+
+func kafkaAcks(acks string) (kafka.RequiredAcks, error) {
+	switch acks {
+	case "", "all":
+		return kafka.RequireAll, nil
+	case "one":
+		return kafka.RequireOne, nil
+	case "none":
+		return kafka.RequireNone, nil
+	}
+	return 0, fmt.Errorf("unknown kafka acks %q, use none, one or all", acks)
+}
+
+// kafkaWriter produces with kafka-go
+type kafkaWriter struct {
+	writer *kafka.Writer
+}
+
+func (w *kafkaWriter) Produce(ctx context.Context, messages []KafkaMessage) error {
+	batch := make([]kafka.Message, len(messages))
+	for i, m := range messages {
+		batch[i] = kafka.Message{Topic: m.Topic, Key: m.Key, Value: m.Value}
+	}
+	err := w.writer.WriteMessages(ctx, batch...)
+	var writeErrors kafka.WriteErrors
+	if errors.As(err, &writeErrors) {
+		return ProduceErrors(writeErrors)
+	}
+	return err
+}
+
+func (w *kafkaWriter) Close() error {
+	return w.writer.Close()
+}
+
+// parseKafka reads kafka:<broker>[,<broker>...][?topic=&batch=&flush=&compression=&acks=&retries=]
+func parseKafka(arg string, failed func(topic string, entries int)) (*Kafka, error) {
+	brokers, rawQuery, _ := strings.Cut(arg, "?")
+	query, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return nil, fmt.Errorf("invalid kafka options %q: %w", rawQuery, err)
+	}
+	opts := KafkaOptions{
+		Brokers:     strings.Split(brokers, ","),
+		Topic:       query.Get("topic"),
+		Compression: query.Get("compression"),
+		Acks:        query.Get("acks"),
+		Retry:       DefaultRetry(),
+		Failed:      failed,
+	}
+	if opts.BatchSize, opts.FlushInterval, err = batchOptions(query); err != nil {
+		return nil, fmt.Errorf("kafka: %w", err)
+	}
+	if retries := query.Get("retries"); retries != "" {
+		if opts.Retry.MaxRetries, err = strconv.Atoi(retries); err != nil {
+			return nil, fmt.Errorf("kafka: invalid retries %q", retries)
+		}
+	}
+	return NewKafka(opts)
+}
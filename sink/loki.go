@@ -0,0 +1,290 @@
+package sink
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/farrjere/kube_watcher/kube"
+	"github.com/golang/snappy"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// The bodies Loki's push api accepts
+const (
+	// LokiProtobuf is a snappy compressed logproto.PushRequest, what Promtail sends
+	LokiProtobuf = "protobuf"
+	LokiJSON     = "json"
+)
+
+const lokiPushPath = "/loki/api/v1/push"
+
+type LokiOptions struct {
+	// URL is the push endpoint, /loki/api/v1/push is added when it has no path
+	URL string
+	// Tenant is sent as X-Scope-OrgID for multi-tenant Lokis
+	Tenant string
+	// Format is LokiProtobuf or LokiJSON, protobuf if not set
+	Format string
+	// a batch is pushed once it has BatchSize entries or BatchBytes of lines, or FlushInterval passes
+	BatchSize     int
+	BatchBytes    int
+	FlushInterval time.Duration
+	Retry         Retry
+}
+
+/*
+Loki pushes entries to Grafana Loki, labelled with job="kube_watcher" and the entry's cluster, namespace,
+deployment, pod and container, so kube_watcher can ship logs where Promtail can't be installed.
+Writes wait while a batch is being retried, slowing the streams down rather than dropping lines
+*/
+type Loki struct {
+	opts    LokiOptions
+	client  *http.Client
+	mu      sync.Mutex
+	batch   []kube.LogEntry
+	bytes   int
+	err     error
+	done    chan struct{}
+	stopped chan struct{}
+}
+
+func NewLoki(opts LokiOptions) (*Loki, error) {
+	u, err := url.Parse(opts.URL)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("invalid loki url %q", opts.URL)
+	}
+	if u.Path == "" || u.Path == "/" {
+		u.Path = lokiPushPath
+	}
+	opts.URL = u.String()
+	switch opts.Format {
+	case "":
+		opts.Format = LokiProtobuf
+	case LokiProtobuf, LokiJSON:
+	default:
+		return nil, fmt.Errorf("unknown loki format %q, use %v or %v", opts.Format, LokiProtobuf, LokiJSON)
+	}
+	if opts.BatchSize < 1 {
+		opts.BatchSize = 1000
+	}
+	if opts.BatchBytes < 1 {
+		opts.BatchBytes = 1024 * 1024
+	}
+	if opts.FlushInterval <= 0 {
+		opts.FlushInterval = time.Second
+	}
+	if opts.Retry == (Retry{}) {
+		opts.Retry = DefaultRetry()
+	}
+	l := Loki{
+		opts:    opts,
+		client:  &http.Client{Timeout: 10 * time.Second},
+		done:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	go l.flushPeriodically()
+	return &l, nil
+}
+
+func (l *Loki) Write(entry kube.LogEntry) error {
+	if entry.Time.IsZero() {
+		entry.Time = time.Now()
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.batch = append(l.batch, entry)
+	l.bytes += len(entry.Message)
+	if len(l.batch) >= l.opts.BatchSize || l.bytes >= l.opts.BatchBytes {
+		l.flush()
+	}
+	// report failures from the background flushes once so the caller knows lines went missing
+	err := l.err
+	l.err = nil
+	return err
+}
+
+func (l *Loki) flushPeriodically() {
+	defer close(l.stopped)
+	ticker := time.NewTicker(l.opts.FlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-l.done:
+			return
+		case <-ticker.C:
+			l.mu.Lock()
+			l.flush()
+			l.mu.Unlock()
+		}
+	}
+}
+
+// flush expects the lock to be held
+func (l *Loki) flush() {
+	if len(l.batch) == 0 {
+		return
+	}
+	err := l.push(l.batch)
+	if err != nil && l.err == nil {
+		l.err = fmt.Errorf("pushing %v log entries to loki: %w", len(l.batch), err)
+	}
+	l.batch = nil
+	l.bytes = 0
+}
+
+func (l *Loki) push(entries []kube.LogEntry) error {
+	header := http.Header{}
+	if l.opts.Tenant != "" {
+		header.Set("X-Scope-OrgID", l.opts.Tenant)
+	}
+	streams := lokiStreams(entries)
+	var body []byte
+	var err error
+	if l.opts.Format == LokiJSON {
+		header.Set("Content-Type", "application/json")
+		body, err = lokiJSON(streams)
+	} else {
+		header.Set("Content-Type", "application/x-protobuf")
+		header.Set("Content-Encoding", "snappy")
+		body = snappy.Encode(nil, lokiProtobuf(streams))
+	}
+	if err != nil {
+		return err
+	}
+	_, err = l.opts.Retry.post(l.client, l.opts.URL, header, body)
+	return err
+}
+
+func (l *Loki) Close() error {
+	close(l.done)
+	<-l.stopped
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.flush()
+	return l.err
+}
+
+type lokiStream struct {
+	labels  map[string]string
+	entries []kube.LogEntry
+}
+
+// lokiStreams groups the entries by their labels, keeping them in order within a stream
+func lokiStreams(entries []kube.LogEntry) []*lokiStream {
+	var streams []*lokiStream
+	byLabels := make(map[string]*lokiStream)
+	for _, e := range entries {
+		labels := lokiLabels(e)
+		key := formatLabels(labels)
+		stream, ok := byLabels[key]
+		if !ok {
+			stream = &lokiStream{labels: labels}
+			byLabels[key] = stream
+			streams = append(streams, stream)
+		}
+		stream.entries = append(stream.entries, e)
+	}
+	return streams
+}
+
+func lokiLabels(e kube.LogEntry) map[string]string {
+	labels := map[string]string{"job": "kube_watcher"}
+	for name, value := range map[string]string{
+		"cluster":    e.Cluster,
+		"namespace":  e.Namespace,
+		"deployment": e.Deployment,
+		"pod":        e.Pod,
+		"container":  e.Container,
+	} {
+		if value != "" {
+			labels[name] = value
+		}
+	}
+	return labels
+}
+
+// formatLabels writes labels the way Loki parses them, {name="value", ...}
+func formatLabels(labels map[string]string) string {
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	pairs := make([]string, len(names))
+	for i, name := range names {
+		pairs[i] = name + "=" + strconv.Quote(labels[name])
+	}
+	return "{" + strings.Join(pairs, ", ") + "}"
+}
+
+func lokiJSON(streams []*lokiStream) ([]byte, error) {
+	type jsonStream struct {
+		Stream map[string]string `json:"stream"`
+		Values [][2]string       `json:"values"`
+	}
+	push := struct {
+		Streams []jsonStream `json:"streams"`
+	}{}
+	for _, s := range streams {
+		values := make([][2]string, len(s.entries))
+		for i, e := range s.entries {
+			values[i] = [2]string{strconv.FormatInt(e.Time.UnixNano(), 10), e.Message}
+		}
+		push.Streams = append(push.Streams, jsonStream{Stream: s.labels, Values: values})
+	}
+	return json.Marshal(push)
+}
+
+// lokiProtobuf encodes a logproto.PushRequest by hand rather than pulling in Loki for its types:
+//
+//	PushRequest   { repeated StreamAdapter streams = 1; }
+//	StreamAdapter { string labels = 1; repeated EntryAdapter entries = 2; }
+//	EntryAdapter  { google.protobuf.Timestamp timestamp = 1; string line = 2; }
+func lokiProtobuf(streams []*lokiStream) []byte {
+	var push []byte
+	for _, s := range streams {
+		var stream []byte
+		stream = protowire.AppendTag(stream, 1, protowire.BytesType)
+		stream = protowire.AppendString(stream, formatLabels(s.labels))
+		for _, e := range s.entries {
+			var ts []byte
+			ts = protowire.AppendTag(ts, 1, protowire.VarintType)
+			ts = protowire.AppendVarint(ts, uint64(e.Time.Unix()))
+			ts = protowire.AppendTag(ts, 2, protowire.VarintType)
+			ts = protowire.AppendVarint(ts, uint64(e.Time.Nanosecond()))
+
+			var entry []byte
+			entry = protowire.AppendTag(entry, 1, protowire.BytesType)
+			entry = protowire.AppendBytes(entry, ts)
+			entry = protowire.AppendTag(entry, 2, protowire.BytesType)
+			entry = protowire.AppendString(entry, e.Message)
+
+			stream = protowire.AppendTag(stream, 2, protowire.BytesType)
+			stream = protowire.AppendBytes(stream, entry)
+		}
+		push = protowire.AppendTag(push, 1, protowire.BytesType)
+		push = protowire.AppendBytes(push, stream)
+	}
+	return push
+}
+
+// parseLoki reads loki:<url>[?tenant=&format=&batch=&flush=], the options are taken off the url
+func parseLoki(arg string) (*Loki, error) {
+	u, err := url.Parse(arg)
+	if err != nil {
+		return nil, fmt.Errorf("invalid loki url %q: %w", arg, err)
+	}
+	query := takeQuery(u, "tenant", "format", "batch", "flush")
+	opts := LokiOptions{URL: u.String(), Tenant: query.Get("tenant"), Format: query.Get("format")}
+	if opts.BatchSize, opts.FlushInterval, err = batchOptions(query); err != nil {
+		return nil, fmt.Errorf("loki: %w", err)
+	}
+	return NewLoki(opts)
+}
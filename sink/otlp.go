@@ -0,0 +1,417 @@
+package sink
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/farrjere/kube_watcher/kube"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// The encodings OTLP/HTTP accepts
+const (
+	OTLPProtobuf = "protobuf"
+	OTLPJSON     = "json"
+)
+
+const otlpLogsPath = "/v1/logs"
+
+type OTLPOptions struct {
+	// URL is the collector's logs endpoint, /v1/logs is added when it has no path
+	URL string
+	// Format is OTLPProtobuf or OTLPJSON, protobuf if not set
+	Format string
+	// Headers are sent with every export, e.g. for authentication
+	Headers http.Header
+	// a batch is exported once it has BatchSize entries or FlushInterval passes
+	BatchSize     int
+	FlushInterval time.Duration
+	Retry         Retry
+}
+
+/*
+OTLP exports entries as OpenTelemetry LogRecords over OTLP/HTTP. The pod's k8s.* attributes go on the
+resource, the severity comes from the entry's level and JSON lines' trace_id and span_id link the
+record to its trace
+*/
+type OTLP struct {
+	opts    OTLPOptions
+	client  *http.Client
+	mu      sync.Mutex
+	batch   []kube.LogEntry
+	err     error
+	done    chan struct{}
+	stopped chan struct{}
+}
+
+func NewOTLP(opts OTLPOptions) (*OTLP, error) {
+	u, err := url.Parse(opts.URL)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("invalid otlp url %q", opts.URL)
+	}
+	if u.Path == "" || u.Path == "/" {
+		u.Path = otlpLogsPath
+	}
+	opts.URL = u.String()
+	switch opts.Format {
+	case "":
+		opts.Format = OTLPProtobuf
+	case OTLPProtobuf, OTLPJSON:
+	default:
+		return nil, fmt.Errorf("unknown otlp format %q, use %v or %v", opts.Format, OTLPProtobuf, OTLPJSON)
+	}
+	if opts.BatchSize < 1 {
+		opts.BatchSize = 512
+	}
+	if opts.FlushInterval <= 0 {
+		opts.FlushInterval = time.Second
+	}
+	if opts.Retry == (Retry{}) {
+		opts.Retry = DefaultRetry()
+	}
+	o := OTLP{
+		opts:    opts,
+		client:  &http.Client{Timeout: 10 * time.Second},
+		done:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	go o.flushPeriodically()
+	return &o, nil
+}
+
+func (o *OTLP) Write(entry kube.LogEntry) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.batch = append(o.batch, entry)
+	if len(o.batch) >= o.opts.BatchSize {
+		o.flush()
+	}
+	// report failures from the background flushes once so the caller knows lines went missing
+	err := o.err
+	o.err = nil
+	return err
+}
+
+func (o *OTLP) flushPeriodically() {
+	defer close(o.stopped)
+	ticker := time.NewTicker(o.opts.FlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-o.done:
+			return
+		case <-ticker.C:
+			o.mu.Lock()
+			o.flush()
+			o.mu.Unlock()
+		}
+	}
+}
+
+// flush expects the lock to be held
+func (o *OTLP) flush() {
+	if len(o.batch) == 0 {
+		return
+	}
+	err := o.export(o.batch)
+	if err != nil && o.err == nil {
+		o.err = fmt.Errorf("exporting %v log entries over otlp: %w", len(o.batch), err)
+	}
+	o.batch = nil
+}
+
+func (o *OTLP) export(entries []kube.LogEntry) error {
+	header := o.opts.Headers.Clone()
+	if header == nil {
+		header = http.Header{}
+	}
+	resources := otlpResources(entries, time.Now())
+	var body []byte
+	var err error
+	if o.opts.Format == OTLPJSON {
+		header.Set("Content-Type", "application/json")
+		body, err = otlpJSON(resources)
+	} else {
+		header.Set("Content-Type", "application/x-protobuf")
+		body = otlpProtobuf(resources)
+	}
+	if err != nil {
+		return err
+	}
+	_, err = o.opts.Retry.post(o.client, o.opts.URL, header, body)
+	return err
+}
+
+func (o *OTLP) Close() error {
+	close(o.done)
+	<-o.stopped
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.flush()
+	return o.err
+}
+
+type otlpAttribute struct {
+	key   string
+	value string
+}
+
+type otlpRecord struct {
+	time     time.Time
+	observed time.Time
+	severity int
+	level    string
+	body     string
+	traceID  []byte
+	spanID   []byte
+}
+
+type otlpResource struct {
+	attributes []otlpAttribute
+	records    []otlpRecord
+}
+
+// otlpResources groups the entries by pod and container, the resource that logged them
+func otlpResources(entries []kube.LogEntry, observed time.Time) []*otlpResource {
+	var resources []*otlpResource
+	byKey := make(map[string]*otlpResource)
+	for _, e := range entries {
+		attributes := otlpResourceAttributes(e)
+		key := fmt.Sprint(attributes)
+		resource, ok := byKey[key]
+		if !ok {
+			resource = &otlpResource{attributes: attributes}
+			byKey[key] = resource
+			resources = append(resources, resource)
+		}
+		resource.records = append(resource.records, otlpLogRecord(e, observed))
+	}
+	return resources
+}
+
+// otlpResourceAttributes follow the kubernetes semantic conventions, with the deployment as service.name
+func otlpResourceAttributes(e kube.LogEntry) []otlpAttribute {
+	var attributes []otlpAttribute
+	for _, a := range []otlpAttribute{
+		{"service.name", e.Deployment},
+		{"k8s.cluster.name", e.Cluster},
+		{"k8s.namespace.name", e.Namespace},
+		{"k8s.deployment.name", e.Deployment},
+		{"k8s.pod.name", e.Pod},
+		{"k8s.container.name", e.Container},
+	} {
+		if a.value != "" {
+			attributes = append(attributes, a)
+		}
+	}
+	return attributes
+}
+
+func otlpLogRecord(e kube.LogEntry, observed time.Time) otlpRecord {
+	fields := jsonFields(e.Message)
+	level := entryLevel(e, fields)
+	return otlpRecord{
+		time:     e.Time,
+		observed: observed,
+		severity: severityNumber(level),
+		level:    level,
+		body:     e.Message,
+		traceID:  hexID(stringField(fields, "trace_id", "traceId", "traceID", "trace.id"), 16),
+		spanID:   hexID(stringField(fields, "span_id", "spanId", "spanID", "span.id"), 8),
+	}
+}
+
+// severityNumber maps a level to the first SeverityNumber of its range, 0 (unspecified) for unknown levels
+func severityNumber(level string) int {
+	switch level {
+	case "trace":
+		return 1
+	case "debug":
+		return 5
+	case "info", "notice":
+		return 9
+	case "warn", "warning":
+		return 13
+	case "error", "err":
+		return 17
+	case "fatal", "panic", "critical", "crit":
+		return 21
+	}
+	return 0
+}
+
+// hexID decodes a trace or span id, nil unless it is size bytes and not all zeros
+func hexID(id string, size int) []byte {
+	decoded, err := hex.DecodeString(id)
+	if err != nil || len(decoded) != size || strings.Trim(id, "0") == "" {
+		return nil
+	}
+	return decoded
+}
+
+// otlpJSON is the OTLP/HTTP JSON encoding: camelCase names, 64 bit integers as strings and ids as hex
+func otlpJSON(resources []*otlpResource) ([]byte, error) {
+	type value struct {
+		StringValue string `json:"stringValue"`
+	}
+	type keyValue struct {
+		Key   string `json:"key"`
+		Value value  `json:"value"`
+	}
+	type logRecord struct {
+		TimeUnixNano         string `json:"timeUnixNano,omitempty"`
+		ObservedTimeUnixNano string `json:"observedTimeUnixNano"`
+		SeverityNumber       int    `json:"severityNumber,omitempty"`
+		SeverityText         string `json:"severityText,omitempty"`
+		Body                 value  `json:"body"`
+		TraceID              string `json:"traceId,omitempty"`
+		SpanID               string `json:"spanId,omitempty"`
+	}
+	type scopeLogs struct {
+		Scope      map[string]string `json:"scope"`
+		LogRecords []logRecord       `json:"logRecords"`
+	}
+	type resourceLogs struct {
+		Resource struct {
+			Attributes []keyValue `json:"attributes"`
+		} `json:"resource"`
+		ScopeLogs []scopeLogs `json:"scopeLogs"`
+	}
+	request := struct {
+		ResourceLogs []resourceLogs `json:"resourceLogs"`
+	}{}
+	for _, r := range resources {
+		rl := resourceLogs{}
+		for _, a := range r.attributes {
+			rl.Resource.Attributes = append(rl.Resource.Attributes, keyValue{Key: a.key, Value: value{StringValue: a.value}})
+		}
+		scope := scopeLogs{Scope: map[string]string{"name": "kube_watcher"}}
+		for _, record := range r.records {
+			lr := logRecord{
+				ObservedTimeUnixNano: strconv.FormatInt(record.observed.UnixNano(), 10),
+				SeverityNumber:       record.severity,
+				SeverityText:         strings.ToUpper(record.level),
+				Body:                 value{StringValue: record.body},
+				TraceID:              hex.EncodeToString(record.traceID),
+				SpanID:               hex.EncodeToString(record.spanID),
+			}
+			if !record.time.IsZero() {
+				lr.TimeUnixNano = strconv.FormatInt(record.time.UnixNano(), 10)
+			}
+			scope.LogRecords = append(scope.LogRecords, lr)
+		}
+		rl.ScopeLogs = []scopeLogs{scope}
+		request.ResourceLogs = append(request.ResourceLogs, rl)
+	}
+	return json.Marshal(request)
+}
+
+// otlpProtobuf encodes an ExportLogsServiceRequest by hand rather than pulling in the generated types:
+//
+//	ExportLogsServiceRequest { repeated ResourceLogs resource_logs = 1; }
+//	ResourceLogs             { Resource resource = 1; repeated ScopeLogs scope_logs = 2; }
+//	Resource                 { repeated KeyValue attributes = 1; }
+//	ScopeLogs                { InstrumentationScope scope = 1; repeated LogRecord log_records = 2; }
+//	InstrumentationScope     { string name = 1; }
+//	LogRecord                { fixed64 time_unix_nano = 1; SeverityNumber severity_number = 2; string severity_text = 3;
+//	                           AnyValue body = 5; bytes trace_id = 9; bytes span_id = 10; fixed64 observed_time_unix_nano = 11; }
+//	KeyValue                 { string key = 1; AnyValue value = 2; }
+//	AnyValue                 { string string_value = 1; ... }
+func otlpProtobuf(resources []*otlpResource) []byte {
+	var request []byte
+	for _, r := range resources {
+		var resource []byte
+		for _, a := range r.attributes {
+			var kv []byte
+			kv = protowire.AppendTag(kv, 1, protowire.BytesType)
+			kv = protowire.AppendString(kv, a.key)
+			kv = protowire.AppendTag(kv, 2, protowire.BytesType)
+			kv = protowire.AppendBytes(kv, anyString(a.value))
+			resource = protowire.AppendTag(resource, 1, protowire.BytesType)
+			resource = protowire.AppendBytes(resource, kv)
+		}
+
+		var scope []byte
+		scope = protowire.AppendTag(scope, 1, protowire.BytesType)
+		scope = protowire.AppendString(scope, "kube_watcher")
+		var scopeLogs []byte
+		scopeLogs = protowire.AppendTag(scopeLogs, 1, protowire.BytesType)
+		scopeLogs = protowire.AppendBytes(scopeLogs, scope)
+		for _, record := range r.records {
+			scopeLogs = protowire.AppendTag(scopeLogs, 2, protowire.BytesType)
+			scopeLogs = protowire.AppendBytes(scopeLogs, otlpRecordProtobuf(record))
+		}
+
+		var rl []byte
+		rl = protowire.AppendTag(rl, 1, protowire.BytesType)
+		rl = protowire.AppendBytes(rl, resource)
+		rl = protowire.AppendTag(rl, 2, protowire.BytesType)
+		rl = protowire.AppendBytes(rl, scopeLogs)
+		request = protowire.AppendTag(request, 1, protowire.BytesType)
+		request = protowire.AppendBytes(request, rl)
+	}
+	return request
+}
+
+func otlpRecordProtobuf(record otlpRecord) []byte {
+	var lr []byte
+	if !record.time.IsZero() {
+		lr = protowire.AppendTag(lr, 1, protowire.Fixed64Type)
+		lr = protowire.AppendFixed64(lr, uint64(record.time.UnixNano()))
+	}
+	if record.severity != 0 {
+		lr = protowire.AppendTag(lr, 2, protowire.VarintType)
+		lr = protowire.AppendVarint(lr, uint64(record.severity))
+	}
+	if record.level != "" {
+		lr = protowire.AppendTag(lr, 3, protowire.BytesType)
+		lr = protowire.AppendString(lr, strings.ToUpper(record.level))
+	}
+	lr = protowire.AppendTag(lr, 5, protowire.BytesType)
+	lr = protowire.AppendBytes(lr, anyString(record.body))
+	if record.traceID != nil {
+		lr = protowire.AppendTag(lr, 9, protowire.BytesType)
+		lr = protowire.AppendBytes(lr, record.traceID)
+	}
+	if record.spanID != nil {
+		lr = protowire.AppendTag(lr, 10, protowire.BytesType)
+		lr = protowire.AppendBytes(lr, record.spanID)
+	}
+	lr = protowire.AppendTag(lr, 11, protowire.Fixed64Type)
+	lr = protowire.AppendFixed64(lr, uint64(record.observed.UnixNano()))
+	return lr
+}
+
+func anyString(s string) []byte {
+	var value []byte
+	value = protowire.AppendTag(value, 1, protowire.BytesType)
+	return protowire.AppendString(value, s)
+}
+
+// parseOTLP reads otlp:<url>[?format=&batch=&flush=&header=Name:value], the options are taken off the url
+func parseOTLP(arg string) (*OTLP, error) {
+	u, err := url.Parse(arg)
+	if err != nil {
+		return nil, fmt.Errorf("invalid otlp url %q: %w", arg, err)
+	}
+	query := takeQuery(u, "format", "batch", "flush", "header")
+	opts := OTLPOptions{URL: u.String(), Format: query.Get("format"), Headers: http.Header{}}
+	if opts.BatchSize, opts.FlushInterval, err = batchOptions(query); err != nil {
+		return nil, fmt.Errorf("otlp: %w", err)
+	}
+	for _, header := range query["header"] {
+		name, value, ok := strings.Cut(header, ":")
+		if !ok {
+			return nil, fmt.Errorf("otlp: invalid header %q, use Name:value", header)
+		}
+		opts.Headers.Add(strings.TrimSpace(name), strings.TrimSpace(value))
+	}
+	return NewOTLP(opts)
+}
@@ -0,0 +1,77 @@
+package sink
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Retry is how a push is retried when the server is overloaded or failing
+type Retry struct {
+	MaxRetries int
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+}
+
+func DefaultRetry() Retry {
+	return Retry{MaxRetries: 5, MinBackoff: 500 * time.Millisecond, MaxBackoff: 30 * time.Second}
+}
+
+// post sends body to url and returns the response, retrying network errors, 429s and 5xxs with a doubling
+// backoff or after as long as the server asks for with Retry-After. Other responses outside 2xx fail straight away
+func (r Retry) post(client *http.Client, url string, header http.Header, body []byte) ([]byte, error) {
+	for attempt := 0; ; attempt++ {
+		resp, wait, err := postOnce(client, url, header, body)
+		if err == nil || wait < 0 || attempt >= r.MaxRetries {
+			return resp, err
+		}
+		if wait == 0 {
+			wait = r.backoff(attempt)
+		}
+		time.Sleep(min(wait, r.MaxBackoff))
+	}
+}
+
+// backoff is how long to wait before the attempt'th retry
+func (r Retry) backoff(attempt int) time.Duration {
+	wait := r.MinBackoff
+	for i := 0; i < attempt && wait < r.MaxBackoff; i++ {
+		wait *= 2
+	}
+	return min(wait, r.MaxBackoff)
+}
+
+// postOnce returns the response or how long the server asked to wait before retrying, 0 to use the
+// backoff and -1 when retrying won't help
+func postOnce(client *http.Client, url string, header http.Header, body []byte) ([]byte, time.Duration, error) {
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, -1, err
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 200 && resp.StatusCode <= 299 {
+		// the push went through, sending it again would duplicate it
+		content, err := io.ReadAll(resp.Body)
+		return content, -1, err
+	}
+	// the servers explain why they rejected a push in the body
+	reason, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	err = fmt.Errorf("posting to %v returned %v: %s", url, resp.StatusCode, bytes.TrimSpace(reason))
+	if resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode < 500 {
+		return nil, -1, err
+	}
+	if seconds, convErr := strconv.Atoi(resp.Header.Get("Retry-After")); convErr == nil && seconds > 0 {
+		return nil, time.Duration(seconds) * time.Second, err
+	}
+	return nil, 0, err
+}
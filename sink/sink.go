@@ -0,0 +1,128 @@
+package sink
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/farrjere/kube_watcher/kube"
+)
+
+/*
+Sinks are given on the command line as name[:argument]
+  - console          colored "pod line" to stdout
+  - plain            uncolored "pod line" to stdout
+  - jsonl            one JSON object per line to stdout
+  - file:<path>      every pod appended to a single file
+  - dir:<dir>        a file per pod in dir, rotated every 100MB
+  - http:<url>       batches of JSON entries POSTed to url
+  - loki:<url>       pushed to Loki, with ?tenant=, format=json|protobuf, batch= and flush= options
+  - elasticsearch:<url>  bulk indexed into Elasticsearch or OpenSearch, with ?index=kube-watcher-{2006.01.02}, batch=, flush= and inflight= options
+  - otlp:<url>       OpenTelemetry log records over OTLP/HTTP, with ?format=json|protobuf, batch=, flush= and header=Name:value options
+  - syslog:<udp|tcp|tls>://<host:port>  RFC 5424 messages, with ?facility=, buffer= and ca=<pem file> options
+  - kafka:<brokers>  JSON messages keyed by pod, with ?topic=logs.{namespace}.{deployment}, batch=, flush=, compression=, acks= and retries= options
+*/
+func Parse(spec string) (kube.LogSink, error) {
+	return ParseWith(spec, ParseOptions{})
+}
+
+type ParseOptions struct {
+	// ColorScheme is used by the console sink, see ColorsRandom, ColorsBasic and ColorsNone
+	ColorScheme string
+	// KafkaFailed is told how many entries for a topic the kafka sink couldn't deliver
+	KafkaFailed func(topic string, entries int)
+}
+
+func ParseWith(spec string, opts ParseOptions) (kube.LogSink, error) {
+	name, arg, _ := strings.Cut(spec, ":")
+	switch name {
+	case "console":
+		if !ValidColorScheme(opts.ColorScheme) {
+			return nil, fmt.Errorf("unknown color scheme %q", opts.ColorScheme)
+		}
+		return NewConsole(os.Stdout, opts.ColorScheme), nil
+	case "plain":
+		return NewPlainConsole(os.Stdout), nil
+	case "jsonl":
+		return NewJSONLines(os.Stdout), nil
+	}
+
+	if arg == "" {
+		return nil, fmt.Errorf("sink %q needs an argument, e.g. %v:<target>", spec, name)
+	}
+	switch name {
+	case "file":
+		return NewCombinedFile(arg)
+	case "dir":
+		return NewPodFiles(arg, RotateOptions{MaxSize: 100 * 1024 * 1024, MaxBackups: 5})
+	case "http":
+		return NewHTTPBatcher(arg, 100, 0), nil
+	case "loki":
+		return parseLoki(arg)
+	case "elasticsearch":
+		return parseElasticsearch(arg)
+	case "otlp":
+		return parseOTLP(arg)
+	case "syslog":
+		return parseSyslog(arg)
+	case "kafka":
+		return parseKafka(arg, opts.KafkaFailed)
+	}
+	return nil, fmt.Errorf("unknown sink %q", spec)
+}
+
+// takeQuery removes the named sink options from the url's query and returns them
+func takeQuery(u *url.URL, names ...string) url.Values {
+	query := u.Query()
+	options := url.Values{}
+	for _, name := range names {
+		if values, ok := query[name]; ok {
+			options[name] = values
+			query.Del(name)
+		}
+	}
+	u.RawQuery = query.Encode()
+	return options
+}
+
+// batchOptions reads the batch= and flush= options of the batching sinks, zero when not given
+func batchOptions(query url.Values) (int, time.Duration, error) {
+	var size int
+	var flush time.Duration
+	var err error
+	if batch := query.Get("batch"); batch != "" {
+		if size, err = strconv.Atoi(batch); err != nil {
+			return 0, 0, fmt.Errorf("invalid batch size %q", batch)
+		}
+	}
+	if interval := query.Get("flush"); interval != "" {
+		if flush, err = time.ParseDuration(interval); err != nil {
+			return 0, 0, fmt.Errorf("invalid flush interval %q", interval)
+		}
+	}
+	return size, flush, nil
+}
+
+// ParseAll parses every spec, closing the ones already opened if any of them fail
+func ParseAll(specs []string) ([]kube.LogSink, error) {
+	return ParseAllWith(specs, ParseOptions{})
+}
+
+func ParseAllWith(specs []string, opts ParseOptions) ([]kube.LogSink, error) {
+	sinks := make([]kube.LogSink, 0, len(specs))
+	for _, spec := range specs {
+		s, err := ParseWith(spec, opts)
+		if err != nil {
+			for _, opened := range sinks {
+				err = errors.Join(err, opened.Close())
+			}
+			return nil, err
+		}
+		sinks = append(sinks, s)
+	}
+	return sinks, nil
+}
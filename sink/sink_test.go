@@ -0,0 +1,832 @@
+package sink
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/farrjere/kube_watcher/kube"
+	"github.com/fatih/color"
+	"github.com/golang/snappy"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+func testEntry(pod string, message string) kube.LogEntry {
+	return kube.LogEntry{Time: time.Date(2023, 9, 1, 12, 0, 0, 0, time.UTC), Namespace: "default", Deployment: "test", Pod: pod, Message: message}
+}
+
+func TestPlainAndJSONLines(t *testing.T) {
+	var plain, jsonl bytes.Buffer
+	entries := make(chan kube.LogEntry, 2)
+	entries <- testEntry("pod-a", "hello")
+	entries <- testEntry("pod-b", "world")
+	close(entries)
+
+	err := kube.FanOut(entries, NewPlainConsole(&plain), NewJSONLines(&jsonl))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plain.String() != "pod-a 2023-09-01T12:00:00Z hello\npod-b 2023-09-01T12:00:00Z world\n" {
+		t.Errorf("unexpected plain output %q", plain.String())
+	}
+	lines := strings.Split(strings.TrimSpace(jsonl.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 json lines got %v", len(lines))
+	}
+	var entry kube.LogEntry
+	if err = json.Unmarshal([]byte(lines[1]), &entry); err != nil || entry.Pod != "pod-b" || entry.Message != "world" {
+		t.Errorf("unexpected json line %v - %v", lines[1], err)
+	}
+}
+
+func TestPodFilesRotate(t *testing.T) {
+	dir := t.TempDir()
+	pf, err := NewPodFiles(dir, RotateOptions{MaxSize: 64, MaxBackups: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		if err = pf.Write(testEntry("pod-a", "0123456789")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	pf.Write(testEntry("pod-b", "only line"))
+	if err = pf.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	files, _ := filepath.Glob(filepath.Join(dir, "pod-a.*log"))
+	if len(files) != 3 {
+		t.Errorf("expected the current file and 2 segments got %v", files)
+	}
+	for _, f := range files {
+		info, _ := os.Stat(f)
+		if info.Size() > 64 {
+			t.Errorf("%v is %v bytes, larger than the max size", f, info.Size())
+		}
+	}
+	b, _ := os.ReadFile(filepath.Join(dir, "pod-b.log"))
+	if string(b) != "2023-09-01T12:00:00Z only line\n" {
+		t.Errorf("unexpected pod-b contents %q", b)
+	}
+}
+
+func TestPodFilesRotateOnIntervalAndCompress(t *testing.T) {
+	dir := t.TempDir()
+	pf, err := NewPodFiles(dir, RotateOptions{Interval: time.Hour, Compress: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2023, 9, 1, 12, 0, 0, 0, time.UTC)
+	pf.now = func() time.Time { return now }
+	pf.Write(testEntry("pod-a", "first"))
+	now = now.Add(30 * time.Minute)
+	pf.Write(testEntry("pod-a", "second"))
+	now = now.Add(31 * time.Minute)
+	pf.Write(testEntry("pod-a", "third"))
+	pf.Close()
+
+	segment := filepath.Join(dir, "pod-a.20230901T130100.000.log.gz")
+	f, err := os.Open(segment)
+	if err != nil {
+		t.Fatalf("expected a compressed segment: %v", err)
+	}
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := io.ReadAll(zr)
+	if strings.Count(string(b), "\n") != 2 {
+		t.Errorf("expected the first two lines in the segment got %q", b)
+	}
+	current, _ := os.ReadFile(filepath.Join(dir, "pod-a.log"))
+	if !strings.HasSuffix(string(current), "third\n") {
+		t.Errorf("expected the current file to have only the third line got %q", current)
+	}
+}
+
+func TestPodFilesRetention(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now()
+	old := filepath.Join(dir, "pod-a.20230801T120000.000.log.gz")
+	os.WriteFile(old, []byte("old"), 0o644)
+	os.Chtimes(old, now.Add(-48*time.Hour), now.Add(-48*time.Hour))
+	big := filepath.Join(dir, "pod-b.20230901T120000.000.log")
+	os.WriteFile(big, make([]byte, 200), 0o644)
+	os.Chtimes(big, now.Add(-time.Hour), now.Add(-time.Hour))
+
+	pf, err := NewPodFiles(dir, RotateOptions{MaxSize: 30, MaxAge: 24 * time.Hour, MaxTotalSize: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pf.Write(testEntry("pod-c", "a line"))
+	pf.Write(testEntry("pod-c", "a line"))
+	pf.Close()
+
+	if exists(old) {
+		t.Errorf("expected the segment past the max age to be removed")
+	}
+	if exists(big) {
+		t.Errorf("expected the oldest segment to be removed to get under the total size")
+	}
+	if !exists(filepath.Join(dir, "pod-c.log")) {
+		t.Errorf("expected the live file to be kept")
+	}
+}
+
+func TestPodFilesFlushAndCleanUpWhileIdle(t *testing.T) {
+	dir := t.TempDir()
+	old := filepath.Join(dir, "pod-a.20230801T120000.000.log")
+	os.WriteFile(old, []byte("old"), 0o644)
+	stale := time.Now().Add(-48 * time.Hour)
+	os.Chtimes(old, stale, stale)
+
+	pf, err := NewPodFiles(dir, RotateOptions{MaxAge: 24 * time.Hour, FlushInterval: 10 * time.Millisecond, CleanupInterval: 10 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pf.Close()
+	if exists(old) {
+		t.Errorf("expected the retention limits enforced without a rotation")
+	}
+	pf.Write(testEntry("pod-b", "a line"))
+	var b []byte
+	for i := 0; i < 100 && len(b) == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+		b, _ = os.ReadFile(filepath.Join(dir, "pod-b.log"))
+	}
+	if string(b) != "2023-09-01T12:00:00Z a line\n" {
+		t.Errorf("expected the line flushed before the file is closed got %q", b)
+	}
+
+	// a segment rotated by something else while idle
+	os.WriteFile(old, []byte("old"), 0o644)
+	os.Chtimes(old, stale, stale)
+	for i := 0; i < 100 && exists(old); i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if exists(old) {
+		t.Errorf("expected the retention limits enforced periodically")
+	}
+}
+
+func TestPodFilesCloseIdlePods(t *testing.T) {
+	dir := t.TempDir()
+	pf, err := NewPodFiles(dir, RotateOptions{CleanupInterval: 10 * time.Millisecond, IdleTimeout: 20 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	open := func() int {
+		pf.mu.Lock()
+		defer pf.mu.Unlock()
+		return len(pf.files)
+	}
+	pf.Write(testEntry("pod-a", "first"))
+	for i := 0; i < 100 && open() > 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if open() != 0 {
+		t.Errorf("expected the idle pod's file closed")
+	}
+	if err := pf.Write(testEntry("pod-a", "second")); err != nil {
+		t.Error(err)
+	}
+	pf.Close()
+	b, _ := os.ReadFile(filepath.Join(dir, "pod-a.log"))
+	if string(b) != "2023-09-01T12:00:00Z first\n2023-09-01T12:00:00Z second\n" {
+		t.Errorf("expected the file reopened and appended to got %q", b)
+	}
+}
+
+func TestHTTPBatcher(t *testing.T) {
+	var mu sync.Mutex
+	batches := make([][]kube.LogEntry, 0)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var batch []kube.LogEntry
+		json.NewDecoder(r.Body).Decode(&batch)
+		mu.Lock()
+		batches = append(batches, batch)
+		mu.Unlock()
+	}))
+	defer server.Close()
+
+	h := NewHTTPBatcher(server.URL, 2, time.Hour)
+	for _, m := range []string{"a", "b", "c"} {
+		if err := h.Write(testEntry("pod-a", m)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := h.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if len(batches) != 2 || len(batches[0]) != 2 || len(batches[1]) != 1 || batches[1][0].Message != "c" {
+		t.Errorf("expected a full batch and the remainder flushed on close, got %v", batches)
+	}
+}
+
+func TestHTTPBatcherDoesNotWaitOnASlowEndpoint(t *testing.T) {
+	release := make(chan struct{})
+	var posted atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		posted.Add(1)
+	}))
+	defer server.Close()
+
+	h := NewHTTPBatcher(server.URL, 1, time.Hour)
+	start := time.Now()
+	for _, m := range []string{"a", "b", "c"} {
+		h.Write(testEntry("pod-a", m))
+	}
+	if took := time.Since(start); took > time.Second {
+		t.Errorf("expected writes not to wait for the endpoint, took %v", took)
+	}
+	close(release)
+	if err := h.Close(); err != nil || posted.Load() != 3 {
+		t.Errorf("expected every batch posted by close got %v %v", posted.Load(), err)
+	}
+}
+
+func TestHTTPBatcherKeepsCredentialsOutOfErrors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer server.Close()
+	refused := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	refused.Close()
+
+	// a client without a timeout still gets the default one rather than none at all
+	for base, expected := range map[string]string{server.URL: "returned 401", refused.URL: "connection refused"} {
+		h := NewHTTPBatcher(strings.Replace(base, "http://", "http://user:hunter2@", 1)+"/logs?token=hunter2", 1, time.Hour)
+		h.Client = &http.Client{}
+		h.Write(testEntry("pod-a", "a"))
+		err := h.Close()
+		if err == nil || !strings.Contains(err.Error(), expected) || strings.Contains(err.Error(), "hunter2") {
+			t.Errorf("expected %q without the credentials got %v", expected, err)
+		}
+	}
+}
+
+// lokiStandIn accepts pushes in either format, answering the first `failures` of them with a 429,
+// and records the lines pushed by stream labels
+type lokiStandIn struct {
+	mu       sync.Mutex
+	failures int
+	pushes   int
+	tenants  []string
+	streams  map[string][]string
+}
+
+func (l *lokiStandIn) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.pushes++
+	if r.URL.Path != "/loki/api/v1/push" {
+		http.NotFound(w, r)
+		return
+	}
+	if l.failures > 0 {
+		l.failures--
+		http.Error(w, "slow down", http.StatusTooManyRequests)
+		return
+	}
+	l.tenants = append(l.tenants, r.Header.Get("X-Scope-OrgID"))
+	body, _ := io.ReadAll(r.Body)
+	if r.Header.Get("Content-Type") == "application/json" {
+		var push struct {
+			Streams []struct {
+				Stream map[string]string `json:"stream"`
+				Values [][2]string       `json:"values"`
+			} `json:"streams"`
+		}
+		if err := json.Unmarshal(body, &push); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		for _, s := range push.Streams {
+			key := formatLabels(s.Stream)
+			for _, v := range s.Values {
+				l.streams[key] = append(l.streams[key], v[1])
+			}
+		}
+	} else {
+		decoded, err := snappy.Decode(nil, body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		for _, stream := range protoFields(decoded, 1) {
+			key := string(protoFields(stream, 1)[0])
+			for _, entry := range protoFields(stream, 2) {
+				l.streams[key] = append(l.streams[key], string(protoFields(entry, 2)[0]))
+			}
+		}
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// protoFields are the values of the message's length delimited field number
+func protoFields(message []byte, number protowire.Number) [][]byte {
+	var values [][]byte
+	for len(message) > 0 {
+		n, typ, length := protowire.ConsumeTag(message)
+		message = message[length:]
+		if typ == protowire.BytesType && n == number {
+			value, length := protowire.ConsumeBytes(message)
+			values = append(values, value)
+			message = message[length:]
+			continue
+		}
+		message = message[protowire.ConsumeFieldValue(n, typ, message):]
+	}
+	return values
+}
+
+// protoVarint is the value of the message's varint field number
+func protoVarint(message []byte, number protowire.Number) uint64 {
+	for len(message) > 0 {
+		n, typ, length := protowire.ConsumeTag(message)
+		message = message[length:]
+		if typ == protowire.VarintType && n == number {
+			value, _ := protowire.ConsumeVarint(message)
+			return value
+		}
+		message = message[protowire.ConsumeFieldValue(n, typ, message):]
+	}
+	return 0
+}
+
+func TestLoki(t *testing.T) {
+	for _, format := range []string{LokiProtobuf, LokiJSON} {
+		standIn := &lokiStandIn{failures: 1, streams: make(map[string][]string)}
+		server := httptest.NewServer(standIn)
+		l, err := parseLoki(server.URL + "?tenant=team-a&batch=3&flush=1h&format=" + format)
+		if err != nil {
+			t.Fatal(err)
+		}
+		l.opts.Retry = Retry{MaxRetries: 2, MinBackoff: time.Millisecond, MaxBackoff: 10 * time.Millisecond}
+		a := testEntry("pod-a", "a")
+		a.Cluster = "prod"
+		a.Container = "app"
+		for _, e := range []kube.LogEntry{a, testEntry("pod-b", "b"), a, testEntry("pod-b", "c")} {
+			if err = l.Write(e); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err = l.Close(); err != nil {
+			t.Fatal(err)
+		}
+		server.Close()
+
+		podA := `{cluster="prod", container="app", deployment="test", job="kube_watcher", namespace="default", pod="pod-a"}`
+		podB := `{deployment="test", job="kube_watcher", namespace="default", pod="pod-b"}`
+		if strings.Join(standIn.streams[podA], ",") != "a,a" || strings.Join(standIn.streams[podB], ",") != "b,c" || len(standIn.streams) != 2 {
+			t.Errorf("%v: expected the lines grouped by pod labels, got %v", format, standIn.streams)
+		}
+		// the 429, the full batch retried, then the rest on close
+		if standIn.pushes != 3 || strings.Join(standIn.tenants, ",") != "team-a,team-a" {
+			t.Errorf("%v: expected the rate limited push retried with the tenant, got %v pushes for %v", format, standIn.pushes, standIn.tenants)
+		}
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "entry too far behind", http.StatusBadRequest)
+	}))
+	defer server.Close()
+	l, err := NewLoki(LokiOptions{URL: server.URL, BatchSize: 1, FlushInterval: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = l.Write(testEntry("pod-a", "a")); err == nil || !strings.Contains(err.Error(), "entry too far behind") {
+		t.Errorf("expected a rejected push to fail without retrying, got %v", err)
+	}
+	l.Close()
+}
+
+func TestElasticsearch(t *testing.T) {
+	var mu sync.Mutex
+	var indices []string
+	var docs []map[string]any
+	bulks := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if r.URL.Path != "/_bulk" || r.Header.Get("Content-Type") != "application/x-ndjson" {
+			http.NotFound(w, r)
+			return
+		}
+		bulks++
+		var items []map[string]any
+		decoder := json.NewDecoder(r.Body)
+		for decoder.More() {
+			var action map[string]map[string]string
+			var doc map[string]any
+			if err := decoder.Decode(&action); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			decoder.Decode(&doc)
+			// the first bulk has b rejected for good and c rejected as the cluster is busy
+			switch {
+			case bulks == 1 && doc["message"] == "b":
+				items = append(items, map[string]any{"create": map[string]any{"status": 400, "error": map[string]string{"type": "mapper_parsing_exception", "reason": "failed to parse"}}})
+			case bulks == 1 && doc["message"] == "c":
+				items = append(items, map[string]any{"create": map[string]any{"status": 429, "error": map[string]string{"type": "es_rejected_execution_exception"}}})
+			default:
+				indices = append(indices, action["create"]["_index"])
+				docs = append(docs, doc)
+				items = append(items, map[string]any{"create": map[string]any{"status": 201}})
+			}
+		}
+		json.NewEncoder(w).Encode(map[string]any{"errors": bulks == 1, "items": items})
+	}))
+	defer server.Close()
+
+	e, err := parseElasticsearch(server.URL + "?index=logs-{2006.01.02}&batch=3&flush=1h&inflight=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.opts.Retry = Retry{MaxRetries: 2, MinBackoff: time.Millisecond, MaxBackoff: 10 * time.Millisecond}
+	structured := testEntry("pod-a", `{"level":"WARN","msg":"slow","took":3}`)
+	structured.Container = "app"
+	for _, entry := range []kube.LogEntry{structured, testEntry("pod-a", "b"), testEntry("pod-b", "c")} {
+		if err = e.Write(entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = e.Close()
+	if err == nil || !strings.Contains(err.Error(), "rejected 1 of 3") || !strings.Contains(err.Error(), "mapper_parsing_exception") {
+		t.Errorf("expected the document rejected for good to be reported, got %v", err)
+	}
+	if bulks != 2 || len(docs) != 2 || docs[1]["message"] != "c" {
+		t.Fatalf("expected the busy document retried in a second bulk, got %v bulks of %v", bulks, docs)
+	}
+	if indices[0] != "logs-2023.09.01" {
+		t.Errorf("expected a daily index got %v", indices[0])
+	}
+	doc := docs[0]
+	fields, _ := doc["fields"].(map[string]any)
+	if doc["@timestamp"] != "2023-09-01T12:00:00Z" || doc["namespace"] != "default" || doc["pod"] != "pod-a" || doc["container"] != "app" ||
+		doc["level"] != "warn" || fields["msg"] != "slow" || fields["took"] != 3.0 {
+		t.Errorf("expected the entry and its parsed fields in the document, got %v", doc)
+	}
+	if _, ok := docs[1]["fields"]; ok {
+		t.Errorf("expected plain lines to have no fields, got %v", docs[1])
+	}
+}
+
+// exportedRecord is what the tests check of an exported log record
+type exportedRecord struct {
+	resource map[string]string
+	body     string
+	severity uint64
+	traceID  string
+	spanID   string
+}
+
+func TestOTLP(t *testing.T) {
+	for _, format := range []string{OTLPProtobuf, OTLPJSON} {
+		var mu sync.Mutex
+		var records []exportedRecord
+		var auth []string
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			defer mu.Unlock()
+			if r.URL.Path != "/v1/logs" {
+				http.NotFound(w, r)
+				return
+			}
+			auth = append(auth, r.Header.Get("Authorization"))
+			body, _ := io.ReadAll(r.Body)
+			if r.Header.Get("Content-Type") == "application/json" {
+				var request struct {
+					ResourceLogs []struct {
+						Resource struct {
+							Attributes []struct {
+								Key   string `json:"key"`
+								Value struct {
+									StringValue string `json:"stringValue"`
+								} `json:"value"`
+							} `json:"attributes"`
+						} `json:"resource"`
+						ScopeLogs []struct {
+							LogRecords []struct {
+								SeverityNumber uint64 `json:"severityNumber"`
+								Body           struct {
+									StringValue string `json:"stringValue"`
+								} `json:"body"`
+								TraceID string `json:"traceId"`
+								SpanID  string `json:"spanId"`
+							} `json:"logRecords"`
+						} `json:"scopeLogs"`
+					} `json:"resourceLogs"`
+				}
+				json.Unmarshal(body, &request)
+				for _, rl := range request.ResourceLogs {
+					resource := make(map[string]string)
+					for _, a := range rl.Resource.Attributes {
+						resource[a.Key] = a.Value.StringValue
+					}
+					for _, lr := range rl.ScopeLogs[0].LogRecords {
+						records = append(records, exportedRecord{resource, lr.Body.StringValue, lr.SeverityNumber, lr.TraceID, lr.SpanID})
+					}
+				}
+				return
+			}
+			for _, rl := range protoFields(body, 1) {
+				resource := make(map[string]string)
+				for _, kv := range protoFields(protoFields(rl, 1)[0], 1) {
+					resource[string(protoFields(kv, 1)[0])] = string(protoFields(protoFields(kv, 2)[0], 1)[0])
+				}
+				for _, lr := range protoFields(protoFields(rl, 2)[0], 2) {
+					record := exportedRecord{resource: resource, body: string(protoFields(protoFields(lr, 5)[0], 1)[0]), severity: protoVarint(lr, 2)}
+					if ids := protoFields(lr, 9); len(ids) > 0 {
+						record.traceID = hex.EncodeToString(ids[0])
+					}
+					if ids := protoFields(lr, 10); len(ids) > 0 {
+						record.spanID = hex.EncodeToString(ids[0])
+					}
+					records = append(records, record)
+				}
+			}
+		}))
+
+		o, err := parseOTLP(server.URL + "?flush=1h&header=Authorization:Bearer%20token&format=" + format)
+		if err != nil {
+			t.Fatal(err)
+		}
+		traced := testEntry("pod-a", `{"level":"error","msg":"failed","trace_id":"4bf92f3577b34da6a3ce929d0e0e4736","span_id":"00f067aa0ba902b7"}`)
+		traced.Container = "app"
+		event := testEntry("pod-b", "Pulled image")
+		event.Kind = kube.EntryEvent
+		event.Level = "warning"
+		for _, e := range []kube.LogEntry{traced, testEntry("pod-a", "plain"), event} {
+			if err = o.Write(e); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err = o.Close(); err != nil {
+			t.Fatal(err)
+		}
+		server.Close()
+
+		if len(records) != 3 || len(auth) != 1 || auth[0] != "Bearer token" {
+			t.Fatalf("%v: expected one export of 3 records with the header, got %v with %v", format, records, auth)
+		}
+		first := records[0]
+		if first.resource["k8s.namespace.name"] != "default" || first.resource["k8s.pod.name"] != "pod-a" || first.resource["k8s.container.name"] != "app" ||
+			first.resource["k8s.deployment.name"] != "test" || first.resource["service.name"] != "test" {
+			t.Errorf("%v: expected the kubernetes resource attributes, got %v", format, first.resource)
+		}
+		if first.severity != 17 || first.traceID != "4bf92f3577b34da6a3ce929d0e0e4736" || first.spanID != "00f067aa0ba902b7" {
+			t.Errorf("%v: expected the level and trace lifted from the json line, got %+v", format, first)
+		}
+		if records[1].severity != 0 || records[1].traceID != "" || records[1].body != "plain" {
+			t.Errorf("%v: expected a plain line to have no severity or trace, got %+v", format, records[1])
+		}
+		if records[2].severity != 13 || records[2].resource["k8s.pod.name"] != "pod-b" || records[2].resource["k8s.container.name"] != "" {
+			t.Errorf("%v: expected the warning event as its own resource, got %+v", format, records[2])
+		}
+	}
+}
+
+func TestSyslog(t *testing.T) {
+	for query, facility := range map[string]int{"": 1, "?facility=0": 0, "?facility=23": 23} {
+		s, err := parseSyslog("udp://127.0.0.1:514" + query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s.facility != facility {
+			t.Errorf("expected %q to be facility %v got %v", query, facility, s.facility)
+		}
+		s.Close()
+	}
+
+	// the receiver is down to begin with
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := listener.Addr().String()
+	listener.Close()
+
+	s, err := parseSyslog("tcp://" + address + "?facility=16")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.opts.Retry = Retry{MinBackoff: 5 * time.Millisecond, MaxBackoff: 20 * time.Millisecond}
+	structured := testEntry("pod-a", `{"level":"error","msg":"failed"}`)
+	structured.Container = "app"
+	structured.Cluster = "prod"
+	event := testEntry("pod-b", "Back-off restarting")
+	event.Kind = kube.EntryEvent
+	event.Level = "warning"
+	event.Deployment = `quote"d`
+	for _, e := range []kube.LogEntry{structured, event, testEntry("pod-a", "plain")} {
+		s.Write(e)
+	}
+
+	listener, err = net.Listen("tcp", address)
+	if err != nil {
+		t.Skipf("couldn't listen on %v again: %v", address, err)
+	}
+	defer listener.Close()
+	conn, err := listener.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	var messages []string
+	for len(messages) < 3 {
+		length, err := reader.ReadString(' ')
+		if err != nil {
+			t.Fatal(err)
+		}
+		size, _ := strconv.Atoi(strings.TrimSpace(length))
+		message := make([]byte, size)
+		if _, err = io.ReadFull(reader, message); err != nil {
+			t.Fatal(err)
+		}
+		messages = append(messages, string(message))
+	}
+	if err = s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{
+		`<131>1 2023-09-01T12:00:00.000000Z pod-a app - - [kube@32473 namespace="default" deployment="test" cluster="prod"] {"level":"error","msg":"failed"}`,
+		`<132>1 2023-09-01T12:00:00.000000Z pod-b quote"d - event [kube@32473 namespace="default" deployment="quote\"d"] Back-off restarting`,
+		`<134>1 2023-09-01T12:00:00.000000Z pod-a test - - [kube@32473 namespace="default" deployment="test"] plain`,
+	}
+	for i, m := range messages {
+		if m != expected[i] {
+			t.Errorf("expected the buffered message\n%v\ngot\n%v", expected[i], m)
+		}
+	}
+
+	// closing with the receiver down reports what wasn't sent
+	listener.Close()
+	down, _ := NewSyslog(SyslogOptions{Network: SyslogUDP, Address: "127.0.0.1:1"})
+	down.Close()
+	down, _ = NewSyslog(SyslogOptions{Network: SyslogTCP, Address: address, Retry: Retry{MinBackoff: time.Millisecond, MaxBackoff: time.Millisecond}})
+	down.Write(testEntry("pod-a", "lost"))
+	if err = down.Close(); err == nil || !strings.Contains(err.Error(), "weren't sent") {
+		t.Errorf("expected the unsent message to be reported, got %v", err)
+	}
+}
+
+// mockProducer records what is produced, failing the messages for the pods in fail
+type mockProducer struct {
+	mu       sync.Mutex
+	produced []KafkaMessage
+	fail     map[string]bool
+	closed   bool
+}
+
+func (p *mockProducer) Produce(ctx context.Context, messages []KafkaMessage) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	errs := make(ProduceErrors, len(messages))
+	failed := false
+	for i, m := range messages {
+		if p.fail[string(m.Key)] {
+			errs[i] = errors.New("not enough replicas")
+			failed = true
+			continue
+		}
+		p.produced = append(p.produced, m)
+	}
+	if failed {
+		return errs
+	}
+	return nil
+}
+
+func (p *mockProducer) Close() error {
+	p.closed = true
+	return nil
+}
+
+func TestKafka(t *testing.T) {
+	producer := &mockProducer{fail: map[string]bool{"pod-c": true}}
+	failures := make(map[string]int)
+	k := NewKafkaWith(producer, KafkaOptions{
+		Topic:         "logs.{namespace}.{deployment}/{container}",
+		BatchSize:     3,
+		FlushInterval: time.Hour,
+		Failed:        func(topic string, entries int) { failures[topic] += entries },
+	})
+	withContainer := testEntry("pod-a", "a1")
+	withContainer.Container = "app"
+	var err error
+	for _, e := range []kube.LogEntry{withContainer, testEntry("pod-b", "b1"), testEntry("pod-c", "c1"), testEntry("pod-a", "a2"), testEntry("pod-b", "b2")} {
+		err = errors.Join(err, k.Write(e))
+	}
+	if err == nil || !strings.Contains(err.Error(), "1 of 3 messages failed: not enough replicas") {
+		t.Errorf("expected the failed delivery reported, got %v", err)
+	}
+	if err = k.Close(); err != nil || !producer.closed {
+		t.Fatalf("expected the rest produced on close, got %v", err)
+	}
+
+	var order []string
+	for _, m := range producer.produced {
+		var entry kube.LogEntry
+		if err = json.Unmarshal(m.Value, &entry); err != nil {
+			t.Fatal(err)
+		}
+		if string(m.Key) != entry.Pod {
+			t.Errorf("expected messages keyed by pod, got %s for %v", m.Key, entry.Pod)
+		}
+		order = append(order, m.Topic+" "+entry.Message)
+	}
+	expected := "logs.default.test_app a1,logs.default.test_ b1,logs.default.test_ a2,logs.default.test_ b2"
+	if strings.Join(order, ",") != expected {
+		t.Errorf("expected %v got %v", expected, strings.Join(order, ","))
+	}
+	if len(failures) != 1 || failures["logs.default.test_"] != 1 {
+		t.Errorf("expected the failure counted by topic, got %v", failures)
+	}
+}
+
+func TestEntryLevel(t *testing.T) {
+	cases := map[string]string{
+		`{"level":"WARN","msg":"slow"}`:                    "warn",
+		`time=2023-09-01T12:00:00Z level=error msg=failed`: "error",
+		`ts=1 lvl="debug" msg="cache miss"`:                "debug",
+		`E1019 12:00:00.000000       1 main.go:10] failed`: "error",
+		`W1019 12:00:00.000000       1 main.go:10] slow`:   "warning",
+		`ERROR failed to connect`:                          "error",
+		`2023-09-01 12:00:00,123 [INFO] started`:           "info",
+		`fatal: out of memory`:                             "fatal",
+		`the error was handled`:                            "",
+		`Information about the request`:                    "",
+	}
+	for message, expected := range cases {
+		entry := testEntry("pod-a", message)
+		if level := entryLevel(entry, jsonFields(message)); level != expected {
+			t.Errorf("expected %q to be at %q got %q", message, expected, level)
+		}
+	}
+	if level := entryLevel(kube.LogEntry{Level: "Warning", Message: "ERROR"}, nil); level != "warning" {
+		t.Errorf("expected the entry's own level first got %v", level)
+	}
+}
+
+func TestParse(t *testing.T) {
+	dir := t.TempDir()
+	sinks, err := ParseAll([]string{"plain", "jsonl", "file:" + filepath.Join(dir, "all.log"), "dir:" + filepath.Join(dir, "pods")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sinks) != 4 {
+		t.Errorf("expected 4 sinks got %v", len(sinks))
+	}
+	for _, s := range sinks {
+		s.Close()
+	}
+	for _, spec := range []string{"nope", "file", "dir:", "loki:nohost", "loki:http://loki:3100?format=xml", "elasticsearch:http://es:9200?inflight=x", "elasticsearch:http://es:9200?index={a}{b}", "otlp:http://collector:4318?header=nocolon", "syslog:http://host:514", "syslog:udp://nohost", "syslog:tcp://host:601?facility=24", "kafka:?topic=logs", "kafka:localhost:9092?acks=two", "kafka:localhost:9092?compression=brotli"} {
+		if _, err = Parse(spec); err == nil {
+			t.Errorf("expected %q to fail to parse", spec)
+		}
+	}
+}
+
+func TestColorSchemes(t *testing.T) {
+	s, err := ParseWith("console", ParseOptions{ColorScheme: ColorsNone})
+	if _, ok := s.(*PlainConsole); err != nil || !ok {
+		t.Errorf("expected no colors to be a plain console got %T %v", s, err)
+	}
+	s, err = ParseWith("console", ParseOptions{ColorScheme: ColorsBasic})
+	c, ok := s.(*ColorConsole)
+	if err != nil || !ok {
+		t.Fatalf("expected a color console got %T %v", s, err)
+	}
+	c.out = io.Discard
+	c.Write(testEntry("web-1", "a"))
+	c.Write(testEntry("web-2", "b"))
+	if !c.logColors["web-1"].Equals(color.New(basicColors[0])) || !c.logColors["web-2"].Equals(color.New(basicColors[1])) {
+		t.Errorf("expected each pod to get the next basic color")
+	}
+	if _, err = ParseWith("console", ParseOptions{ColorScheme: "rainbow"}); err == nil {
+		t.Errorf("expected an unknown color scheme to fail")
+	}
+}
@@ -0,0 +1,317 @@
+package sink
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/farrjere/kube_watcher/kube"
+)
+
+// The transports a syslog receiver can listen on, TCP and TLS use octet-counting framing (RFC 6587)
+const (
+	SyslogUDP = "udp"
+	SyslogTCP = "tcp"
+	SyslogTLS = "tls"
+)
+
+// syslogSDID names the structured data element, 32473 is the enterprise number reserved for examples
+const syslogSDID = "kube@32473"
+
+// maxUDPMessage is the most a UDP datagram can carry
+const maxUDPMessage = 65507
+
+type SyslogOptions struct {
+	Network string
+	Address string
+	// Facility is the syslog facility, 1 (user) if nil
+	Facility *int
+	// TLS configures the tls transport, the system roots are trusted if not set
+	TLS *tls.Config
+	// Buffer is how many messages are kept while the receiver is down, the oldest are dropped past it
+	Buffer int
+	Retry  Retry
+}
+
+/*
+Syslog forwards entries as RFC 5424 messages, with the pod as the hostname, the container as the app-name and the
+namespace, deployment and cluster as structured data. Messages are sent in the background, buffering and reconnecting
+while the receiver is down
+*/
+type Syslog struct {
+	opts     SyslogOptions
+	facility int
+	mu       sync.Mutex
+	queue    [][]byte
+	dropped  int
+	err      error
+	conn     net.Conn
+	wake     chan struct{}
+	done     chan struct{}
+	stopped  chan struct{}
+}
+
+func NewSyslog(opts SyslogOptions) (*Syslog, error) {
+	switch opts.Network {
+	case SyslogUDP, SyslogTCP, SyslogTLS:
+	default:
+		return nil, fmt.Errorf("unknown syslog network %q, use %v, %v or %v", opts.Network, SyslogUDP, SyslogTCP, SyslogTLS)
+	}
+	if _, _, err := net.SplitHostPort(opts.Address); err != nil {
+		return nil, fmt.Errorf("invalid syslog address %q: %w", opts.Address, err)
+	}
+	facility := 1
+	if opts.Facility != nil {
+		facility = *opts.Facility
+	}
+	if facility < 0 || facility > 23 {
+		return nil, fmt.Errorf("invalid syslog facility %v, it must be 0 to 23", facility)
+	}
+	if opts.Buffer < 1 {
+		opts.Buffer = 10000
+	}
+	if opts.Retry == (Retry{}) {
+		opts.Retry = DefaultRetry()
+	}
+	s := Syslog{
+		opts:     opts,
+		facility: facility,
+		wake:     make(chan struct{}, 1),
+		done:     make(chan struct{}),
+		stopped:  make(chan struct{}),
+	}
+	go s.send()
+	return &s, nil
+}
+
+func (s *Syslog) Write(entry kube.LogEntry) error {
+	if entry.Time.IsZero() {
+		entry.Time = time.Now()
+	}
+	message := formatSyslog(entry, s.facility)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.queue) >= s.opts.Buffer {
+		s.queue = s.queue[1:]
+		s.dropped++
+	}
+	s.queue = append(s.queue, message)
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+	// report failures from the background sends once so the caller knows lines went missing
+	err := s.err
+	if s.dropped > 0 {
+		err = errors.Join(fmt.Errorf("syslog receiver %v is down, dropped %v messages", s.opts.Address, s.dropped), err)
+		s.dropped = 0
+	}
+	s.err = nil
+	return err
+}
+
+// send writes the queued messages until closed, holding on to a message until it is written
+func (s *Syslog) send() {
+	defer close(s.stopped)
+	var message []byte
+	for attempt := 0; ; {
+		if message == nil {
+			var ok bool
+			if message, ok = s.next(); !ok {
+				return
+			}
+		}
+		err := s.write(message)
+		if err == nil {
+			message = nil
+			attempt = 0
+			continue
+		}
+		s.mu.Lock()
+		if s.err == nil {
+			s.err = err
+		}
+		s.mu.Unlock()
+		select {
+		case <-s.done:
+			s.giveUp(err)
+			return
+		case <-time.After(s.opts.Retry.backoff(attempt)):
+			attempt++
+		}
+	}
+}
+
+// next waits for a message, returning false once closed with nothing left to send
+func (s *Syslog) next() ([]byte, bool) {
+	for {
+		s.mu.Lock()
+		if len(s.queue) > 0 {
+			message := s.queue[0]
+			s.queue = s.queue[1:]
+			s.mu.Unlock()
+			return message, true
+		}
+		s.mu.Unlock()
+		select {
+		case <-s.wake:
+		case <-s.done:
+			s.mu.Lock()
+			empty := len(s.queue) == 0
+			s.mu.Unlock()
+			if empty {
+				return nil, false
+			}
+		}
+	}
+}
+
+// giveUp reports the messages that couldn't be sent before closing
+func (s *Syslog) giveUp(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.err = fmt.Errorf("syslog receiver %v is down, %v messages weren't sent: %w", s.opts.Address, len(s.queue)+1, err)
+	s.queue = nil
+}
+
+// write sends a message, connecting first if needed, and drops the connection if it fails
+func (s *Syslog) write(message []byte) error {
+	if s.conn == nil {
+		conn, err := s.dial()
+		if err != nil {
+			return err
+		}
+		s.conn = conn
+	}
+	s.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	var err error
+	if s.opts.Network == SyslogUDP {
+		_, err = s.conn.Write(message[:min(len(message), maxUDPMessage)])
+	} else {
+		_, err = s.conn.Write(append([]byte(strconv.Itoa(len(message))+" "), message...))
+	}
+	if err != nil {
+		s.conn.Close()
+		s.conn = nil
+	}
+	return err
+}
+
+func (s *Syslog) dial() (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: 10 * time.Second}
+	if s.opts.Network == SyslogTLS {
+		return tls.DialWithDialer(dialer, "tcp", s.opts.Address, s.opts.TLS)
+	}
+	return dialer.Dial(s.opts.Network, s.opts.Address)
+}
+
+// Close sends what is buffered, giving up if the receiver is down
+func (s *Syslog) Close() error {
+	close(s.done)
+	<-s.stopped
+	if s.conn != nil {
+		s.conn.Close()
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
+
+// syslogSeverity maps a level to a syslog severity, info for unknown levels
+func syslogSeverity(level string) int {
+	switch level {
+	case "fatal", "panic", "critical", "crit":
+		return 2
+	case "error", "err":
+		return 3
+	case "warn", "warning":
+		return 4
+	case "notice":
+		return 5
+	case "debug", "trace":
+		return 7
+	}
+	return 6
+}
+
+// formatSyslog writes the entry as an RFC 5424 message:
+//
+//	<PRI>1 TIMESTAMP HOSTNAME APP-NAME PROCID MSGID [kube@32473 namespace="" deployment="" cluster=""] MSG
+func formatSyslog(entry kube.LogEntry, facility int) []byte {
+	severity := syslogSeverity(entryLevel(entry, jsonFields(entry.Message)))
+	appName := entry.Container
+	if appName == "" {
+		appName = entry.Deployment
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "<%d>1 %v %v %v - %v ", facility*8+severity, entry.Time.UTC().Format("2006-01-02T15:04:05.000000Z07:00"),
+		syslogHeader(entry.Pod, 255), syslogHeader(appName, 48), syslogHeader(entry.Kind, 32))
+	b.WriteString("[" + syslogSDID)
+	for _, param := range [][2]string{{"namespace", entry.Namespace}, {"deployment", entry.Deployment}, {"cluster", entry.Cluster}} {
+		if param[1] != "" {
+			fmt.Fprintf(&b, ` %v="%v"`, param[0], sdEscaper.Replace(param[1]))
+		}
+	}
+	b.WriteString("] ")
+	b.WriteString(entry.Message)
+	return []byte(b.String())
+}
+
+// syslogHeader makes a header field printable ASCII without spaces and at most limit long, - when empty
+func syslogHeader(value string, limit int) string {
+	value = strings.Map(func(r rune) rune {
+		if r <= ' ' || r > '~' {
+			return '_'
+		}
+		return r
+	}, value)
+	if value == "" {
+		return "-"
+	}
+	return value[:min(len(value), limit)]
+}
+
+var sdEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`)
+
+// parseSyslog reads syslog:<udp|tcp|tls>://<host:port>[?facility=&buffer=&ca=], ca is a PEM file of
+// certificates to trust for tls
+func parseSyslog(arg string) (*Syslog, error) {
+	u, err := url.Parse(arg)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("invalid syslog address %q, use e.g. tcp://localhost:601", arg)
+	}
+	query := u.Query()
+	opts := SyslogOptions{Network: u.Scheme, Address: u.Host}
+	if facility := query.Get("facility"); facility != "" {
+		n, err := strconv.Atoi(facility)
+		if err != nil {
+			return nil, fmt.Errorf("invalid syslog facility %q", facility)
+		}
+		opts.Facility = &n
+	}
+	if buffer := query.Get("buffer"); buffer != "" {
+		if opts.Buffer, err = strconv.Atoi(buffer); err != nil {
+			return nil, fmt.Errorf("invalid syslog buffer %q", buffer)
+		}
+	}
+	if ca := query.Get("ca"); ca != "" {
+		pem, err := os.ReadFile(ca)
+		if err != nil {
+			return nil, err
+		}
+		roots := x509.NewCertPool()
+		if !roots.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %v", ca)
+		}
+		opts.TLS = &tls.Config{RootCAs: roots}
+	}
+	return NewSyslog(opts)
+}
@@ -0,0 +1,128 @@
+package tui
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/farrjere/kube_watcher/kube"
+	"github.com/rivo/tview"
+)
+
+// maxPaneLines is how much scroll-back each pane keeps
+const maxPaneLines = 5000
+
+// mergedPane shows every pod's lines together
+const mergedPane = "all"
+
+// paneBuffer holds a pane's entries, so the filter can be changed and a paused pane caught up
+type paneBuffer struct {
+	name    string
+	max     int
+	entries []kube.LogEntry
+	paused  bool
+	// missed counts the lines that arrived while paused
+	missed int
+}
+
+func newPaneBuffer(name string, max int) *paneBuffer {
+	return &paneBuffer{name: name, max: max}
+}
+
+// add keeps the entry and reports whether it should be written to the view now
+func (b *paneBuffer) add(entry kube.LogEntry, filter string) bool {
+	b.entries = append(b.entries, entry)
+	if b.max > 0 && len(b.entries) > b.max {
+		b.entries = b.entries[len(b.entries)-b.max:]
+	}
+	if b.paused {
+		b.missed++
+		return false
+	}
+	return matches(entry, filter)
+}
+
+func (b *paneBuffer) visible(filter string) []kube.LogEntry {
+	visible := make([]kube.LogEntry, 0, len(b.entries))
+	for _, e := range b.entries {
+		if matches(e, filter) {
+			visible = append(visible, e)
+		}
+	}
+	return visible
+}
+
+func (b *paneBuffer) title() string {
+	if b.paused {
+		return fmt.Sprintf(" %v [paused, %v new] ", b.name, b.missed)
+	}
+	return fmt.Sprintf(" %v ", b.name)
+}
+
+func matches(entry kube.LogEntry, filter string) bool {
+	if filter == "" {
+		return true
+	}
+	return strings.Contains(strings.ToLower(entry.Message), strings.ToLower(filter)) ||
+		strings.Contains(strings.ToLower(entry.Pod), strings.ToLower(filter))
+}
+
+// formatEntry renders an entry with tview color tags, the merged pane prefixes the pod
+func formatEntry(entry kube.LogEntry, merged bool) string {
+	line := tview.Escape(entry.Line())
+	if merged {
+		line = "[::b]" + tview.Escape(entry.Pod) + "[::-] " + line
+	}
+	switch {
+	case entry.IsEvent() && entry.Level == "warning":
+		return "[yellow]" + line + "[-]"
+	case entry.IsEvent():
+		return "[aqua]" + line + "[-]"
+	}
+	return line
+}
+
+type pane struct {
+	buffer *paneBuffer
+	view   *tview.TextView
+	merged bool
+}
+
+func newPane(name string) *pane {
+	view := tview.NewTextView().SetDynamicColors(true).SetScrollable(true).SetMaxLines(maxPaneLines)
+	view.SetBorder(true).SetTitle(" " + name + " ")
+	return &pane{buffer: newPaneBuffer(name, maxPaneLines), view: view, merged: name == mergedPane}
+}
+
+// add must be called from the tview event loop
+func (p *pane) add(entry kube.LogEntry, filter string) {
+	if p.buffer.add(entry, filter) {
+		fmt.Fprintln(p.view, formatEntry(entry, p.merged))
+	}
+	if p.buffer.paused {
+		p.view.SetTitle(p.buffer.title())
+	}
+}
+
+// render redraws the pane from its buffer, used when the filter changes or the pane is resumed
+func (p *pane) render(filter string) {
+	p.view.Clear()
+	var b strings.Builder
+	for _, e := range p.buffer.visible(filter) {
+		b.WriteString(formatEntry(e, p.merged) + "\n")
+	}
+	p.view.SetText(b.String())
+	p.view.SetTitle(p.buffer.title())
+	if !p.buffer.paused {
+		p.view.ScrollToEnd()
+	}
+}
+
+func (p *pane) togglePause(filter string) {
+	p.buffer.paused = !p.buffer.paused
+	if !p.buffer.paused {
+		p.buffer.missed = 0
+		p.render(filter)
+		return
+	}
+	p.view.SetTitle(p.buffer.title())
+}
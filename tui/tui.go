@@ -0,0 +1,494 @@
+package tui
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/farrjere/kube_watcher/kube"
+	"github.com/gdamore/tcell/v2"
+	"github.com/rivo/tview"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+)
+
+const help = "[::b]/[::-] filter  [::b]?[::-] search  [::b]p[::-] pause  [::b]m[::-] merged  [::b]tab[::-] next pane  [::b]s[::-] save  [::b]esc[::-] deployments  [::b]q[::-] quit"
+
+type Options struct {
+	// Flags are how to connect, the context picker is skipped when they have a context
+	Flags *genericclioptions.ConfigFlags
+	// Namespace and Deployment skip their pickers when set
+	Namespace  string
+	Deployment string
+	// SavePath is the directory logs and search results are saved to
+	SavePath string
+	// Stream is the buffer and overflow policy of each pod's stream
+	Stream kube.SubscribeOptions
+	// Lines is how lines over the maximum line size are handled
+	Lines kube.LineOptions
+	// Redactor is applied to the logs and events shown and saved, nil to show them as they are
+	Redactor *kube.Redactor
+}
+
+type TUI struct {
+	opts    Options
+	app     *tview.Application
+	pages   *tview.Pages
+	input   *tview.InputField
+	status  *tview.TextView
+	logs    *tview.Flex
+	body    *tview.Flex
+	client  *kube.KubeClient
+	watcher *kube.DeploymentWatcher
+	cancel  context.CancelFunc
+
+	panes      map[string]*pane
+	order      []string
+	merged     *pane
+	showMerged bool
+	focused    int
+	filter     string
+	searchView *tview.TextView
+	results    []kube.SearchResult
+	query      string
+
+	// message and stats make up the status line, stats counts the lines streamed and dropped
+	message string
+	stats   string
+}
+
+// Run shows the terminal UI until the user quits.
+// Anything the kube package prints goes to a log file in the temp dir so it doesn't draw over the UI.
+func Run(opts Options) error {
+	logPath := filepath.Join(os.TempDir(), "kube_watcher-tui.log")
+	logFile, err := os.OpenFile(logPath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	defer logFile.Close()
+	stdout := os.Stdout
+	os.Stdout = logFile
+	defer func() { os.Stdout = stdout }()
+
+	t := New(opts)
+	t.start()
+	err = t.app.Run()
+	if t.cancel != nil {
+		t.cancel()
+	}
+	return err
+}
+
+func New(opts Options) *TUI {
+	if opts.Flags == nil {
+		opts.Flags = kube.NewConfigFlags("", "")
+	}
+	if opts.SavePath == "" {
+		opts.SavePath = "."
+	}
+	t := &TUI{opts: opts, app: tview.NewApplication(), pages: tview.NewPages(), panes: make(map[string]*pane)}
+	t.input = tview.NewInputField()
+	t.status = tview.NewTextView().SetDynamicColors(true).SetText(help)
+	t.body = tview.NewFlex()
+	t.logs = tview.NewFlex().SetDirection(tview.FlexRow).
+		AddItem(t.body, 0, 1, true).
+		AddItem(t.status, 1, 0, false)
+	t.logs.SetInputCapture(t.logKeys)
+	t.pages.AddPage("logs", t.logs, true, false)
+
+	t.searchView = tview.NewTextView().SetDynamicColors(true).SetScrollable(true)
+	t.searchView.SetBorder(true)
+	t.searchView.SetInputCapture(t.searchKeys)
+	t.pages.AddPage("search", t.searchView, true, false)
+	t.app.SetRoot(t.pages, true)
+	return t
+}
+
+func (t *TUI) start() {
+	if t.opts.Flags.Context == nil || *t.opts.Flags.Context == "" {
+		t.pickContext()
+		return
+	}
+	t.setContext(*t.opts.Flags.Context)
+}
+
+// pick shows a list to choose from, esc goes back to the previous picker if there is one
+func (t *TUI) pick(title string, items []string, onSelect func(string), onBack func()) {
+	sort.Strings(items)
+	list := tview.NewList().ShowSecondaryText(false)
+	list.SetBorder(true).SetTitle(" " + title + " ")
+	for _, item := range items {
+		item := item
+		list.AddItem(item, "", 0, func() { onSelect(item) })
+	}
+	list.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
+		switch {
+		case event.Key() == tcell.KeyEscape && onBack != nil:
+			onBack()
+			return nil
+		case event.Rune() == 'q':
+			t.app.Stop()
+			return nil
+		}
+		return event
+	})
+	t.pages.AddAndSwitchToPage("picker", list, true)
+}
+
+func (t *TUI) pickContext() {
+	t.pick("Context", kube.AvailableContexts(t.opts.Flags), t.setContext, nil)
+}
+
+func (t *TUI) setContext(name string) {
+	t.opts.Flags.Context = &name
+	client, err := kube.NewKubeClientFromFlags(t.opts.Flags)
+	if err != nil {
+		t.showError(fmt.Sprintf("unable to load context %v: %v", name, err), t.pickContext)
+		return
+	}
+	client.SetRedactor(t.opts.Redactor)
+	t.client = client
+	if t.opts.Namespace != "" {
+		namespace := t.opts.Namespace
+		t.opts.Namespace = ""
+		t.setNamespace(namespace)
+		return
+	}
+	t.pickNamespace()
+}
+
+func (t *TUI) pickNamespace() {
+	t.pick("Namespace", t.client.GetNamespaces(context.Background()), t.setNamespace, t.pickContext)
+}
+
+func (t *TUI) setNamespace(name string) {
+	t.client.SetNamespace(name)
+	if t.opts.Deployment != "" {
+		deployment := t.opts.Deployment
+		t.opts.Deployment = ""
+		t.watch(deployment)
+		return
+	}
+	t.pickDeployment()
+}
+
+func (t *TUI) pickDeployment() {
+	t.pick("Deployment", t.client.GetDeployments(context.Background()), t.watch, t.pickNamespace)
+}
+
+func (t *TUI) showError(message string, onDone func()) {
+	modal := tview.NewModal().SetText(message).AddButtons([]string{"OK"}).
+		SetDoneFunc(func(int, string) { onDone() })
+	t.pages.AddAndSwitchToPage("error", modal, true)
+}
+
+func (t *TUI) setStatus(message string) {
+	t.message = message
+	t.renderStatus()
+}
+
+func (t *TUI) setStats(stats []kube.StreamStats) {
+	var dropped int64
+	for _, s := range stats {
+		dropped += s.Dropped
+	}
+	t.stats = kube.FormatStreamStats(stats)
+	if dropped > 0 {
+		t.stats = "[yellow]" + tview.Escape(t.stats) + "[-]"
+	}
+	t.renderStatus()
+}
+
+func (t *TUI) renderStatus() {
+	parts := make([]string, 0, 3)
+	for _, part := range []string{t.message, t.stats, help} {
+		if part != "" {
+			parts = append(parts, part)
+		}
+	}
+	t.status.SetText(strings.Join(parts, "  "))
+}
+
+// watch streams the deployment into a pane per pod, plus one for events and the merged pane
+func (t *TUI) watch(deployment string) {
+	t.stopWatching()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.cancel = cancel
+	t.watcher = kube.NewDeploymentWatcher(deployment, t.client, ctx)
+	if err := t.watcher.SetLineOptions(t.opts.Lines); err != nil {
+		t.showError(err.Error(), t.pickDeployment)
+		return
+	}
+	if err := t.watcher.SetStreamOptions(t.opts.Stream); err != nil {
+		t.showError(err.Error(), t.pickDeployment)
+		return
+	}
+	t.stats = ""
+	t.panes = make(map[string]*pane)
+	t.order = nil
+	t.focused = 0
+	t.filter = ""
+	t.merged = newPane(mergedPane)
+	for _, name := range t.watcher.GetPods() {
+		t.addPane(name)
+	}
+	t.addPane(kube.EventsName)
+	t.setStatus(fmt.Sprintf("[green]%v/%v[-]", t.client.Namespace(), deployment))
+	t.pages.SwitchToPage("logs")
+	t.layout()
+
+	watcher := t.watcher
+	entries := watcher.Stream()
+	go func() {
+		for entry := range entries {
+			entry := entry
+			t.app.QueueUpdateDraw(func() {
+				// lines still queued from a deployment we have moved on from
+				if t.watcher == watcher {
+					t.addEntry(entry)
+				}
+			})
+		}
+	}()
+	go func() {
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				stats := watcher.StreamStats()
+				t.app.QueueUpdateDraw(func() {
+					if t.watcher == watcher {
+						t.setStats(stats)
+					}
+				})
+			}
+		}
+	}()
+}
+
+func (t *TUI) stopWatching() {
+	if t.cancel != nil {
+		t.cancel()
+		t.cancel = nil
+	}
+}
+
+func (t *TUI) addPane(name string) *pane {
+	p := newPane(name)
+	t.panes[name] = p
+	t.order = append(t.order, name)
+	sort.SliceStable(t.order, func(i, j int) bool {
+		// events stay last
+		if t.order[i] == kube.EventsName || t.order[j] == kube.EventsName {
+			return t.order[j] == kube.EventsName && t.order[i] != kube.EventsName
+		}
+		return t.order[i] < t.order[j]
+	})
+	return p
+}
+
+func (t *TUI) addEntry(entry kube.LogEntry) {
+	p, ok := t.panes[entry.Pod]
+	if !ok {
+		// a pod from a rollout or restart
+		p = t.addPane(entry.Pod)
+		p.render(t.filter)
+		t.layout()
+	}
+	p.add(entry, t.filter)
+	t.merged.add(entry, t.filter)
+}
+
+func (t *TUI) visiblePanes() []*pane {
+	if t.showMerged {
+		return []*pane{t.merged}
+	}
+	panes := make([]*pane, len(t.order))
+	for i, name := range t.order {
+		panes[i] = t.panes[name]
+	}
+	return panes
+}
+
+func (t *TUI) layout() {
+	t.body.Clear()
+	panes := t.visiblePanes()
+	for _, p := range panes {
+		t.body.AddItem(p.view, 0, 1, false)
+	}
+	if t.focused >= len(panes) {
+		t.focused = 0
+	}
+	front, _ := t.pages.GetFrontPage()
+	if len(panes) > 0 && front == "logs" && t.app.GetFocus() != t.input {
+		t.app.SetFocus(panes[t.focused].view)
+	}
+}
+
+func (t *TUI) focusedPane() *pane {
+	panes := t.visiblePanes()
+	if len(panes) == 0 {
+		return nil
+	}
+	return panes[t.focused%len(panes)]
+}
+
+func (t *TUI) renderAll() {
+	for _, p := range t.panes {
+		p.render(t.filter)
+	}
+	t.merged.render(t.filter)
+}
+
+func (t *TUI) logKeys(event *tcell.EventKey) *tcell.EventKey {
+	if t.app.GetFocus() == t.input {
+		return event
+	}
+	switch event.Key() {
+	case tcell.KeyTab:
+		t.focused = (t.focused + 1) % len(t.visiblePanes())
+		t.app.SetFocus(t.focusedPane().view)
+		return nil
+	case tcell.KeyBacktab:
+		n := len(t.visiblePanes())
+		t.focused = (t.focused + n - 1) % n
+		t.app.SetFocus(t.focusedPane().view)
+		return nil
+	case tcell.KeyEscape:
+		t.stopWatching()
+		t.pickDeployment()
+		return nil
+	}
+	switch event.Rune() {
+	case '/':
+		t.prompt("filter: ", t.filter, func(text string) {
+			t.filter = text
+			t.renderAll()
+		}, nil)
+		return nil
+	case '?':
+		t.prompt("search: ", "", nil, t.search)
+		return nil
+	case 'p':
+		if p := t.focusedPane(); p != nil {
+			p.togglePause(t.filter)
+		}
+		return nil
+	case 'm':
+		t.showMerged = !t.showMerged
+		t.focused = 0
+		t.layout()
+		return nil
+	case 's':
+		t.save()
+		return nil
+	case 'q':
+		t.app.Stop()
+		return nil
+	}
+	return event
+}
+
+// prompt takes over the status line, onChange fires on every key and onDone once enter is pressed
+func (t *TUI) prompt(label string, text string, onChange func(string), onDone func(string)) {
+	t.input.SetLabel(label).SetText(text)
+	t.input.SetChangedFunc(onChange)
+	t.input.SetDoneFunc(func(key tcell.Key) {
+		value := t.input.GetText()
+		t.swapStatus(t.input, t.status)
+		if p := t.focusedPane(); p != nil {
+			t.app.SetFocus(p.view)
+		}
+		if key == tcell.KeyEnter && onDone != nil {
+			onDone(value)
+		}
+	})
+	t.swapStatus(t.status, t.input)
+	t.app.SetFocus(t.input)
+}
+
+func (t *TUI) swapStatus(old tview.Primitive, replacement tview.Primitive) {
+	t.logs.RemoveItem(old)
+	t.logs.AddItem(replacement, 1, 0, false)
+}
+
+// save downloads every pod's logs in the background, the result is shown once it is done
+func (t *TUI) save() {
+	t.setStatus("saving logs...")
+	watcher := t.watcher
+	go func() {
+		path, err := watcher.Export(t.opts.SavePath, 0, kube.ExportOptions{Format: kube.FormatText})
+		t.app.QueueUpdateDraw(func() {
+			if err != nil {
+				t.setStatus(fmt.Sprintf("[red]unable to save logs: %v[-]", tview.Escape(err.Error())))
+				return
+			}
+			t.setStatus(fmt.Sprintf("[green]saved logs to %v[-]", tview.Escape(path)))
+		})
+	}()
+}
+
+func (t *TUI) search(query string) {
+	if query == "" {
+		return
+	}
+	t.setStatus(fmt.Sprintf("searching for %q...", tview.Escape(query)))
+	watcher := t.watcher
+	go func() {
+		results := watcher.SearchLogs(kube.SearchParameters{Query: query, AllContainers: true, Limit: 1000})
+		t.app.QueueUpdateDraw(func() { t.showResults(query, results) })
+	}()
+}
+
+func (t *TUI) showResults(query string, results []kube.SearchResult) {
+	t.query = query
+	t.results = results
+	sort.Slice(results, func(i, j int) bool { return results[i].PodName < results[j].PodName })
+	var b strings.Builder
+	total := 0
+	for _, res := range results {
+		if len(res.Matches) == 0 {
+			continue
+		}
+		total += len(res.Matches)
+		fmt.Fprintf(&b, "[::b]%v[::-] (%v)\n", tview.Escape(res.PodName), len(res.Matches))
+		for _, m := range res.Matches {
+			b.WriteString("  " + tview.Escape(m) + "\n")
+		}
+		b.WriteString("\n")
+	}
+	t.searchView.SetTitle(fmt.Sprintf(" %v matches for %q  [::b]s[::-] save  [::b]esc[::-] back ", total, tview.Escape(query)))
+	t.searchView.SetText(b.String()).ScrollToBeginning()
+	t.setStatus("")
+	t.pages.SwitchToPage("search")
+	t.app.SetFocus(t.searchView)
+}
+
+func (t *TUI) searchKeys(event *tcell.EventKey) *tcell.EventKey {
+	switch {
+	case event.Key() == tcell.KeyEscape:
+		t.pages.SwitchToPage("logs")
+		t.layout()
+		return nil
+	case event.Rune() == 's':
+		path, err := kube.WriteExport(t.opts.SavePath, t.watcher.SearchResultFiles(t.results), t.watcher.Manifest(t.query), kube.ExportOptions{Format: kube.FormatText})
+		message := fmt.Sprintf("[green]saved results to %v[-]", tview.Escape(path))
+		if err != nil {
+			message = fmt.Sprintf("[red]unable to save results: %v[-]", tview.Escape(err.Error()))
+		}
+		t.setStatus(message)
+		t.pages.SwitchToPage("logs")
+		t.layout()
+		return nil
+	case event.Rune() == 'q':
+		t.app.Stop()
+		return nil
+	}
+	return event
+}
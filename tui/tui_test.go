@@ -0,0 +1,81 @@
+package tui
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/farrjere/kube_watcher/kube"
+)
+
+func entry(pod string, message string) kube.LogEntry {
+	return kube.LogEntry{Pod: pod, Kind: kube.EntryLog, Message: message}
+}
+
+func TestPaneBuffer(t *testing.T) {
+	b := newPaneBuffer("web-1", 3)
+	for i := 0; i < 5; i++ {
+		if !b.add(entry("web-1", fmt.Sprintf("line %v", i)), "") {
+			t.Errorf("expected line %v to be shown", i)
+		}
+	}
+	if len(b.entries) != 3 || b.entries[0].Message != "line 2" {
+		t.Errorf("expected only the last 3 lines kept got %v", b.entries)
+	}
+	if b.add(entry("web-1", "skipped"), "line") {
+		t.Errorf("expected a line not matching the filter to be hidden")
+	}
+
+	b.paused = true
+	if b.add(entry("web-1", "line 5"), "") {
+		t.Errorf("expected nothing shown while paused")
+	}
+	if b.missed != 1 || !strings.Contains(b.title(), "paused, 1 new") {
+		t.Errorf("expected the missed line counted got %v", b.title())
+	}
+	visible := b.visible("LINE")
+	if len(visible) != 2 || visible[1].Message != "line 5" {
+		t.Errorf("expected the filter to be case insensitive and include paused lines got %v", visible)
+	}
+}
+
+func TestAddEntry(t *testing.T) {
+	ui := New(Options{})
+	ui.merged = newPane(mergedPane)
+	ui.addPane("web-1")
+	ui.addPane(kube.EventsName)
+
+	ui.addEntry(entry("web-1", "started"))
+	ui.addEntry(entry("web-2", "new pod [not a color tag]"))
+	ui.addEntry(kube.LogEntry{Pod: kube.EventsName, Kind: kube.EntryEvent, Level: "warning", Message: "Warning BackOff"})
+
+	if strings.Join(ui.order, ",") != "web-1,web-2,events" {
+		t.Errorf("expected the new pod's pane before events got %v", ui.order)
+	}
+	if text := ui.panes["web-2"].view.GetText(true); !strings.Contains(text, "new pod [not a color tag]") {
+		t.Errorf("expected the log line unchanged got %q", text)
+	}
+	if len(ui.merged.buffer.entries) != 3 {
+		t.Errorf("expected every entry in the merged pane got %v", ui.merged.buffer.entries)
+	}
+
+	ui.filter = "started"
+	ui.renderAll()
+	if text := ui.merged.view.GetText(true); strings.Contains(text, "new pod") || !strings.Contains(text, "web-1 started") {
+		t.Errorf("expected the merged pane filtered to the matching line got %q", text)
+	}
+}
+
+func TestStatusLine(t *testing.T) {
+	ui := New(Options{})
+	ui.setStatus("web/api")
+	ui.setStats([]kube.StreamStats{{Pod: "web-1", Received: 5, Delivered: 3, Dropped: 2}})
+	text := ui.status.GetText(true)
+	if !strings.HasPrefix(text, "web/api  5 received, 3 delivered, 2 dropped (web-1 2)") {
+		t.Errorf("expected the drops in the status line got %q", text)
+	}
+	ui.setStatus("")
+	if text = ui.status.GetText(true); !strings.HasPrefix(text, "5 received") {
+		t.Errorf("expected the stats kept after the message cleared got %q", text)
+	}
+}